@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base32"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sempernow/kit/id"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/pkg/errors"
+)
+
+// ****************************************************************************
+// API keys of machine clients (e.g., of RoleSvcAPI services):
+//
+//	<prefix>_<id>_<secret>     e.g., "sk_HXQ4CFM7V9J2PW8R_..."
+//
+// The prefix marks the kind of key; it aids secret scanners and humans.
+// The id indexes the key at its store. The secret is stored only as
+// its hash, id.SumBlake3_256(secret); a fast hash suffices for a secret
+// of 256 random bits. The key itself is shown but once, upon generation.
+// ****************************************************************************
+
+var (
+	// ErrAPIKeyNotFound is returned by an APIKeyStore of an unknown key id.
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	// ErrAPIKeyInvalid is of a malformed, unknown, expired or mismatched key.
+	ErrAPIKeyInvalid = errors.New("api key invalid")
+)
+
+var apiKeyEncoding = base32.NewEncoding(id.WordSafe).WithPadding(base32.NoPadding)
+
+// APIKey is the stored record of an API key; it holds the hash of its secret, not the secret.
+type APIKey struct {
+	ID        string    `json:"id" db:"id"`
+	Hash      string    `json:"-" db:"hash"`
+	Name      string    `json:"name" db:"name"`
+	Subject   string    `json:"subject" db:"subject"`
+	Roles     []string  `json:"roles" db:"roles"`
+	Scopes    []string  `json:"scopes" db:"scopes"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitempty" db:"expires_at"` // Zero if never.
+}
+
+// APIKeyStore looks up API keys by id; ErrAPIKeyNotFound if none.
+type APIKeyStore interface {
+	LookupAPIKey(ctx context.Context, id string) (APIKey, error)
+}
+
+// APIKeys generates and authenticates API keys of a prefix, per store.
+type APIKeys struct {
+	prefix string
+	store  APIKeyStore
+}
+
+// NewAPIKeys returns the APIKeys of prefix (e.g., "sk"; sans "_") per store.
+func NewAPIKeys(prefix string, store APIKeyStore) (*APIKeys, error) {
+	if prefix == "" || strings.ContainsAny(prefix, "_ ") {
+		return nil, errors.Errorf("api keys : invalid prefix %q", prefix)
+	}
+	if store == nil {
+		return nil, errors.New("api keys : store cannot be nil")
+	}
+	return &APIKeys{prefix: prefix, store: store}, nil
+}
+
+// Generate returns a new key and its record (rec) of fields per template (tmpl);
+// Name, Subject, Roles, Scopes and ExpiresAt. Persist rec; give the key to its client.
+func (k *APIKeys) Generate(tmpl APIKey) (key string, rec APIKey, err error) {
+	secret, err := id.Nonce(32)
+	if err != nil {
+		return "", APIKey{}, errors.Wrap(err, "api keys : generate")
+	}
+	rec = tmpl
+	rec.ID = id.Base32(id.WordSafe)[:16]
+	rec.CreatedAt = time.Now().UTC()
+	rec.Hash = id.SumBlake3_256(apiKeyEncoding.EncodeToString(secret))
+
+	key = k.prefix + "_" + rec.ID + "_" + apiKeyEncoding.EncodeToString(secret)
+	return key, rec, nil
+}
+
+// Is reports whether key is of the prefix; not whether it is valid.
+func (k *APIKeys) Is(key string) bool {
+	return strings.HasPrefix(key, k.prefix+"_")
+}
+
+// Authenticate returns the claims of key; those of an Access token per API access mode.
+// The claims are of the key's Subject, Roles and Scopes (scope claim),
+// and of jti and key claims of its id.
+func (k *APIKeys) Authenticate(ctx context.Context, key string) (Claims, error) {
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) != 3 || parts[0] != k.prefix || parts[1] == "" || parts[2] == "" {
+		return Claims{}, ErrAPIKeyInvalid
+	}
+	rec, err := k.store.LookupAPIKey(ctx, parts[1])
+	if err != nil {
+		if errors.Cause(err) == ErrAPIKeyNotFound {
+			return Claims{}, ErrAPIKeyInvalid
+		}
+		return Claims{}, errors.Wrap(err, "api keys : lookup")
+	}
+	if subtle.ConstantTimeCompare([]byte(rec.Hash), []byte(id.SumBlake3_256(parts[2]))) != 1 {
+		return Claims{}, ErrAPIKeyInvalid
+	}
+	now := time.Now()
+	if !rec.ExpiresAt.IsZero() && !now.Before(rec.ExpiresAt) {
+		return Claims{}, errors.Wrap(ErrAPIKeyInvalid, "expired")
+	}
+
+	claims := Claims{
+		StandardClaims: jwt.StandardClaims{
+			Id:       rec.ID,
+			Subject:  rec.Subject,
+			Issuer:   Issuer(API),
+			IssuedAt: rec.CreatedAt.Unix(),
+		},
+		Roles:     rec.Roles,
+		TokenType: Access,
+		Key:       rec.ID,
+		Scope:     strings.Join(rec.Scopes, " "),
+	}
+	if !rec.ExpiresAt.IsZero() {
+		claims.ExpiresAt = rec.ExpiresAt.Unix()
+	}
+	return claims, nil
+}
+
+// ----------------------------------------------------------------------------
+// MemoryAPIKeyStore
+
+// MemoryAPIKeyStore is an in-process APIKeyStore; for tests and static configuration.
+type MemoryAPIKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]APIKey
+}
+
+// NewMemoryAPIKeyStore returns a MemoryAPIKeyStore of records (recs).
+func NewMemoryAPIKeyStore(recs ...APIKey) *MemoryAPIKeyStore {
+	s := MemoryAPIKeyStore{keys: make(map[string]APIKey, len(recs))}
+	for _, rec := range recs {
+		s.keys[rec.ID] = rec
+	}
+	return &s
+}
+
+// Put adds or replaces a record.
+func (s *MemoryAPIKeyStore) Put(rec APIKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[rec.ID] = rec
+}
+
+// Delete removes the record of id; its key is no longer valid.
+func (s *MemoryAPIKeyStore) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, id)
+}
+
+// LookupAPIKey returns the record of id.
+func (s *MemoryAPIKeyStore) LookupAPIKey(ctx context.Context, id string) (APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rec, ok := s.keys[id]
+	if !ok {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+	return rec, nil
+}
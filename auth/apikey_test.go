@@ -0,0 +1,55 @@
+package auth_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sempernow/kit/auth"
+	"github.com/sempernow/kit/testkit"
+
+	"github.com/pkg/errors"
+)
+
+func TestAPIKeys(t *testing.T) {
+	store := auth.NewMemoryAPIKeyStore()
+	keys, err := auth.NewAPIKeys("sk", store)
+	testkit.Log(t, "New API keys", err)
+
+	key, rec, err := keys.Generate(auth.APIKey{
+		Name:    "reports",
+		Subject: "svc-reports",
+		Roles:   []string{auth.RoleSvcAPI},
+		Scopes:  []string{"reports:read", "reports:write"},
+	})
+	testkit.Log(t, "Generate", err)
+	store.Put(rec)
+
+	testkit.LogDiff(t, "Prefixed", strings.HasPrefix(key, "sk_"+rec.ID+"_"), true)
+	testkit.LogDiff(t, "Secret not stored", strings.Contains(key, rec.Hash), false)
+
+	claims, err := keys.Authenticate(testkit.Context(), key)
+	testkit.Log(t, "Authenticate", err)
+	testkit.LogDiff(t, "Subject", claims.Subject, "svc-reports")
+	testkit.LogDiff(t, "Roles", claims.Has(auth.RoleSvcAPI), true)
+	testkit.LogDiff(t, "Scope", claims.Scope, "reports:read reports:write")
+	testkit.LogDiff(t, "Token type", claims.TokenType, auth.Access)
+
+	invalid := func(key string) bool {
+		_, err := keys.Authenticate(testkit.Context(), key)
+		return errors.Cause(err) == auth.ErrAPIKeyInvalid
+	}
+	testkit.LogDiff(t, "Wrong secret", invalid(key[:len(key)-1]+"X"), true)
+	testkit.LogDiff(t, "Wrong prefix", invalid("pk"+key[2:]), true)
+	testkit.LogDiff(t, "Malformed", invalid("sk_"+rec.ID), true)
+
+	rec.ExpiresAt = time.Now().Add(-time.Second)
+	store.Put(rec)
+	testkit.LogDiff(t, "Expired", invalid(key), true)
+
+	store.Delete(rec.ID)
+	testkit.LogDiff(t, "Deleted", invalid(key), true)
+
+	_, err = auth.NewAPIKeys("s_k", store)
+	testkit.LogDiff(t, "Invalid prefix refused", err != nil, true)
+}
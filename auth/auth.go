@@ -2,6 +2,10 @@
 package auth
 
 import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"fmt"
 	"net/http"
@@ -173,12 +177,6 @@ type TokenPair struct {
 	Provider string `json:"provider,omitempty"`
 }
 
-// ctxKey represents the type of value for the context key.
-type ctxKey int
-
-// Key1 is used for protected store/retrieve of Claims VALUEs from a `context.Context`.
-const Key1 ctxKey = 1
-
 // Claims represents the authorization claims of a bearer token (JSON Web Token; JWT).
 // https://tools.ietf.org/html/rfc7519#section-4.1 | RFC7519
 type Claims struct {
@@ -186,6 +184,7 @@ type Claims struct {
 	Roles     []string `json:"roles"`
 	TokenType string   `json:"tokenType"`
 	Key       string   `json:"key"`
+	Scope     string   `json:"scope,omitempty"` // Space-delimited; see Policy.
 	//RemoteOrigin string   `json:"remote_origin,omitempty"`
 	//ChnID        string   `json:"chn_id,omitempty"`
 	//ChnSlug      string   `json:"chn_slug,omitempty"`
@@ -243,11 +242,16 @@ func (c Claims) Has(roles ...string) bool {
 //
 // * KID to public key resolution is usually accomplished via a public JWKS
 // endpoint. See https://auth0.com/docs/jwks for more details.
-type PubKeyLookup func(kid string) (*rsa.PublicKey, error)
+//
+// KeySet.Lookup is a PubKeyLookup of keys that rotate.
+//
+// The key is *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey,
+// per the algorithm of the Auth.
+type PubKeyLookup func(kid string) (crypto.PublicKey, error)
 
 // JWKS (JSON Web Key Set) function returns a PubKeyLookup
-func JWKS(activeKID string, publicKey *rsa.PublicKey) PubKeyLookup {
-	f := func(kid string) (*rsa.PublicKey, error) {
+func JWKS(activeKID string, publicKey crypto.PublicKey) PubKeyLookup {
+	f := func(kid string) (crypto.PublicKey, error) {
 		if activeKID != kid {
 			return nil, fmt.Errorf("unrecognized key id %q", kid)
 		}
@@ -259,25 +263,51 @@ func JWKS(activeKID string, publicKey *rsa.PublicKey) PubKeyLookup {
 
 // Auth contains the `New(..)` authenticator's parameters; used to authenticate clients.
 type Auth struct {
-	privateKey          *rsa.PrivateKey
+	privateKey          crypto.Signer
 	activeKID           string
 	algorithm           string
 	keyFunc             PubKeyLookup
+	keys                *KeySet
 	parser              *jwt.Parser
+	refresh             RefreshStore
+	revocation          RevocationStore
+	v                   validator
+	accessTTL           time.Duration
+	refreshTTL          time.Duration
 	cookieKeyTknRefresh string
 	cookieKeyTknAccess  string
 }
 
 // New creates an authenticator (`*Auth`) used to generate a token (JWT)
 // for a set of user claims and recreate the claims by parsing the token.
+// The private key is any crypto.Signer of the algorithm's kind;
+// *rsa.PrivateKey (RS256, PS256, ...), *ecdsa.PrivateKey (ES256, ...),
+// ed25519.PrivateKey (EdDSA), or such a key held by an HSM or KMS.
 // It will error if:
 //   - The private key is nil
 //   - The public key func is nil.
 //   - The key ID is blank.
 //   - The specified algorithm is unsupported.
-func New(privateKey *rsa.PrivateKey, activeKID, algorithm string, lookup PubKeyLookup) (*Auth, error) {
-	if privateKey == nil {
+//   - The private key does not fit the algorithm.
+//
+// Options (opts) configure the refresh flow (see IssuePair(..)), revocation,
+// and the validation of claims; e.g., WithAudience(..), WithLeeway(..).
+func New(privateKey crypto.Signer, activeKID, algorithm string, lookup PubKeyLookup, opts ...Option) (*Auth, error) {
+	switch k := privateKey.(type) {
+	case nil:
 		return nil, errors.New("private key cannot be nil")
+	case *rsa.PrivateKey:
+		if k == nil {
+			return nil, errors.New("private key cannot be nil")
+		}
+	case *ecdsa.PrivateKey:
+		if k == nil {
+			return nil, errors.New("private key cannot be nil")
+		}
+	case ed25519.PrivateKey:
+		if len(k) != ed25519.PrivateKeySize {
+			return nil, errors.New("private key cannot be nil")
+		}
 	}
 	if activeKID == "" {
 		return nil, errors.New("active kid cannot be blank")
@@ -285,6 +315,9 @@ func New(privateKey *rsa.PrivateKey, activeKID, algorithm string, lookup PubKeyL
 	if jwt.GetSigningMethod(algorithm) == nil {
 		return nil, errors.Errorf("unknown algorithm %v", algorithm)
 	}
+	if err := checkKey(algorithm, privateKey.Public()); err != nil {
+		return nil, errors.Wrap(err, "private key")
+	}
 	if lookup == nil {
 		return nil, errors.New("public key function cannot be nil")
 	}
@@ -292,8 +325,10 @@ func New(privateKey *rsa.PrivateKey, activeKID, algorithm string, lookup PubKeyL
 	// Create the token (JWT) parser to use. The algorithm used to sign the JWT must be
 	// validated to avoid a critical vulnerability:
 	// https://auth0.com/blog/critical-vulnerabilities-in-json-web-token-libraries/
+	// Claims are validated per options of the Auth (see validator), not per jwt.
 	parser := jwt.Parser{
-		ValidMethods: []string{algorithm},
+		ValidMethods:         []string{algorithm},
+		SkipClaimsValidation: true,
 	}
 
 	// Authenticator
@@ -304,10 +339,41 @@ func New(privateKey *rsa.PrivateKey, activeKID, algorithm string, lookup PubKeyL
 		keyFunc:    lookup,
 		parser:     &parser,
 	}
+	if err := a.configure(opts); err != nil {
+		return nil, err
+	}
 
 	return &a, nil
 }
 
+// NewWithKeySet creates an authenticator (`*Auth`) that signs per the active key of ks,
+// and validates per any of its keys; rotations of ks take effect sans restart.
+func NewWithKeySet(ks *KeySet, opts ...Option) (*Auth, error) {
+	if ks == nil {
+		return nil, errors.New("key set cannot be nil")
+	}
+	a := Auth{
+		algorithm: ks.Algorithm(),
+		keyFunc:   ks.Lookup,
+		keys:      ks,
+		parser:    &jwt.Parser{ValidMethods: []string{ks.Algorithm()}, SkipClaimsValidation: true},
+	}
+	if err := a.configure(opts); err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// configure applies options over defaults.
+func (a *Auth) configure(opts []Option) error {
+	a.accessTTL, a.refreshTTL = AccessTTL, RefreshTTL
+	for _, opt := range opts {
+		opt(a)
+	}
+	return errors.Wrap(a.v.check(), "options")
+}
+
 // GenerateToken generates a signed token (JWT) string representing the user Claims.
 // **************************************************************************************
 // TODO: Per KeyID; see `modelskit.Token(..)`; it's commented out; taken from service-5.
@@ -315,10 +381,19 @@ func New(privateKey *rsa.PrivateKey, activeKID, algorithm string, lookup PubKeyL
 func (a *Auth) GenerateToken(claims Claims) (string, error) {
 	method := jwt.GetSigningMethod(a.algorithm)
 
+	kid, key := a.activeKID, a.privateKey
+	if a.keys != nil {
+		k, err := a.keys.Active()
+		if err != nil {
+			return "", errors.Wrap(err, "signing token")
+		}
+		kid, key = k.KID, k.Private
+	}
+
 	tkn := jwt.NewWithClaims(method, claims)
-	tkn.Header["kid"] = a.activeKID
+	tkn.Header["kid"] = kid
 
-	str, err := tkn.SignedString(a.privateKey)
+	str, err := sign(tkn, key)
 	if err != nil {
 		return "", errors.Wrap(err, "signing token")
 	}
@@ -329,7 +404,15 @@ func (a *Auth) GenerateToken(claims Claims) (string, error) {
 // ValidateToken recreates the Claims from whence the token was created.
 // It verifies that the token was signed using the key identified therein.
 // If invalid, returns: `nil, err`; reason per error message.
+// See ValidateTokenContext(..) to consult the revocation store, if any.
 func (a *Auth) ValidateToken(tokenStr string) (Claims, error) {
+	return a.ValidateTokenContext(context.Background(), tokenStr)
+}
+
+// ValidateTokenContext is ValidateToken(..) that also consults the revocation store (WithRevocation);
+// error ErrRevoked if the token is denied, or that of the store if it fails; ErrRevocationUnavailable
+// if it could not be reached.
+func (a *Auth) ValidateTokenContext(ctx context.Context, tokenStr string) (Claims, error) {
 
 	// `getPubKey` DEFINES a function that, WHEN INVOKED, RETURNs the PUBLIC KEY
 	// per key ID (`kid`); invoking `PubKeyLookup` function, `keyFunc(ID)`.
@@ -357,6 +440,23 @@ func (a *Auth) ValidateToken(tokenStr string) (Claims, error) {
 		return Claims{}, errors.New("invalid token")
 	}
 
+	if err := a.v.validate(claims, time.Now()); err != nil {
+		return Claims{}, errors.Wrap(err, "validating claims")
+	}
+	if err := a.v.validateCustom(token); err != nil {
+		return Claims{}, errors.Wrap(err, "validating claims")
+	}
+
+	if a.revocation != nil {
+		revoked, err := a.revocation.Revoked(ctx, claims)
+		if err != nil {
+			return Claims{}, errors.Wrap(err, "consulting revocation store")
+		}
+		if revoked {
+			return Claims{}, ErrRevoked
+		}
+	}
+
 	return claims, nil
 }
 
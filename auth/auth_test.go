@@ -1,6 +1,7 @@
 package auth_test
 
 import (
+	"crypto"
 	"crypto/rsa"
 	"errors"
 	"fmt"
@@ -47,7 +48,7 @@ func TestAuthenticator(t *testing.T) {
 	publicKey, err := jwt.ParseRSAPublicKeyFromPEM([]byte(pubPEM))
 	testkit.Log(t, "Parse PEM-encoded public key", err)
 
-	keyLookupFunc := func(kid string) (*rsa.PublicKey, error) {
+	keyLookupFunc := func(kid string) (crypto.PublicKey, error) {
 		if kid != KID {
 			return nil, errors.New("no public key found")
 		}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// ****************************************************************************
+// CBOR (RFC 8949) decoding of the subset used by WebAuthn (CTAP2 canonical):
+// definite-length items only; of major types uint, negint, bytes, text,
+// array, map, tag (content only), and the simple values false, true and null.
+//
+//	uint, negint : int64
+//	bytes        : []byte
+//	text         : string
+//	array        : []interface{}
+//	map          : map[interface{}]interface{} (keys of int64 or string)
+// ****************************************************************************
+
+// cborMaxDepth bounds the nesting of decoded items.
+const cborMaxDepth = 16
+
+var errCBOR = errors.New("cbor : malformed")
+
+// decodeCBOR returns the first item of bb, and the bytes that follow it.
+func decodeCBOR(bb []byte) (interface{}, []byte, error) {
+	return cborItem(bb, 0)
+}
+
+func cborItem(bb []byte, depth int) (interface{}, []byte, error) {
+	if depth > cborMaxDepth {
+		return nil, nil, errors.Wrap(errCBOR, "nested too deep")
+	}
+	if len(bb) == 0 {
+		return nil, nil, errors.Wrap(errCBOR, "unexpected end")
+	}
+	major, info := bb[0]>>5, bb[0]&0x1f
+	bb = bb[1:]
+
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, bb, nil
+		case 21:
+			return true, bb, nil
+		case 22:
+			return nil, bb, nil
+		}
+		return nil, nil, errors.Wrapf(errCBOR, "unsupported simple value %d", info)
+	}
+
+	var n uint64
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info <= 27:
+		size := 1 << (info - 24)
+		if len(bb) < size {
+			return nil, nil, errors.Wrap(errCBOR, "unexpected end")
+		}
+		for _, b := range bb[:size] {
+			n = n<<8 | uint64(b)
+		}
+		bb = bb[size:]
+	default:
+		return nil, nil, errors.Wrap(errCBOR, "indefinite length not supported")
+	}
+
+	switch major {
+	case 0:
+		if n > math.MaxInt64 {
+			return nil, nil, errors.Wrap(errCBOR, "integer overflow")
+		}
+		return int64(n), bb, nil
+	case 1:
+		if n > math.MaxInt64 {
+			return nil, nil, errors.Wrap(errCBOR, "integer overflow")
+		}
+		return -1 - int64(n), bb, nil
+	case 2, 3:
+		if n > uint64(len(bb)) {
+			return nil, nil, errors.Wrap(errCBOR, "unexpected end")
+		}
+		if major == 2 {
+			return append([]byte(nil), bb[:n]...), bb[n:], nil
+		}
+		return string(bb[:n]), bb[n:], nil
+	case 4:
+		if n > uint64(len(bb)) { //... each item is at least a byte.
+			return nil, nil, errors.Wrap(errCBOR, "unexpected end")
+		}
+		arr := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			var (
+				v   interface{}
+				err error
+			)
+			if v, bb, err = cborItem(bb, depth+1); err != nil {
+				return nil, nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, bb, nil
+	case 5:
+		if n > uint64(len(bb))/2 {
+			return nil, nil, errors.Wrap(errCBOR, "unexpected end")
+		}
+		m := make(map[interface{}]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			var (
+				k, v interface{}
+				err  error
+			)
+			if k, bb, err = cborItem(bb, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errors.Wrap(errCBOR, "map key of neither integer nor text")
+			}
+			if _, dup := m[k]; dup {
+				return nil, nil, errors.Wrap(errCBOR, "duplicate map key")
+			}
+			if v, bb, err = cborItem(bb, depth+1); err != nil {
+				return nil, nil, err
+			}
+			m[k] = v
+		}
+		return m, bb, nil
+	case 6:
+		return cborItem(bb, depth+1)
+	}
+	return nil, nil, errCBOR
+}
+
+// cborMap returns the map of item, else error.
+func cborMap(item interface{}) (map[interface{}]interface{}, error) {
+	m, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.Wrap(errCBOR, "expected map")
+	}
+	return m, nil
+}
+
+// cborBytes returns the byte string of m at key, else error.
+func cborBytes(m map[interface{}]interface{}, key interface{}) ([]byte, error) {
+	bb, ok := m[key].([]byte)
+	if !ok {
+		return nil, errors.Wrapf(errCBOR, "expected bytes at %v", key)
+	}
+	return bb, nil
+}
+
+// cborInt returns the integer of m at key, else error.
+func cborInt(m map[interface{}]interface{}, key interface{}) (int64, error) {
+	n, ok := m[key].(int64)
+	if !ok {
+		return 0, errors.Wrapf(errCBOR, "expected integer at %v", key)
+	}
+	return n, nil
+}
@@ -0,0 +1,38 @@
+package auth
+
+import "context"
+
+// ctxKey represents the type of value for the context key.
+type ctxKey int
+
+// claimsKey is the context key of the bearer's Claims; unexported, so collision free.
+const claimsKey ctxKey = 1
+
+// WithClaims returns a copy of ctx carrying claims; as do the authenticating
+// middlewares (mid.ValidToken, mid.APIKey, ...) upon success.
+func WithClaims(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, claimsKey, claims)
+}
+
+// FromContext returns the claims of ctx; false if none, as of a request
+// not (yet) authenticated.
+//
+//	claims, ok := auth.FromContext(ctx)
+//	if !ok {
+//		return web.NewShutdownError("context : missing claims")
+//	}
+func FromContext(ctx context.Context) (Claims, bool) {
+	c, ok := ctx.Value(claimsKey).(Claims)
+	return c, ok
+}
+
+// Visitor returns the claims of an anonymous visitor (RoleUsrVis);
+// those of a request admitted sans token per mid.OptionalToken(..).
+func Visitor() Claims {
+	return Claims{Roles: []string{RoleUsrVis}}
+}
+
+// IsVisitor reports whether claims are those of an anonymous visitor; sans subject.
+func (c Claims) IsVisitor() bool {
+	return c.Subject == "" && c.Has(RoleUsrVis)
+}
@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// COSE algorithms (RFC 9053) of WebAuthn credentials and attestations.
+// https://www.iana.org/assignments/cose/cose.xhtml#algorithms
+const (
+	COSEAlgES256 int64 = -7
+	COSEAlgES384 int64 = -35
+	COSEAlgES512 int64 = -36
+	COSEAlgEdDSA int64 = -8
+	COSEAlgRS256 int64 = -257
+)
+
+// COSE key parameters (RFC 9052 section 7, RFC 9053 section 7).
+const (
+	coseKty int64 = 1
+	coseAlg int64 = 3
+	coseCrv int64 = -1 // EC2, OKP
+	coseX   int64 = -2 // EC2, OKP
+	coseY   int64 = -3 // EC2
+	coseN   int64 = -1 // RSA
+	coseE   int64 = -2 // RSA
+
+	coseKtyOKP int64 = 1
+	coseKtyEC2 int64 = 2
+	coseKtyRSA int64 = 3
+)
+
+// parseCOSEKey returns the public key and algorithm of a COSE_Key.
+func parseCOSEKey(bb []byte) (crypto.PublicKey, int64, error) {
+	item, rest, err := decodeCBOR(bb)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "cose key")
+	}
+	if len(rest) != 0 {
+		return nil, 0, errors.New("cose key : trailing bytes")
+	}
+	m, err := cborMap(item)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "cose key")
+	}
+	kty, err := cborInt(m, coseKty)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "cose key : kty")
+	}
+	alg, err := cborInt(m, coseAlg)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "cose key : alg")
+	}
+
+	switch kty {
+	case coseKtyEC2:
+		crv, _ := cborInt(m, coseCrv)
+		var curve elliptic.Curve
+		switch {
+		case crv == 1 && alg == COSEAlgES256:
+			curve = elliptic.P256()
+		case crv == 2 && alg == COSEAlgES384:
+			curve = elliptic.P384()
+		case crv == 3 && alg == COSEAlgES512:
+			curve = elliptic.P521()
+		default:
+			return nil, 0, errors.Errorf("cose key : EC2 curve %d of alg %d not supported", crv, alg)
+		}
+		x, err := cborBytes(m, coseX)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "cose key : x")
+		}
+		y, err := cborBytes(m, coseY)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "cose key : y")
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, 0, errors.New("cose key : point not on curve")
+		}
+		return pub, alg, nil
+
+	case coseKtyOKP:
+		crv, _ := cborInt(m, coseCrv)
+		if crv != 6 || alg != COSEAlgEdDSA {
+			return nil, 0, errors.Errorf("cose key : OKP curve %d of alg %d not supported", crv, alg)
+		}
+		x, err := cborBytes(m, coseX)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, 0, errors.New("cose key : malformed Ed25519 key")
+		}
+		return ed25519.PublicKey(x), alg, nil
+
+	case coseKtyRSA:
+		if alg != COSEAlgRS256 {
+			return nil, 0, errors.Errorf("cose key : RSA alg %d not supported", alg)
+		}
+		n, err := cborBytes(m, coseN)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "cose key : n")
+		}
+		e, err := cborBytes(m, coseE)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, 0, errors.New("cose key : malformed RSA exponent")
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < 2048 {
+			return nil, 0, errors.New("cose key : RSA key too short")
+		}
+		return pub, alg, nil
+	}
+	return nil, 0, errors.Errorf("cose key : kty %d not supported", kty)
+}
+
+// verifyCOSE verifies sig of data per the COSE algorithm (alg) of pub.
+// ECDSA signatures of WebAuthn are ASN.1 DER, unlike those of JWS.
+func verifyCOSE(alg int64, pub crypto.PublicKey, data, sig []byte) error {
+	ok := false
+	switch alg {
+	case COSEAlgES256, COSEAlgES384, COSEAlgES512:
+		k, isEC := pub.(*ecdsa.PublicKey)
+		if !isEC {
+			break
+		}
+		var digest []byte
+		switch alg {
+		case COSEAlgES256:
+			sum := sha256.Sum256(data)
+			digest = sum[:]
+		case COSEAlgES384:
+			sum := sha512.Sum384(data)
+			digest = sum[:]
+		default:
+			sum := sha512.Sum512(data)
+			digest = sum[:]
+		}
+		ok = ecdsa.VerifyASN1(k, digest, sig)
+	case COSEAlgEdDSA:
+		if k, isEd := pub.(ed25519.PublicKey); isEd {
+			ok = ed25519.Verify(k, data, sig)
+		}
+	case COSEAlgRS256:
+		if k, isRSA := pub.(*rsa.PublicKey); isRSA {
+			sum := sha256.Sum256(data)
+			ok = rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig) == nil
+		}
+	default:
+		return errors.Errorf("cose : alg %d not supported", alg)
+	}
+	if !ok {
+		return errors.New("cose : invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sempernow/kit/id"
+
+	"github.com/pkg/errors"
+)
+
+// ****************************************************************************
+// HTTP Digest Access Authentication per RFC 7616; algorithm SHA-256, qop auth.
+//
+// Nonces are stateless: the time of issue and its HMAC per a key of the Digest.
+// A nonce older than DigestNonceTTL is stale; the client retries per a new one
+// sans prompting the user. The server never sees the password; it verifies
+// per HA1 = SHA-256(user:realm:password), stored along with the password hash
+// (see DigestHA1), hence per realm.
+//
+// Replay: the nonce count (nc) of each nonce must increase per response; the
+// highest seen is recorded until the nonce expires. The record is of the
+// process, so a response replayed to another replica is accepted there, but
+// only within DigestNonceTTL of its nonce.
+// ****************************************************************************
+
+// DigestNonceTTL is the lifetime of a Digest nonce.
+const DigestNonceTTL = 5 * time.Minute
+
+// ErrDigestStale is of a response per a valid but expired nonce; challenge anew with stale=true.
+var ErrDigestStale = errors.New("digest : stale nonce")
+
+// DigestHA1 returns the HA1 of Digest authentication of user per realm;
+// store it as Credential.DigestHA1 whenever the password is set.
+func DigestHA1(user, realm, password string) string {
+	return sha256Hex(user + ":" + realm + ":" + password)
+}
+
+// Digest authenticates requests per RFC 7616 against the credentials of Passwords.
+type Digest struct {
+	realm  string
+	pw     *Passwords
+	key    []byte
+	opaque string
+	dummy  string // HA1 verified against upon unknown user, to equalize timing.
+
+	mu     sync.Mutex
+	counts map[string]nonceCount // Of nonces of valid responses.
+	pruned time.Time
+}
+
+// nonceCount is the highest nc of a nonce.
+type nonceCount struct {
+	nc     uint64
+	issued time.Time
+}
+
+// NewDigest returns the Digest of realm per the credential store of pw.
+func NewDigest(pw *Passwords, realm string) (*Digest, error) {
+	if pw == nil || realm == "" || strings.Contains(realm, `"`) {
+		return nil, errors.New("digest : passwords and a realm sans quotes are required")
+	}
+	key, err := id.Nonce(32)
+	if err != nil {
+		return nil, errors.Wrap(err, "digest : key")
+	}
+	return &Digest{
+		realm:  realm,
+		pw:     pw,
+		key:    key,
+		opaque: sha256Hex(realm)[:32],
+		dummy:  sha256Hex(hex.EncodeToString(key)),
+		counts: make(map[string]nonceCount),
+	}, nil
+}
+
+// Challenge returns the value of a WWW-Authenticate header of a new nonce;
+// stale if the prior response was rejected per ErrDigestStale.
+func (d *Digest) Challenge(stale bool) string {
+	c := `Digest realm="` + d.realm + `", qop="auth", algorithm=SHA-256, nonce="` +
+		d.nonce(time.Now()) + `", opaque="` + d.opaque + `"`
+	if stale {
+		c += ", stale=true"
+	}
+	return c
+}
+
+// Authenticate returns the claims of the request's `Authorization: Digest ...` header;
+// those of an Access token per DigestAuth mode. The error is ErrCredentials if invalid
+// or replayed (of an nc not above that of a prior response per its nonce),
+// or ErrDigestStale if valid but of an expired nonce.
+func (d *Digest) Authenticate(ctx context.Context, r *http.Request) (Claims, error) {
+	scheme, params, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "digest") {
+		return Claims{}, errors.Wrap(ErrCredentials, "digest : expected authorization header format: Digest PARAMS")
+	}
+	p := ParseAuthParams(params)
+	for _, k := range []string{"username", "realm", "nonce", "uri", "response", "qop", "nc", "cnonce"} {
+		if p[k] == "" {
+			return Claims{}, errors.Wrapf(ErrCredentials, "digest : missing %s", k)
+		}
+	}
+	switch {
+	case p["realm"] != d.realm, p["opaque"] != d.opaque:
+		return Claims{}, errors.Wrap(ErrCredentials, "digest : realm mismatch")
+	case p["algorithm"] != "" && !strings.EqualFold(p["algorithm"], "SHA-256"):
+		return Claims{}, errors.Wrap(ErrCredentials, "digest : algorithm not supported")
+	case p["qop"] != "auth":
+		return Claims{}, errors.Wrap(ErrCredentials, "digest : qop not supported")
+	case p["uri"] != r.URL.RequestURI():
+		return Claims{}, errors.Wrap(ErrCredentials, "digest : uri mismatch")
+	}
+	nc, err := strconv.ParseUint(p["nc"], 16, 32)
+	if err != nil || len(p["nc"]) != 8 {
+		return Claims{}, errors.Wrap(ErrCredentials, "digest : invalid nc")
+	}
+	issued, ok := d.verifyNonce(p["nonce"])
+	if !ok {
+		return Claims{}, errors.Wrap(ErrCredentials, "digest : invalid nonce")
+	}
+
+	cred, known, err := d.lookup(ctx, p["username"])
+	if err != nil {
+		return Claims{}, err
+	}
+	ha1 := cred.DigestHA1
+	if !known {
+		ha1 = d.dummy
+	}
+	ha2 := sha256Hex(r.Method + ":" + p["uri"])
+	want := sha256Hex(ha1 + ":" + p["nonce"] + ":" + p["nc"] + ":" + p["cnonce"] + ":" + p["qop"] + ":" + ha2)
+	if subtle.ConstantTimeCompare([]byte(want), []byte(strings.ToLower(p["response"]))) != 1 || !known {
+		return Claims{}, ErrCredentials
+	}
+	if time.Since(issued) > DigestNonceTTL {
+		return Claims{}, ErrDigestStale //... only once the response is otherwise valid.
+	}
+	if !d.count(p["nonce"], nc, issued) {
+		return Claims{}, errors.Wrap(ErrCredentials, "digest : replayed nonce count")
+	}
+	return credentialClaims(cred, DigestAuth), nil
+}
+
+// lookup returns the credential of user, and whether it is of a DigestHA1.
+// Unlike Passwords.lookup, an unknown user costs no hashing of a password,
+// as Authenticate verifies at the cost of SHA-256 either way.
+func (d *Digest) lookup(ctx context.Context, user string) (Credential, bool, error) {
+	if d.pw.store == nil {
+		return Credential{}, false, errors.New("digest : no credential store")
+	}
+	cred, err := d.pw.store.LookupCredential(ctx, user)
+	if err != nil {
+		if errors.Cause(err) == ErrCredentials {
+			return Credential{}, false, nil
+		}
+		return Credential{}, false, errors.Wrap(err, "digest : lookup")
+	}
+	return cred, cred.DigestHA1 != "", nil
+}
+
+// count records nc of nonce, and reports whether it exceeds that of any prior response
+// per nonce; expired nonces are pruned at most once a minute.
+func (d *Digest) count(nonce string, nc uint64, issued time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if now := time.Now(); now.Sub(d.pruned) > time.Minute {
+		for k, c := range d.counts {
+			if now.Sub(c.issued) > DigestNonceTTL {
+				delete(d.counts, k)
+			}
+		}
+		d.pruned = now
+	}
+	if c, ok := d.counts[nonce]; ok && nc <= c.nc {
+		return false
+	}
+	d.counts[nonce] = nonceCount{nc: nc, issued: issued}
+	return true
+}
+
+// nonce returns base64url(issued || HMAC(key, issued)[:16]).
+func (d *Digest) nonce(t time.Time) string {
+	bb := make([]byte, 8, 24)
+	binary.BigEndian.PutUint64(bb, uint64(t.UnixNano()))
+	mac := hmac.New(sha256.New, d.key)
+	mac.Write(bb)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(bb)[:24])
+}
+
+// verifyNonce returns the time nonce was issued, and whether it was issued by d.
+func (d *Digest) verifyNonce(nonce string) (time.Time, bool) {
+	bb, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(bb) != 24 {
+		return time.Time{}, false
+	}
+	issued := time.Unix(0, int64(binary.BigEndian.Uint64(bb[:8])))
+	return issued, hmac.Equal([]byte(d.nonce(issued)), []byte(nonce))
+}
+
+// ParseAuthParams returns the auth-params of an Authorization or WWW-Authenticate header,
+// sans scheme; `k1=token, k2="quoted \"string\""` (RFC 7235 section 2.1). Keys are lower case.
+func ParseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		k, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			return params
+		}
+		k = strings.ToLower(strings.TrimSpace(k))
+		rest = strings.TrimLeft(rest, " \t")
+
+		var v strings.Builder
+		if strings.HasPrefix(rest, `"`) {
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				v.WriteByte(rest[i])
+			}
+			if i < len(rest) {
+				i++ //... closing quote.
+			}
+			s = rest[i:]
+		} else {
+			end := strings.IndexAny(rest, ", \t")
+			if end < 0 {
+				end = len(rest)
+			}
+			v.WriteString(rest[:end])
+			s = rest[end:]
+		}
+		params[k] = v.String()
+	}
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,365 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/pkg/errors"
+)
+
+// JWKSPath is the conventional path of a JWK Set document.
+// https://www.rfc-editor.org/rfc/rfc8414#section-2 (jwks_uri)
+const JWKSPath = "/.well-known/jwks.json"
+
+// Key is a key pair of a KeySet, per key ID (kid).
+// It verifies tokens only within its validity window; a zero bound is unbounded.
+// A Key sans Private is verify-only; it cannot be the active (signing) key.
+type Key struct {
+	KID       string
+	Private   crypto.Signer
+	Public    crypto.PublicKey // Derived from Private if nil.
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// ValidAt reports whether t is within the key's validity window.
+func (k Key) ValidAt(t time.Time) bool {
+	return (k.NotBefore.IsZero() || !t.Before(k.NotBefore)) &&
+		(k.NotAfter.IsZero() || t.Before(k.NotAfter))
+}
+
+// KeySet holds the public keys by which tokens are verified,
+// and the one active private key by which tokens are signed.
+// Keys are rotated at runtime; tokens signed by a retired key remain valid
+// until its NotAfter, so a rotation does not invalidate outstanding tokens.
+// All methods are safe for concurrent use.
+//
+//	ks, err := auth.LoadKeySet("RS256", "/run/secrets/keys", kid)
+//	a, err := auth.NewWithKeySet(ks)
+//
+// KeySet is an http.Handler that publishes its public keys; serve it at JWKSPath.
+type KeySet struct {
+	algorithm string
+	mu        sync.RWMutex
+	keys      map[string]Key
+	active    string
+}
+
+// NewKeySet returns an empty KeySet of keys signing per algorithm;
+// RS256, PS256, ES256, EdDSA, ..., all keys of the set of the algorithm's kind.
+func NewKeySet(algorithm string) (*KeySet, error) {
+	switch jwt.GetSigningMethod(algorithm).(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA, *jwt.SigningMethodEd25519:
+	default:
+		return nil, errors.Errorf("key set : unsupported algorithm %v", algorithm)
+	}
+	return &KeySet{algorithm: algorithm, keys: make(map[string]Key)}, nil
+}
+
+// LoadKeySet returns a KeySet of the PEM files (<kid>.pem) of dir, with activeKID the signing key.
+// See Load(..).
+func LoadKeySet(algorithm, dir, activeKID string) (*KeySet, error) {
+	ks, err := NewKeySet(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return ks, ks.Load(dir, activeKID)
+}
+
+// Load replaces the keys of the set with those of the PEM files (<kid>.pem) of dir,
+// and activates activeKID; all or nothing. A file of a private key (PKCS #8, PKCS #1 or SEC 1)
+// is a signing key; a file of a public key (PKIX, PKCS #1 or certificate) is verify-only.
+// Call again upon a change of dir to rotate keys sans restart.
+func (ks *KeySet) Load(dir, activeKID string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return errors.Wrap(err, "key set : listing keys")
+	}
+	keys := make(map[string]Key, len(paths))
+	for _, path := range paths {
+		bb, err := os.ReadFile(path)
+		if err != nil {
+			return errors.Wrap(err, "key set : reading key")
+		}
+		k := Key{KID: strings.TrimSuffix(filepath.Base(path), ".pem")}
+		if k.Private, k.Public, err = parseKeyPEM(bb); err != nil {
+			return errors.Wrapf(err, "key set : parsing key %s", k.KID)
+		}
+		if keys[k.KID], err = k.normalize(ks.algorithm); err != nil {
+			return err
+		}
+	}
+	if err := canActivate(keys, activeKID, time.Now()); err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = keys
+	ks.active = activeKID
+	return nil
+}
+
+// Add adds (or replaces) a key of the set; it does not activate it.
+func (ks *KeySet) Add(k Key) error {
+	k, err := k.normalize(ks.algorithm)
+	if err != nil {
+		return err
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if k.KID == ks.active {
+		if err := canActivate(map[string]Key{k.KID: k}, k.KID, time.Now()); err != nil {
+			return errors.Wrap(err, "replacing active key")
+		}
+	}
+	ks.keys[k.KID] = k
+	return nil
+}
+
+// Activate makes the key of kid the signing key.
+// The key must be of the set, have a private key, and be valid now.
+func (ks *KeySet) Activate(kid string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if err := canActivate(ks.keys, kid, time.Now()); err != nil {
+		return err
+	}
+	ks.active = kid
+	return nil
+}
+
+// Rotate adds key (next) and activates it. The prior active key remains to verify
+// for the duration of overlap, which should be at least the TTL of its tokens;
+// its NotAfter is shortened to now + overlap, if not already sooner.
+// An overlap of zero leaves the prior key's window as is.
+func (ks *KeySet) Rotate(next Key, overlap time.Duration) error {
+	next, err := next.normalize(ks.algorithm)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := canActivate(map[string]Key{next.KID: next}, next.KID, now); err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if prior, ok := ks.keys[ks.active]; ok && overlap > 0 && prior.KID != next.KID {
+		if end := now.Add(overlap); prior.NotAfter.IsZero() || end.Before(prior.NotAfter) {
+			prior.NotAfter = end
+			ks.keys[prior.KID] = prior
+		}
+	}
+	ks.keys[next.KID] = next
+	ks.active = next.KID
+	return nil
+}
+
+// Remove removes the key of kid from the set; the active key cannot be removed.
+func (ks *KeySet) Remove(kid string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if kid == ks.active {
+		return errors.Errorf("key set : cannot remove active key %q", kid)
+	}
+	delete(ks.keys, kid)
+	return nil
+}
+
+// Algorithm returns the signing algorithm of the set.
+func (ks *KeySet) Algorithm() string {
+	return ks.algorithm
+}
+
+// Active returns the signing key; error if none, or if it is no longer valid.
+func (ks *KeySet) Active() (Key, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if err := canActivate(ks.keys, ks.active, time.Now()); err != nil {
+		return Key{}, err
+	}
+	return ks.keys[ks.active], nil
+}
+
+// Lookup is the PubKeyLookup of the set;
+// it returns the public key of kid if valid now.
+func (ks *KeySet) Lookup(kid string) (crypto.PublicKey, error) {
+	ks.mu.RLock()
+	k, ok := ks.keys[kid]
+	ks.mu.RUnlock()
+	if !ok {
+		return nil, errors.Errorf("unrecognized key id %q", kid)
+	}
+	if !k.ValidAt(time.Now()) {
+		return nil, errors.Errorf("key id %q is outside its validity window", kid)
+	}
+	return k.Public, nil
+}
+
+// JWK is a public JSON Web Key; of RSA (N, E), EC (Crv, X, Y) or OKP (Crv, X) key type (Kty).
+// https://www.rfc-editor.org/rfc/rfc7517#section-4
+// https://www.rfc-editor.org/rfc/rfc7518#section-6.2.1
+// https://www.rfc-editor.org/rfc/rfc7518#section-6.3.1
+// https://www.rfc-editor.org/rfc/rfc8037#section-2
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set document.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKSet returns the public keys of the set that are not expired, sorted by kid.
+// Keys not yet valid are included, so that verifiers learn of them before their use.
+func (ks *KeySet) JWKSet() JWKSet {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	now := time.Now()
+	set := JWKSet{Keys: []JWK{}}
+	for _, k := range ks.keys {
+		if !k.NotAfter.IsZero() && !now.Before(k.NotAfter) {
+			continue
+		}
+		set.Keys = append(set.Keys, newJWK(k.KID, ks.algorithm, k.Public))
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+// ServeHTTP responds with the JWK Set of the public keys (see JWKSet()); serve at JWKSPath.
+func (ks *KeySet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	bb, err := json.Marshal(ks.JWKSet())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(bb)
+	}
+}
+
+// newJWK returns the JWK of a public key of a KeySet; the key fits the algorithm (alg).
+func newJWK(kid, alg string, pub crypto.PublicKey) JWK {
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwk := JWK{Use: "sig", Alg: alg, Kid: kid}
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64(k.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(k.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8 //... coordinates are of full size.
+		jwk.Kty = "EC"
+		jwk.Crv = k.Curve.Params().Name
+		jwk.X = b64(k.X.FillBytes(make([]byte, size)))
+		jwk.Y = b64(k.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = b64(k)
+	}
+	return jwk
+}
+
+// parseKeyPEM returns the private key of a PEM block, else its public key.
+func parseKeyPEM(bb []byte) (crypto.Signer, crypto.PublicKey, error) {
+	block, _ := pem.Decode(bb)
+	if block == nil {
+		return nil, nil, errors.New("not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, nil, errors.Errorf("unsupported private key of type %T", key)
+		}
+		return signer, nil, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil, nil
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return nil, key, nil
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return nil, key, nil
+	}
+	if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+		return nil, cert.PublicKey, nil
+	}
+	return nil, nil, errors.Errorf("unsupported PEM block %q", block.Type)
+}
+
+// normalize validates the key against the algorithm of its set,
+// and derives its public key if absent.
+func (k Key) normalize(algorithm string) (Key, error) {
+	if k.KID == "" {
+		return k, errors.New("key set : key id cannot be blank")
+	}
+	if k.Private != nil {
+		pub := k.Private.Public()
+		if eq, ok := pub.(interface{ Equal(crypto.PublicKey) bool }); k.Public != nil && (!ok || !eq.Equal(k.Public)) {
+			return k, errors.Errorf("key set : key %q : public key does not match private key", k.KID)
+		}
+		k.Public = pub
+	}
+	if k.Public == nil {
+		return k, errors.Errorf("key set : key %q : missing key", k.KID)
+	}
+	if err := checkKey(algorithm, k.Public); err != nil {
+		return k, errors.Wrapf(err, "key set : key %q", k.KID)
+	}
+	if !k.NotAfter.IsZero() && !k.NotAfter.After(k.NotBefore) {
+		return k, errors.Errorf("key set : key %q : empty validity window", k.KID)
+	}
+	return k, nil
+}
+
+// canActivate returns an error unless the key of kid (of keys) can sign at t.
+func canActivate(keys map[string]Key, kid string, t time.Time) error {
+	k, ok := keys[kid]
+	switch {
+	case !ok:
+		return errors.Errorf("key set : unrecognized active key id %q", kid)
+	case k.Private == nil:
+		return errors.Errorf("key set : active key %q has no private key", kid)
+	case !k.ValidAt(t):
+		return errors.Errorf("key set : active key %q is outside its validity window", kid)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package auth_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sempernow/kit/auth"
+	"github.com/sempernow/kit/testkit"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	testkit.Log(t, "Generate RSA key", err)
+	return key
+}
+
+func accessClaims(ttl time.Duration) auth.Claims {
+	return auth.Claims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   "0x01",
+			ExpiresAt: time.Now().Add(ttl).Unix(),
+			IssuedAt:  auth.IssuedAtNow(),
+		},
+		Roles:     []string{auth.RoleUsrMbr},
+		TokenType: auth.Access,
+	}
+}
+
+func TestKeySet(t *testing.T) {
+	ks, err := auth.NewKeySet("RS256")
+	testkit.Log(t, "New key set", err)
+	_, err = auth.NewKeySet("HS256")
+	testkit.LogDiff(t, "Symmetric algorithm refused", err != nil, true)
+
+	k1, k2 := newRSAKey(t), newRSAKey(t)
+	testkit.Log(t, "Add key", ks.Add(auth.Key{KID: "k1", Private: k1}))
+	testkit.Log(t, "Activate key", ks.Activate("k1"))
+	testkit.LogDiff(t, "Verify-only key cannot activate",
+		ks.Add(auth.Key{KID: "pub", Public: &k2.PublicKey}) == nil && ks.Activate("pub") != nil, true)
+
+	a, err := auth.NewWithKeySet(ks)
+	testkit.Log(t, "New authenticator per key set", err)
+
+	t.Log("@ Rotation")
+	{
+		old, err := a.GenerateToken(accessClaims(time.Hour))
+		testkit.Log(t, "Generate token per k1", err)
+
+		testkit.Log(t, "Rotate to k2", ks.Rotate(auth.Key{KID: "k2", Private: k2}, time.Hour))
+		tkn, err := a.GenerateToken(accessClaims(time.Hour))
+		testkit.Log(t, "Generate token per k2", err)
+		parsed, _ := jwt.Parse(tkn, nil)
+		testkit.LogDiff(t, "Signed per k2", parsed.Header["kid"], "k2")
+
+		_, err = a.ValidateToken(old)
+		testkit.Log(t, "Token per k1 valid within overlap", err)
+		_, err = a.ValidateToken(tkn)
+		testkit.Log(t, "Token per k2 valid", err)
+
+		testkit.Log(t, "Retire k1", ks.Add(auth.Key{KID: "k1", Private: k1, NotAfter: time.Now().Add(-time.Second)}))
+		_, err = a.ValidateToken(old)
+		testkit.LogDiff(t, "Token per k1 invalid after its window", err != nil, true)
+		testkit.LogDiff(t, "Active key cannot be removed", ks.Remove("k2") != nil, true)
+	}
+	t.Log("@ JWKS document")
+	{
+		w := httptest.NewRecorder()
+		ks.ServeHTTP(w, httptest.NewRequest("GET", auth.JWKSPath, nil))
+		testkit.LogDiff(t, "Content-Type", w.Header().Get("Content-Type"), "application/jwk-set+json")
+
+		var set auth.JWKSet
+		testkit.Log(t, "Decode JWKS", json.Unmarshal(w.Body.Bytes(), &set))
+		var kids []string
+		for _, k := range set.Keys {
+			kids = append(kids, k.Kid)
+		}
+		testkit.LogDiff(t, "Expired keys are not published", strings.Join(kids, ","), "k2,pub")
+		testkit.LogDiff(t, "Exponent", set.Keys[0].E, "AQAB")
+		testkit.LogDiff(t, "Algorithm", set.Keys[0].Alg, "RS256")
+	}
+}
+
+func TestLoadKeySet(t *testing.T) {
+	dir := t.TempDir()
+	k1, k2 := newRSAKey(t), newRSAKey(t)
+	write := func(name, typ string, der []byte) {
+		bb := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+		testkit.Log(t, "Write "+name, os.WriteFile(filepath.Join(dir, name), bb, 0600))
+	}
+	pub, _ := x509.MarshalPKIXPublicKey(&k1.PublicKey)
+	write("k1.pem", "PUBLIC KEY", pub)
+	write("k2.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(k2))
+	write("notes.txt", "NOTE", []byte("ignored"))
+
+	ks, err := auth.LoadKeySet("RS256", dir, "k2")
+	testkit.Log(t, "Load key set", err)
+	testkit.LogDiff(t, "Keys loaded", len(ks.JWKSet().Keys), 2)
+
+	_, err = ks.Lookup("k1")
+	testkit.Log(t, "Lookup verify-only key", err)
+	k, err := ks.Active()
+	testkit.Log(t, "Active key", err)
+	testkit.LogDiff(t, "Active kid", k.KID, "k2")
+
+	_, err = auth.LoadKeySet("RS256", dir, "k1")
+	testkit.LogDiff(t, "Verify-only key cannot activate", err != nil, true)
+
+	t.Log("@ Reload upon rotation")
+	{
+		der, _ := x509.MarshalPKCS8PrivateKey(k1)
+		write("k1.pem", "PRIVATE KEY", der)
+		testkit.Log(t, "Reload", ks.Load(dir, "k1"))
+		k, _ = ks.Active()
+		testkit.LogDiff(t, "Active kid", k.KID, "k1")
+
+		write("k3.pem", "PRIVATE KEY", []byte("garbage"))
+		testkit.LogDiff(t, "Reload is all or nothing", ks.Load(dir, "k2") != nil, true)
+		k, _ = ks.Active()
+		testkit.LogDiff(t, "Active kid unchanged", k.KID, "k1")
+	}
+}
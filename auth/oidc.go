@@ -0,0 +1,367 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sempernow/kit/id"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/pkg/errors"
+)
+
+// ****************************************************************************
+// OAuth 2.0 / OpenID Connect login per authorization-code flow with PKCE:
+//
+//	Begin     : Set-Cookie __Host-o=<state>.<nonce>.<verifier>; redirect to IdP
+//	            (authorization_endpoint) with state, nonce and code_challenge.
+//	Callback  : IdP redirects back with code and state; state must match the cookie.
+//	            The code is exchanged, along with the PKCE verifier, for an ID token
+//	            at token_endpoint. The ID token is verified per the IdP's keys (jwks_uri):
+//	            signature, iss, aud, nonce, exp and iat.
+//	IssuePair : the app maps the verified identity to its own user,
+//	            and issues its own TokenPair of Mode OAuth2 and Provider of the IdP.
+//
+// The IdP's tokens go no further than Callback; clients bear only our own.
+// The KeyOA cookie is SameSite=Lax, not Strict, else browsers withhold it from the
+// IdP's cross-site redirect to Callback. It is good for one login (OIDCLoginTTL).
+// ****************************************************************************
+
+// OIDCLoginTTL is the time allowed to login at the IdP; the TTL of the KeyOA cookie.
+const OIDCLoginTTL = 10 * time.Minute
+
+var (
+	// ErrOAuthState is of a callback whose state does not match that of its KeyOA cookie;
+	// a forged or replayed callback, or a login begun at another browser.
+	ErrOAuthState = errors.New("oauth : state mismatch")
+	// ErrOAuthNonce is of an ID token whose nonce does not match that of its login.
+	ErrOAuthNonce = errors.New("oauth : nonce mismatch")
+)
+
+// OIDCConfig declares the parameters of Discover(..); zero values select defaults.
+type OIDCConfig struct {
+	// Issuer is the IdP's issuer URL; discovery is at Issuer + "/.well-known/openid-configuration".
+	Issuer       string
+	ClientID     string
+	ClientSecret string // Empty of a public client; PKCE alone.
+	// RedirectURL is that of the route of Callback(..); registered at the IdP.
+	RedirectURL string
+	// Scopes requested (default "openid email profile"); "openid" is added if absent.
+	Scopes []string
+	// Client of discovery and token requests (default of 10s timeout).
+	Client *http.Client
+	// Lookup of the IdP's keys (default RemoteJWKS of the discovered jwks_uri).
+	Lookup PubKeyLookup
+	// Leeway allows for clock skew at the exp and iat claims of ID tokens (default 1m).
+	Leeway time.Duration
+}
+
+// OIDCMetadata is the discovery document of an IdP; that of the fields used here.
+// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+type OIDCMetadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint,omitempty"`
+	JWKSURI               string   `json:"jwks_uri"`
+	SigningAlgs           []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// IDToken holds the verified claims of an ID token.
+type IDToken struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	AuthorizedBy  string   `json:"azp,omitempty"`
+	Nonce         string   `json:"nonce,omitempty"`
+	ExpiresAt     int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Email         string   `json:"email,omitempty"`
+	EmailVerified bool     `json:"email_verified,omitempty"`
+	Name          string   `json:"name,omitempty"`
+	Picture       string   `json:"picture,omitempty"`
+}
+
+// Valid is called during parsing; claims are validated per Provider.verify(..) instead.
+func (IDToken) Valid() error { return nil }
+
+// audience is the aud claim; a string or an array thereof.
+type audience []string
+
+func (aud *audience) UnmarshalJSON(bb []byte) error {
+	var s string
+	if err := json.Unmarshal(bb, &s); err == nil {
+		*aud = audience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(bb, &ss); err != nil {
+		return errors.Wrap(err, "aud claim")
+	}
+	*aud = ss
+	return nil
+}
+
+// Provider is an OIDC identity provider (IdP) of a relying party (client); per Discover(..).
+type Provider struct {
+	name   string
+	cfg    OIDCConfig
+	meta   OIDCMetadata
+	parser *jwt.Parser
+}
+
+// Discover returns the Provider of cfg per its discovery document, named (e.g., "google")
+// as is the Provider of TokenPairs issued per its logins.
+//
+//	google, err := auth.Discover(ctx, "google", auth.OIDCConfig{
+//		Issuer:       "https://accounts.google.com",
+//		ClientID:     cfg.OAuth.ClientID,
+//		ClientSecret: cfg.OAuth.ClientSecret,
+//		RedirectURL:  "https://foo.com/v1/oauth/google/callback",
+//	})
+func Discover(ctx context.Context, name string, cfg OIDCConfig) (*Provider, error) {
+	if name == "" || cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("oidc : name, issuer, client id and redirect url are required")
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	} else if !oneOf("openid", cfg.Scopes) {
+		cfg.Scopes = append([]string{"openid"}, cfg.Scopes...)
+	}
+	if cfg.Leeway <= 0 {
+		cfg.Leeway = time.Minute
+	}
+
+	wellKnown := strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "oidc : discovery")
+	}
+	resp, err := cfg.Client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "oidc : discovery")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("oidc : discovery : HTTP %d", resp.StatusCode)
+	}
+	var meta OIDCMetadata
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&meta); err != nil {
+		return nil, errors.Wrap(err, "oidc : discovery : decode")
+	}
+
+	// The issuer of the document must be that configured, else a spoofed document
+	// could name its own keys. https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfigurationValidation
+	if meta.Issuer != cfg.Issuer {
+		return nil, errors.Errorf("oidc : discovery : issuer %q is not %q", meta.Issuer, cfg.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("oidc : discovery : document lacks required endpoints")
+	}
+	if cfg.Lookup == nil {
+		cfg.Lookup = NewRemoteJWKS(meta.JWKSURI, RemoteJWKSConfig{Client: cfg.Client}).Lookup
+	}
+
+	// Only asymmetric algorithms; never "none" or HS* (per client secret) regardless of the document.
+	var algs []string
+	for _, alg := range meta.SigningAlgs {
+		if alg != "none" && !strings.HasPrefix(alg, "HS") && jwt.GetSigningMethod(alg) != nil {
+			algs = append(algs, alg)
+		}
+	}
+	if len(algs) == 0 {
+		algs = []string{"RS256"} //... the default per spec.
+	}
+
+	return &Provider{
+		name:   name,
+		cfg:    cfg,
+		meta:   meta,
+		parser: &jwt.Parser{ValidMethods: algs, SkipClaimsValidation: true},
+	}, nil
+}
+
+// Name returns the name of the provider.
+func (p *Provider) Name() string {
+	return p.name
+}
+
+// Metadata returns the discovery document of the provider.
+func (p *Provider) Metadata() OIDCMetadata {
+	return p.meta
+}
+
+// Begin sets the KeyOA cookie of a new login, and returns the URL of the IdP
+// to which the user agent is redirected.
+//
+//	u, err := google.Begin(w)
+//	http.Redirect(w, r, u, http.StatusFound)
+func (p *Provider) Begin(w http.ResponseWriter) (string, error) {
+	var vals [3]string // state, nonce, verifier
+	for i := range vals {
+		bb, err := id.Nonce(32)
+		if err != nil {
+			return "", errors.Wrap(err, "oidc : begin")
+		}
+		vals[i] = base64.RawURLEncoding.EncodeToString(bb)
+	}
+	state, nonce, verifier := vals[0], vals[1], vals[2]
+
+	u, err := url.Parse(p.meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", errors.Wrap(err, "oidc : begin : authorization endpoint")
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURL)
+	q.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", challenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     KeyOA,
+		Value:    state + "." + nonce + "." + verifier,
+		Path:     "/",
+		MaxAge:   int(OIDCLoginTTL.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return u.String(), nil
+}
+
+// Callback completes the login of the request (r) redirected from the IdP;
+// it clears the KeyOA cookie, exchanges the code, and returns the verified ID token.
+// The app then maps it to its own user and issues its own pair; see IssuePair(..).
+func (p *Provider) Callback(ctx context.Context, w http.ResponseWriter, r *http.Request) (IDToken, error) {
+	c, err := r.Cookie(KeyOA)
+	http.SetCookie(w, &http.Cookie{Name: KeyOA, Path: "/", MaxAge: -1, Secure: true, HttpOnly: true, SameSite: http.SameSiteLaxMode})
+	if err != nil {
+		return IDToken{}, errors.Wrap(err, "oidc : callback : reading login cookie")
+	}
+	vals := strings.Split(c.Value, ".")
+	if len(vals) != 3 {
+		return IDToken{}, errors.New("oidc : callback : malformed login cookie")
+	}
+	state, nonce, verifier := vals[0], vals[1], vals[2]
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		return IDToken{}, errors.Errorf("oidc : callback : %s : %s", e, q.Get("error_description"))
+	}
+	if subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(state)) != 1 {
+		return IDToken{}, ErrOAuthState
+	}
+	code := q.Get("code")
+	if code == "" {
+		return IDToken{}, errors.New("oidc : callback : missing code")
+	}
+
+	raw, err := p.exchange(ctx, code, verifier)
+	if err != nil {
+		return IDToken{}, err
+	}
+	return p.verify(raw, nonce, time.Now())
+}
+
+// IssuePair issues our own token pair of claims per a login at the provider;
+// of Mode OAuth2 and Provider of its name. See Auth.IssuePair(..).
+func (p *Provider) IssuePair(ctx context.Context, a *Auth, claims Claims) (Issued, error) {
+	return a.IssuePair(ctx, claims, OAuth2, p.name)
+}
+
+// exchange returns the raw ID token of code, per the token endpoint.
+func (p *Provider) exchange(ctx context.Context, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {verifier},
+		"client_id":     {p.cfg.ClientID},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", errors.Wrap(err, "oidc : token request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		//... client_secret_basic; both form-urlencoded per RFC 6749 section 2.3.1.
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	resp, err := p.cfg.Client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "oidc : token request")
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken     string `json:"id_token"`
+		TokenType   string `json:"token_type"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", errors.Wrapf(err, "oidc : token response : HTTP %d", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return "", errors.Errorf("oidc : token response : HTTP %d : %s : %s", resp.StatusCode, body.Error, body.Description)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("oidc : token response lacks id_token")
+	}
+	return body.IDToken, nil
+}
+
+// verify returns the claims of the raw ID token if valid at now, of the login of nonce.
+// https://openid.net/specs/openid-connect-core-1_0.html#IDTokenValidation
+func (p *Provider) verify(raw, nonce string, now time.Time) (IDToken, error) {
+	var idt IDToken
+	_, err := p.parser.ParseWithClaims(raw, &idt, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.cfg.Lookup(kid)
+	})
+	if err != nil {
+		return IDToken{}, errors.Wrap(err, "oidc : parsing id token")
+	}
+
+	t, skew := now.Unix(), int64(p.cfg.Leeway/time.Second)
+	switch {
+	case idt.Issuer != p.meta.Issuer:
+		return IDToken{}, errors.Errorf("oidc : id token issuer %q not accepted", idt.Issuer)
+	case !oneOf(p.cfg.ClientID, idt.Audience):
+		return IDToken{}, errors.New("oidc : id token audience lacks client id")
+	case len(idt.Audience) > 1 && idt.AuthorizedBy != p.cfg.ClientID:
+		return IDToken{}, errors.New("oidc : id token azp is not client id")
+	case idt.Subject == "":
+		return IDToken{}, errors.New("oidc : id token lacks sub claim")
+	case idt.ExpiresAt == 0 || t > idt.ExpiresAt+skew:
+		return IDToken{}, errors.New("oidc : id token is expired")
+	case t < idt.IssuedAt-skew:
+		return IDToken{}, errors.New("oidc : id token used before issued")
+	case subtle.ConstantTimeCompare([]byte(idt.Nonce), []byte(nonce)) != 1:
+		return IDToken{}, ErrOAuthNonce
+	}
+	return idt, nil
+}
+
+// challenge returns the S256 PKCE code challenge of verifier (RFC 7636).
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,201 @@
+package auth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sempernow/kit/auth"
+	"github.com/sempernow/kit/testkit"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/pkg/errors"
+)
+
+// fakeIdP is an OIDC provider of authorization codes granted per grant(..).
+type fakeIdP struct {
+	*httptest.Server
+	key *ecdsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]url.Values // code : query of the authorization request
+	aud   string                // Overrides the aud claim if set.
+	nonce string                // Overrides the nonce claim if set.
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ks, err := auth.NewKeySet("ES256")
+	testkit.Log(t, "New IdP key set", err)
+	testkit.Log(t, "Add IdP key", ks.Add(auth.Key{KID: "idp-1", Private: key}))
+
+	idp := fakeIdP{key: key, codes: make(map[string]url.Values)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(auth.OIDCMetadata{
+			Issuer:                idp.URL,
+			AuthorizationEndpoint: idp.URL + "/authorize",
+			TokenEndpoint:         idp.URL + "/token",
+			JWKSURI:               idp.URL + auth.JWKSPath,
+			SigningAlgs:           []string{"ES256"},
+		})
+	})
+	mux.Handle(auth.JWKSPath, ks)
+	mux.HandleFunc("/token", idp.token)
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return &idp
+}
+
+// grant returns the code granted per the authorization request (authURL).
+func (idp *fakeIdP) grant(authURL string) string {
+	u, _ := url.Parse(authURL)
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	code := "code-" + u.Query().Get("state")[:8]
+	idp.codes[code] = u.Query()
+	return code
+}
+
+func (idp *fakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	fail := func(e string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": e})
+	}
+	if id, secret, ok := r.BasicAuth(); !ok || id != "client-1" || secret != "s3cr3t" {
+		fail("invalid_client")
+		return
+	}
+	idp.mu.Lock()
+	q, ok := idp.codes[r.PostFormValue("code")]
+	delete(idp.codes, r.PostFormValue("code"))
+	idp.mu.Unlock()
+	if !ok || r.PostFormValue("redirect_uri") != q.Get("redirect_uri") {
+		fail("invalid_grant")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != q.Get("code_challenge") {
+		fail("invalid_grant")
+		return
+	}
+
+	aud, nonce := interface{}(q.Get("client_id")), q.Get("nonce")
+	if idp.aud != "" {
+		aud = []string{idp.aud, "other"}
+	}
+	if idp.nonce != "" {
+		nonce = idp.nonce
+	}
+	tkn := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss":   idp.URL,
+		"sub":   "idp-user-42",
+		"aud":   aud,
+		"nonce": nonce,
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"iat":   time.Now().Unix(),
+		"email": "foo@bar.com",
+	})
+	tkn.Header["kid"] = "idp-1"
+	raw, _ := tkn.SignedString(idp.key)
+	json.NewEncoder(w).Encode(map[string]string{"id_token": raw, "access_token": "x", "token_type": "Bearer"})
+}
+
+func TestOIDC(t *testing.T) {
+	idp := newFakeIdP(t)
+	ctx := testkit.Context()
+	p, err := auth.Discover(ctx, "fake", auth.OIDCConfig{
+		Issuer:       idp.URL,
+		ClientID:     "client-1",
+		ClientSecret: "s3cr3t",
+		RedirectURL:  "https://app.test/oauth/callback",
+	})
+	testkit.Log(t, "Discover", err)
+
+	// login begins, the user consents at the IdP, and the IdP redirects back.
+	login := func(tamper func(q url.Values)) (auth.IDToken, error) {
+		w := httptest.NewRecorder()
+		authURL, err := p.Begin(w)
+		testkit.Log(t, "Begin", err)
+		u, _ := url.Parse(authURL)
+		q := url.Values{"state": {u.Query().Get("state")}, "code": {idp.grant(authURL)}}
+		if tamper != nil {
+			tamper(q)
+		}
+		r := httptest.NewRequest("GET", "https://app.test/oauth/callback?"+q.Encode(), nil)
+		for _, c := range w.Result().Cookies() {
+			r.AddCookie(c)
+		}
+		return p.Callback(ctx, httptest.NewRecorder(), r)
+	}
+
+	t.Log("@ Authorization request")
+	{
+		w := httptest.NewRecorder()
+		authURL, err := p.Begin(w)
+		testkit.Log(t, "Begin", err)
+		q, _ := url.ParseQuery(authURL[strings.Index(authURL, "?")+1:])
+		testkit.LogDiff(t, "Endpoint", strings.HasPrefix(authURL, idp.URL+"/authorize?"), true)
+		testkit.LogDiff(t, "Scope", q.Get("scope"), "openid email profile")
+		testkit.LogDiff(t, "PKCE", q.Get("code_challenge_method"), "S256")
+		c := w.Result().Cookies()
+		testkit.LogDiff(t, "Login cookie", len(c) == 1 && c[0].Name == auth.KeyOA && c[0].SameSite == http.SameSiteLaxMode, true)
+	}
+	t.Log("@ Callback")
+	{
+		idt, err := login(nil)
+		testkit.Log(t, "Callback", err)
+		testkit.LogDiff(t, "Subject", idt.Subject, "idp-user-42")
+		testkit.LogDiff(t, "Email", idt.Email, "foo@bar.com")
+
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		a, err := auth.New(key, "k1", "ES256", auth.JWKS("k1", key.Public()), auth.WithRefreshStore(auth.NewMemoryRefreshStore()))
+		testkit.Log(t, "New authenticator", err)
+		iss, err := p.IssuePair(ctx, a, auth.Claims{StandardClaims: jwt.StandardClaims{Subject: "0x01"}})
+		testkit.Log(t, "Issue pair", err)
+		testkit.LogDiff(t, "Mode", iss.Mode, auth.OAuth2)
+		testkit.LogDiff(t, "Provider", iss.Provider, "fake")
+	}
+	t.Log("@ Rejects")
+	{
+		_, err := login(func(q url.Values) { q.Set("state", "forged") })
+		testkit.LogDiff(t, "State mismatch", errors.Cause(err), auth.ErrOAuthState)
+
+		_, err = login(func(q url.Values) { q.Set("code", "unknown") })
+		testkit.LogDiff(t, "Unknown code", err != nil && strings.Contains(err.Error(), "invalid_grant"), true)
+
+		idp.nonce = "replayed"
+		_, err = login(nil)
+		testkit.LogDiff(t, "Nonce mismatch", errors.Cause(err), auth.ErrOAuthNonce)
+		idp.nonce = ""
+
+		idp.aud = "client-2"
+		_, err = login(nil)
+		testkit.LogDiff(t, "Audience lacks client", err != nil && strings.Contains(err.Error(), "audience"), true)
+		idp.aud = ""
+
+		r := httptest.NewRequest("GET", "https://app.test/oauth/callback?state=x&code=y", nil)
+		_, err = p.Callback(ctx, httptest.NewRecorder(), r)
+		testkit.LogDiff(t, "No login cookie", err != nil, true)
+	}
+	t.Log("@ Discovery")
+	{
+		_, err := auth.Discover(ctx, "fake", auth.OIDCConfig{
+			Issuer:      idp.URL + "/",
+			ClientID:    "client-1",
+			RedirectURL: "https://app.test/oauth/callback",
+		})
+		testkit.LogDiff(t, "Issuer mismatch refused", err != nil, true)
+	}
+}
@@ -0,0 +1,283 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"hash"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sempernow/kit/id"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/pkg/errors"
+)
+
+// ****************************************************************************
+// Passwords are stored as self-describing PBKDF2 hashes (RFC 8018),
+// in the PHC string format, so parameters may be raised over time:
+//
+//	$pbkdf2-sha256$i=600000$<salt>$<key>      (salt and key per base64; raw std)
+//
+// Upon login per a hash of weaker parameters (or algorithm), the password is
+// rehashed per the current ones and saved; upgrade-on-login.
+// ****************************************************************************
+
+// DefaultPasswordIterations is that of PBKDF2-HMAC-SHA256 per OWASP guidance (2023).
+const DefaultPasswordIterations = 600000
+
+var (
+	// ErrCredentials is of an unknown user or a wrong password; indistinguishable by design.
+	ErrCredentials = errors.New("invalid credentials")
+	// ErrPasswordHash is of a stored hash that is malformed or of an unknown algorithm.
+	ErrPasswordHash = errors.New("malformed or unknown password hash")
+)
+
+// PasswordConfig declares the parameters of hashing; zero values select defaults.
+type PasswordConfig struct {
+	Iterations int // Default DefaultPasswordIterations.
+	SaltLen    int // Bytes; default 16.
+	KeyLen     int // Bytes; default 32.
+}
+
+// Credential is the stored credential of a user (login name).
+type Credential struct {
+	Subject string   // The sub claim of the user.
+	Roles   []string // The roles claim of the user.
+	Hash    string   // Per Passwords.Hash(..).
+	// DigestHA1 is DigestHA1(user, realm, password); required only of DigestAuth.
+	DigestHA1 string
+}
+
+// CredentialStore looks up credentials by user (login name), and saves rehashed passwords.
+// LookupCredential returns ErrCredentials if the user is unknown.
+type CredentialStore interface {
+	LookupCredential(ctx context.Context, user string) (Credential, error)
+	UpdatePasswordHash(ctx context.Context, user, hash string) error
+}
+
+// Passwords hashes and verifies passwords, and authenticates users per a CredentialStore.
+type Passwords struct {
+	cfg   PasswordConfig
+	store CredentialStore
+	dummy string // Hash verified against upon unknown user, to equalize timing.
+}
+
+// NewPasswords returns the Passwords of store per cfg; store may be nil if only hashing.
+func NewPasswords(store CredentialStore, cfg PasswordConfig) (*Passwords, error) {
+	if cfg.Iterations <= 0 {
+		cfg.Iterations = DefaultPasswordIterations
+	}
+	if cfg.SaltLen <= 0 {
+		cfg.SaltLen = 16
+	}
+	if cfg.KeyLen <= 0 {
+		cfg.KeyLen = 32
+	}
+	if cfg.SaltLen < 8 || cfg.KeyLen < 16 {
+		return nil, errors.New("passwords : salt or key too short")
+	}
+	p := Passwords{cfg: cfg, store: store}
+	dummy, err := p.Hash(id.Base32())
+	if err != nil {
+		return nil, err
+	}
+	p.dummy = dummy
+	return &p, nil
+}
+
+// Hash returns the encoded hash of password per the current parameters.
+func (p *Passwords) Hash(password string) (string, error) {
+	salt, err := id.Nonce(p.cfg.SaltLen)
+	if err != nil {
+		return "", errors.Wrap(err, "passwords : salt")
+	}
+	key := pbkdf2(sha256.New, []byte(password), salt, p.cfg.Iterations, p.cfg.KeyLen)
+	return encodeHash("pbkdf2-sha256", p.cfg.Iterations, salt, key), nil
+}
+
+// Verify reports whether password matches the encoded hash, in constant time;
+// and whether the hash is of weaker parameters than current, so should be replaced.
+func (p *Passwords) Verify(encoded, password string) (ok, rehash bool, err error) {
+	alg, iter, salt, key, err := decodeHash(encoded)
+	if err != nil {
+		return false, false, err
+	}
+	var h func() hash.Hash
+	switch alg {
+	case "pbkdf2-sha256":
+		h = sha256.New
+	case "pbkdf2-sha512":
+		h = sha512.New
+	}
+	got := pbkdf2(h, []byte(password), salt, iter, len(key))
+	ok = subtle.ConstantTimeCompare(got, key) == 1
+	rehash = alg != "pbkdf2-sha256" || iter < p.cfg.Iterations ||
+		len(salt) < p.cfg.SaltLen || len(key) < p.cfg.KeyLen
+	return ok, rehash, nil
+}
+
+// Authenticate returns the claims of user if password is valid; ErrCredentials if not.
+// A hash of weaker parameters is upgraded; failure to save it does not fail the login.
+// The claims are of an Access token per BasicAuth mode; sub and roles of the Credential.
+func (p *Passwords) Authenticate(ctx context.Context, user, password string) (Claims, error) {
+	cred, err := p.lookup(ctx, user)
+	if err != nil {
+		return Claims{}, err
+	}
+	encoded := cred.Hash
+	if encoded == "" {
+		encoded = p.dummy //... a user sans password; e.g., of OAuth2 only.
+	}
+	ok, rehash, err := p.Verify(encoded, password)
+	if err != nil {
+		return Claims{}, errors.Wrapf(err, "passwords : user %q", user)
+	}
+	if !ok || cred.Hash == "" {
+		return Claims{}, ErrCredentials
+	}
+	if rehash {
+		if h, err := p.Hash(password); err == nil {
+			_ = p.store.UpdatePasswordHash(ctx, user, h)
+		}
+	}
+	return credentialClaims(cred, BasicAuth), nil
+}
+
+// lookup returns the credential of user; upon unknown user, it verifies against
+// the dummy hash so that the response time reveals nothing, and returns ErrCredentials.
+func (p *Passwords) lookup(ctx context.Context, user string) (Credential, error) {
+	if p.store == nil {
+		return Credential{}, errors.New("passwords : no credential store")
+	}
+	cred, err := p.store.LookupCredential(ctx, user)
+	if err != nil {
+		if errors.Cause(err) == ErrCredentials {
+			p.Verify(p.dummy, user)
+			return Credential{}, ErrCredentials
+		}
+		return Credential{}, errors.Wrap(err, "passwords : lookup")
+	}
+	return cred, nil
+}
+
+// credentialClaims returns the claims of an Access token of cred, per auth mode.
+func credentialClaims(cred Credential, mode string) Claims {
+	return Claims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:  cred.Subject,
+			Issuer:   Issuer(mode),
+			IssuedAt: IssuedAtNow(),
+		},
+		Roles:     cred.Roles,
+		TokenType: Access,
+	}
+}
+
+func encodeHash(alg string, iter int, salt, key []byte) string {
+	return "$" + alg + "$i=" + strconv.Itoa(iter) + "$" +
+		base64.RawStdEncoding.EncodeToString(salt) + "$" +
+		base64.RawStdEncoding.EncodeToString(key)
+}
+
+func decodeHash(encoded string) (alg string, iter int, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[0] != "" || !strings.HasPrefix(parts[2], "i=") {
+		return "", 0, nil, nil, ErrPasswordHash
+	}
+	alg = parts[1]
+	if alg != "pbkdf2-sha256" && alg != "pbkdf2-sha512" {
+		return "", 0, nil, nil, ErrPasswordHash
+	}
+	if iter, err = strconv.Atoi(parts[2][2:]); err != nil || iter < 1 {
+		return "", 0, nil, nil, ErrPasswordHash
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[3]); err != nil {
+		return "", 0, nil, nil, ErrPasswordHash
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil || len(key) == 0 {
+		return "", 0, nil, nil, ErrPasswordHash
+	}
+	return alg, iter, salt, key, nil
+}
+
+// pbkdf2 derives a key of keyLen bytes per RFC 8018 section 5.2.
+func pbkdf2(h func() hash.Hash, password, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(h, password)
+	size := prf.Size()
+	blocks := (keyLen + size - 1) / size
+
+	dk := make([]byte, 0, blocks*size)
+	var ctr [4]byte
+	u := make([]byte, size)
+	t := make([]byte, size)
+	for block := 1; block <= blocks; block++ {
+		binary.BigEndian.PutUint32(ctr[:], uint32(block))
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(ctr[:])
+		u = prf.Sum(u[:0])
+		copy(t, u)
+		for n := 1; n < iter; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range t {
+				t[i] ^= u[i]
+			}
+		}
+		dk = append(dk, t...)
+	}
+	return dk[:keyLen]
+}
+
+// ----------------------------------------------------------------------------
+// MemoryCredentialStore
+
+// MemoryCredentialStore is an in-process CredentialStore; for tests and static configuration.
+type MemoryCredentialStore struct {
+	mu    sync.RWMutex
+	creds map[string]Credential
+}
+
+// NewMemoryCredentialStore returns an empty MemoryCredentialStore.
+func NewMemoryCredentialStore() *MemoryCredentialStore {
+	return &MemoryCredentialStore{creds: make(map[string]Credential)}
+}
+
+// Put adds or replaces the credential of user.
+func (s *MemoryCredentialStore) Put(user string, cred Credential) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.creds[user] = cred
+}
+
+// LookupCredential returns the credential of user.
+func (s *MemoryCredentialStore) LookupCredential(ctx context.Context, user string) (Credential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cred, ok := s.creds[user]
+	if !ok {
+		return Credential{}, ErrCredentials
+	}
+	return cred, nil
+}
+
+// UpdatePasswordHash replaces the password hash of user.
+func (s *MemoryCredentialStore) UpdatePasswordHash(ctx context.Context, user, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cred, ok := s.creds[user]
+	if !ok {
+		return ErrCredentials
+	}
+	cred.Hash = hash
+	s.creds[user] = cred
+	return nil
+}
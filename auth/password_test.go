@@ -0,0 +1,75 @@
+package auth_test
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/sempernow/kit/auth"
+	"github.com/sempernow/kit/testkit"
+
+	"github.com/pkg/errors"
+)
+
+func TestPasswords(t *testing.T) {
+	store := auth.NewMemoryCredentialStore()
+	pw, err := auth.NewPasswords(store, auth.PasswordConfig{Iterations: 1000})
+	testkit.Log(t, "New passwords", err)
+	ctx := testkit.Context()
+
+	t.Log("@ Hash")
+	{
+		h, err := pw.Hash("correct horse")
+		testkit.Log(t, "Hash", err)
+		testkit.LogDiff(t, "Self-describing", strings.HasPrefix(h, "$pbkdf2-sha256$i=1000$"), true)
+
+		ok, rehash, err := pw.Verify(h, "correct horse")
+		testkit.Log(t, "Verify", err)
+		testkit.LogDiff(t, "Match", ok && !rehash, true)
+		ok, _, _ = pw.Verify(h, "wrong horse")
+		testkit.LogDiff(t, "Mismatch", ok, false)
+
+		_, _, err = pw.Verify("$bcrypt$x", "correct horse")
+		testkit.LogDiff(t, "Unknown algorithm", errors.Cause(err), auth.ErrPasswordHash)
+	}
+	t.Log("@ PBKDF2-HMAC-SHA256 vector (RFC 7914 section 11)")
+	{
+		dk, _ := hex.DecodeString("55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+			"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783")
+		h := "$pbkdf2-sha256$i=1$" + base64.RawStdEncoding.EncodeToString([]byte("salt")) +
+			"$" + base64.RawStdEncoding.EncodeToString(dk)
+		ok, rehash, err := pw.Verify(h, "passwd")
+		testkit.Log(t, "Verify", err)
+		testkit.LogDiff(t, "Match", ok, true)
+		testkit.LogDiff(t, "Weak parameters", rehash, true)
+	}
+	t.Log("@ Authenticate")
+	{
+		weak, _ := auth.NewPasswords(nil, auth.PasswordConfig{Iterations: 10})
+		h, _ := weak.Hash("s3cr3t")
+		store.Put("foo", auth.Credential{Subject: "0x01", Roles: []string{auth.RoleUsrMbr}, Hash: h})
+
+		claims, err := pw.Authenticate(ctx, "foo", "s3cr3t")
+		testkit.Log(t, "Authenticate", err)
+		testkit.LogDiff(t, "Subject", claims.Subject, "0x01")
+		testkit.LogDiff(t, "Issuer", claims.Issuer, auth.Issuer(auth.BasicAuth))
+
+		cred, _ := store.LookupCredential(ctx, "foo")
+		testkit.LogDiff(t, "Upgraded on login", strings.HasPrefix(cred.Hash, "$pbkdf2-sha256$i=1000$"), true)
+		_, err = pw.Authenticate(ctx, "foo", "s3cr3t")
+		testkit.Log(t, "Authenticate per upgraded hash", err)
+
+		_, err = pw.Authenticate(ctx, "foo", "wrong")
+		testkit.LogDiff(t, "Wrong password", errors.Cause(err), auth.ErrCredentials)
+		_, err = pw.Authenticate(ctx, "bar", "s3cr3t")
+		testkit.LogDiff(t, "Unknown user", errors.Cause(err), auth.ErrCredentials)
+	}
+}
+
+func TestParseAuthParams(t *testing.T) {
+	p := auth.ParseAuthParams(`username="Mufasa", realm="http-auth@example.org", nc=00000001, opaque="a\"b"`)
+	testkit.LogDiff(t, "Quoted", p["realm"], "http-auth@example.org")
+	testkit.LogDiff(t, "Token", p["nc"], "00000001")
+	testkit.LogDiff(t, "Escaped", p["opaque"], `a"b`)
+}
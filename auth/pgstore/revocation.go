@@ -0,0 +1,122 @@
+// Package pgstore implements stores of package auth per Postgres (see package dbms),
+// apart from auth so that it does not import the database layer.
+package pgstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/sempernow/kit/auth"
+	"github.com/sempernow/kit/dbms"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RevocationSchema is the DDL of the tables of RevocationStore.
+const RevocationSchema = `
+CREATE TABLE IF NOT EXISTS auth_revoked_tokens (
+	jti        TEXT PRIMARY KEY,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS auth_revoked_tokens_expires_at ON auth_revoked_tokens (expires_at);
+CREATE TABLE IF NOT EXISTS auth_revoked_subjects (
+	subject    TEXT PRIMARY KEY,
+	not_before TIMESTAMPTZ NOT NULL
+);`
+
+// RevocationStore is an auth.RevocationStore of Postgres tables (RevocationSchema),
+// shared by all instances of a service.
+//
+//	db, err := dbms.Open(cfg)
+//	store := pgstore.NewRevocationStore(db)
+//	a, err := auth.New(key, kid, "ES256", lookup, auth.WithRevocation(store))
+type RevocationStore struct {
+	db *sqlx.DB
+}
+
+// NewRevocationStore returns a RevocationStore of db (see dbms.Open).
+func NewRevocationStore(db *sqlx.DB) *RevocationStore {
+	return &RevocationStore{db: db}
+}
+
+// Migrate creates the tables of the store if not exist.
+func (s *RevocationStore) Migrate(ctx context.Context) error {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "kit.dbms.revocation.migrate")
+	defer span.End()
+
+	if _, err := s.db.ExecContext(ctx, RevocationSchema); err != nil {
+		return errors.Wrap(dbms.Classify(err), "migrating revocation schema")
+	}
+	return nil
+}
+
+// RevokeToken denies the token of jti until exp.
+func (s *RevocationStore) RevokeToken(ctx context.Context, jti string, exp time.Time) error {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "kit.dbms.revocation.revoketoken")
+	defer span.End()
+
+	if jti == "" {
+		return errors.New("revoke token : jti cannot be blank")
+	}
+	const q = `
+	INSERT INTO auth_revoked_tokens (jti, expires_at) VALUES ($1, $2)
+	ON CONFLICT (jti) DO UPDATE SET expires_at = GREATEST(auth_revoked_tokens.expires_at, EXCLUDED.expires_at)`
+	if _, err := s.db.ExecContext(ctx, q, jti, exp.UTC()); err != nil {
+		return errors.Wrap(dbms.Classify(err), "revoke token")
+	}
+	return nil
+}
+
+// RevokeSubject denies tokens of subject issued before notBefore, per auth.RevokedBefore(..);
+// a later revocation supersedes.
+func (s *RevocationStore) RevokeSubject(ctx context.Context, subject string, notBefore time.Time) error {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "kit.dbms.revocation.revokesubject")
+	defer span.End()
+
+	if subject == "" {
+		return errors.New("revoke subject : subject cannot be blank")
+	}
+	const q = `
+	INSERT INTO auth_revoked_subjects (subject, not_before) VALUES ($1, $2)
+	ON CONFLICT (subject) DO UPDATE SET not_before = GREATEST(auth_revoked_subjects.not_before, EXCLUDED.not_before)`
+	if _, err := s.db.ExecContext(ctx, q, subject, auth.RevokedBefore(notBefore)); err != nil {
+		return errors.Wrap(dbms.Classify(err), "revoke subject")
+	}
+	return nil
+}
+
+// Revoked reports whether the token of claims is denied; one round trip.
+// Only a failure of the connection is auth.ErrRevocationUnavailable; others are per dbms.Classify(..).
+func (s *RevocationStore) Revoked(ctx context.Context, claims auth.Claims) (bool, error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "kit.dbms.revocation.revoked")
+	defer span.End()
+
+	const q = `
+	SELECT EXISTS (SELECT 1 FROM auth_revoked_tokens WHERE jti = $1 AND expires_at > now())
+		OR EXISTS (SELECT 1 FROM auth_revoked_subjects WHERE subject = $2 AND not_before > $3)`
+	var revoked bool
+	iat := time.Unix(claims.IssuedAt, 0).UTC()
+	if err := s.db.QueryRowContext(ctx, q, claims.Id, claims.Subject, iat).Scan(&revoked); err != nil {
+		err = dbms.Classify(err)
+		if errors.Is(err, dbms.ErrConnection) {
+			return false, errors.Wrap(auth.ErrRevocationUnavailable, err.Error())
+		}
+		return false, errors.Wrap(err, "revoked")
+	}
+	return revoked, nil
+}
+
+// Prune deletes entries of tokens expired regardless; run periodically.
+func (s *RevocationStore) Prune(ctx context.Context) (int64, error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "kit.dbms.revocation.prune")
+	defer span.End()
+
+	const q = `DELETE FROM auth_revoked_tokens WHERE expires_at <= now()`
+	res, err := s.db.ExecContext(ctx, q)
+	if err != nil {
+		return 0, errors.Wrap(dbms.Classify(err), "prune revoked tokens")
+	}
+	return res.RowsAffected()
+}
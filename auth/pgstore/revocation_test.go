@@ -0,0 +1,30 @@
+package pgstore_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sempernow/kit/auth"
+	"github.com/sempernow/kit/auth/pgstore"
+	"github.com/sempernow/kit/dbms"
+	"github.com/sempernow/kit/testkit"
+
+	"github.com/pkg/errors"
+)
+
+func TestRevocationStoreUnreachable(t *testing.T) {
+	ctx := testkit.Context()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	testkit.Log(t, "Reserve a port", err)
+	ln.Close() //... refusing connections.
+	db, err := dbms.Open(dbms.Config{Host: ln.Addr().String(), User: "app", Name: "app", DisableTLS: true})
+	testkit.Log(t, "Open unreachable database", err)
+	defer db.Close()
+	store := pgstore.NewRevocationStore(db)
+
+	_, err = store.Revoked(ctx, auth.Claims{})
+	testkit.LogDiff(t, "Revoked is unavailable", errors.Cause(err), auth.ErrRevocationUnavailable)
+	err = store.RevokeToken(ctx, "x", time.Now())
+	testkit.LogDiff(t, "RevokeToken is of ErrConnection", errors.Is(err, dbms.ErrConnection), true)
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ****************************************************************************
+// Permission-based authorization:
+//
+//	Roles grant permissions per app-supplied PolicyConfig.
+//	Scopes (the space-delimited scope claim) narrow them; a token with scopes
+//	is allowed only what both its roles and its scopes allow, as of OAuth 2.0
+//	clients acting for a user. A token sans scope claim is not narrowed.
+//	A role of a resource ("GMOD@group:42") grants only upon that resource;
+//	see ScopedRole(..). Unscoped roles grant upon every resource.
+//
+// Permissions and scopes are literal ("posts:write"), of a namespace ("posts:*"),
+// or all ("*"). So too the resource of a scoped role ("GMOD@group:*").
+// ****************************************************************************
+
+// PolicyConfig maps roles to the permissions each grants; supplied by the app,
+// typically per JSON:
+//
+//	{"roles": {"UMEM": ["posts:read", "posts:write"], "GMOD": ["group:moderate"], "AOPS": ["*"]}}
+type PolicyConfig struct {
+	Roles map[string][]string `json:"roles"`
+}
+
+// Policy answers whether claims are allowed a permission upon a resource.
+type Policy struct {
+	roles map[string][]string
+}
+
+// NewPolicy returns the Policy of cfg; error on a malformed permission.
+func NewPolicy(cfg PolicyConfig) (*Policy, error) {
+	p := Policy{roles: make(map[string][]string, len(cfg.Roles))}
+	for role, perms := range cfg.Roles {
+		if role == "" || strings.Contains(role, "@") {
+			return nil, errors.Errorf("policy : invalid role %q", role)
+		}
+		for _, perm := range perms {
+			if !validPattern(perm) {
+				return nil, errors.Errorf("policy : role %s : invalid permission %q", role, perm)
+			}
+		}
+		p.roles[role] = append([]string(nil), perms...)
+	}
+	return &p, nil
+}
+
+// ScopedRole returns the role of a resource; a claim of it grants only upon that resource.
+//
+//	claims.Roles = append(claims.Roles, auth.ScopedRole(auth.RoleGrpMod, "group:"+gid))
+func ScopedRole(role, resource string) string {
+	return role + "@" + resource
+}
+
+// Scopes returns the scopes of the scope claim.
+func (c Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// Allowed reports whether claims are allowed perm upon resource (empty if none).
+func (p *Policy) Allowed(c Claims, perm, resource string) bool {
+	if c.Scope != "" && !anyMatch(c.Scopes(), perm) {
+		return false
+	}
+	for _, r := range c.Roles {
+		role, on, scoped := strings.Cut(r, "@")
+		if scoped && (resource == "" || !match(on, resource)) {
+			continue
+		}
+		if anyMatch(p.roles[role], perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// Missing returns those of perms not allowed claims upon resource; empty if all are.
+func (p *Policy) Missing(c Claims, resource string, perms ...string) []string {
+	var missing []string
+	for _, perm := range perms {
+		if !p.Allowed(c, perm, resource) {
+			missing = append(missing, perm)
+		}
+	}
+	return missing
+}
+
+// match reports whether pattern ("*", "ns:*" or literal) matches s.
+func match(pattern, s string) bool {
+	switch {
+	case pattern == "*":
+		return true
+	case strings.HasSuffix(pattern, ":*"):
+		return strings.HasPrefix(s, pattern[:len(pattern)-1])
+	}
+	return pattern == s
+}
+
+func anyMatch(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if match(pattern, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// validPattern reports whether s is a pattern per match(..); a wildcard only as of "*" or "ns:*".
+func validPattern(s string) bool {
+	if s == "" || strings.ContainsAny(s, " @") {
+		return false
+	}
+	i := strings.Index(s, "*")
+	return i < 0 || s == "*" || (i == len(s)-1 && strings.HasSuffix(s, ":*"))
+}
@@ -0,0 +1,51 @@
+package auth_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/sempernow/kit/auth"
+	"github.com/sempernow/kit/testkit"
+)
+
+func TestPolicy(t *testing.T) {
+	var cfg auth.PolicyConfig
+	testkit.Log(t, "Decode config", json.Unmarshal([]byte(`{"roles": {
+		"UMEM": ["posts:read", "posts:write"],
+		"GMOD": ["group:moderate", "posts:*"],
+		"AOPS": ["*"]
+	}}`), &cfg))
+	p, err := auth.NewPolicy(cfg)
+	testkit.Log(t, "New policy", err)
+
+	member := auth.Claims{Roles: []string{auth.RoleUsrMbr, auth.ScopedRole(auth.RoleGrpMod, "group:42")}}
+	tests := []struct {
+		name     string
+		claims   auth.Claims
+		perm     string
+		resource string
+		exp      bool
+	}{
+		{"Granted per role", member, "posts:write", "", true},
+		{"Not granted", member, "users:delete", "", false},
+		{"Scoped role upon its resource", member, "group:moderate", "group:42", true},
+		{"Scoped role upon another resource", member, "group:moderate", "group:7", false},
+		{"Scoped role sans resource", member, "group:moderate", "", false},
+		{"Namespace wildcard", member, "posts:delete", "group:42", true},
+		{"All", auth.Claims{Roles: []string{auth.RoleAppOps}}, "users:delete", "group:7", true},
+		{"Scope narrows", auth.Claims{Roles: member.Roles, Scope: "posts:read"}, "posts:write", "", false},
+		{"Scope admits", auth.Claims{Roles: member.Roles, Scope: "posts:read posts:write"}, "posts:write", "", true},
+		{"Scope alone grants nothing", auth.Claims{Scope: "posts:*"}, "posts:read", "", false},
+		{"Wildcard resource", auth.Claims{Roles: []string{auth.ScopedRole(auth.RoleGrpMod, "group:*")}}, "group:moderate", "group:7", true},
+	}
+	for _, tt := range tests {
+		testkit.LogDiff(t, tt.name, p.Allowed(tt.claims, tt.perm, tt.resource), tt.exp)
+	}
+
+	missing := p.Missing(member, "group:7", "posts:read", "group:moderate", "users:delete")
+	testkit.LogDiff(t, "Missing", strings.Join(missing, ","), "group:moderate,users:delete")
+
+	_, err = auth.NewPolicy(auth.PolicyConfig{Roles: map[string][]string{"X": {"posts*"}}})
+	testkit.LogDiff(t, "Malformed permission refused", err != nil, true)
+}
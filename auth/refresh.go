@@ -0,0 +1,326 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/sempernow/kit/id"
+
+	"github.com/pkg/errors"
+)
+
+// ****************************************************************************
+// Refresh flow per the Token Type scheme (see Refresh and Access):
+//
+//	IssuePair : Access token, and Refresh token whose iss is SHA256(ISS_REF_VAL);
+//	            Set-Cookie __Host-r=ISS_REF_VAL (and __Host-a) of matching TTLs.
+//	Refresh   : Bearer of Refresh token and its reference cookie;
+//	            iss must match the hash of the cookie value, and the token (jti)
+//	            must be unused. Both tokens, and both cookies, are then reissued.
+//
+// Refresh tokens rotate; each is good for one refresh. All of one login are
+// of a family. Reuse of a spent refresh token implies theft of either it or
+// its successor, so the whole family is revoked; the user must login again.
+// ****************************************************************************
+
+var (
+	// ErrRefreshReused is of a refresh token already spent; its family is revoked.
+	ErrRefreshReused = errors.New("refresh token reused : token family revoked")
+	// ErrRefreshUnknown is of a refresh token unknown to the store; revoked or expired.
+	ErrRefreshUnknown = errors.New("refresh token unknown")
+	// ErrRefreshBinding is of a refresh token whose iss does not match its reference cookie.
+	ErrRefreshBinding = errors.New("refresh token does not match its reference cookie")
+)
+
+// Option configures an Auth at New(..).
+type Option func(*Auth)
+
+// WithRefreshStore sets the store of refresh tokens; required by IssuePair(..) and Refresh(..).
+func WithRefreshStore(store RefreshStore) Option {
+	return func(a *Auth) {
+		a.refresh = store
+	}
+}
+
+// WithTTL sets the TTLs of issued Access and Refresh tokens (default AccessTTL and RefreshTTL);
+// that of Refresh tokens is of their family, from login, regardless of rotations.
+func WithTTL(access, refresh time.Duration) Option {
+	return func(a *Auth) {
+		a.accessTTL, a.refreshTTL = access, refresh
+	}
+}
+
+// RefreshRecord is the server-side state of an issued Refresh token.
+type RefreshRecord struct {
+	ID        string // Token's jti.
+	Family    string // jti of the first token of the family (login).
+	Subject   string
+	Issuer    string // Of Access tokens of the family.
+	Mode      string
+	Provider  string
+	ExpiresAt time.Time
+	Used      bool
+	// Started is the issue time of the family (login), whereafter it expires
+	// per the Refresh TTL regardless of rotations.
+	Started time.Time
+}
+
+// RefreshStore persists Refresh tokens for rotation and reuse detection.
+// Implementations must be safe for concurrent use, and Use(..) must be atomic.
+type RefreshStore interface {
+	// Save records an issued token.
+	Save(ctx context.Context, rec RefreshRecord) error
+	// Use marks the token of jti spent and returns its record.
+	// If already spent, it revokes the family and returns ErrRefreshReused;
+	// if unknown or expired, ErrRefreshUnknown.
+	Use(ctx context.Context, jti string) (RefreshRecord, error)
+	// RevokeFamily deletes all tokens of family; e.g., upon logout.
+	RevokeFamily(ctx context.Context, family string) error
+}
+
+// Issued is a token pair along with the values of its reference cookies.
+type Issued struct {
+	TokenPair
+	RefAccess      string
+	RefRefresh     string
+	AccessExpires  time.Time
+	RefreshExpires time.Time
+}
+
+// IssuePair issues a token pair of claims; Subject, Audience, Roles and Key
+// are of both tokens, Issuer of the Access token only. This starts a family.
+//
+//	iss, err := a.IssuePair(ctx, claims, auth.BasicAuth, "")
+//	auth.SetCookies(w, iss)
+//	return web.Respond(ctx, w, iss.TokenPair, http.StatusOK)
+func (a *Auth) IssuePair(ctx context.Context, claims Claims, mode, provider string) (Issued, error) {
+	jti, err := uuid.NewV4()
+	if err != nil {
+		return Issued{}, errors.Wrap(err, "issuing pair")
+	}
+	rec := RefreshRecord{
+		ID:       jti.String(),
+		Family:   jti.String(),
+		Subject:  claims.Subject,
+		Issuer:   claims.Issuer,
+		Mode:     mode,
+		Provider: provider,
+	}
+	return a.issue(ctx, claims, rec)
+}
+
+// Refresh rotates the pair of a Refresh token (tkn) and its reference-cookie value (ref).
+// Roles, Audience and Key carry over from the Refresh token.
+func (a *Auth) Refresh(ctx context.Context, tkn, ref string) (Issued, error) {
+	if a.refresh == nil {
+		return Issued{}, errors.New("refresh : no refresh store")
+	}
+	claims, err := a.ValidateTokenContext(ctx, tkn)
+	if err != nil {
+		return Issued{}, errors.Wrap(err, "refresh")
+	}
+	if claims.TokenType != Refresh {
+		return Issued{}, errors.New("refresh : not a refresh token")
+	}
+	if ref == "" || subtle.ConstantTimeCompare([]byte(claims.Issuer), []byte(id.SumSHA256(ref))) != 1 {
+		return Issued{}, ErrRefreshBinding
+	}
+	rec, err := a.refresh.Use(ctx, claims.Id)
+	if err != nil {
+		return Issued{}, errors.Wrap(err, "refresh")
+	}
+	if rec.Subject != claims.Subject {
+		return Issued{}, errors.Wrap(ErrRefreshUnknown, "refresh : subject mismatch")
+	}
+
+	jti, err := uuid.NewV4()
+	if err != nil {
+		return Issued{}, errors.Wrap(err, "refresh")
+	}
+	rec.ID = jti.String()
+	rec.Used = false
+	claims.Issuer = rec.Issuer
+	return a.issue(ctx, claims, rec)
+}
+
+// RefreshRequest is Refresh(..) per request; its `Authorization: Bearer <TOKEN>` header
+// and refresh-reference cookie (KeyRefRefresh).
+func (a *Auth) RefreshRequest(ctx context.Context, r *http.Request) (Issued, error) {
+	scheme, tkn, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") {
+		return Issued{}, errors.New("refresh : expected authorization header format: Bearer TOKEN")
+	}
+	c, err := r.Cookie(KeyRefRefresh)
+	if err != nil {
+		return Issued{}, errors.Wrap(err, "refresh : reading refresh-token-reference cookie")
+	}
+	return a.Refresh(ctx, tkn, c.Value)
+}
+
+// issue generates the pair of claims per record (rec), and saves the record.
+func (a *Auth) issue(ctx context.Context, claims Claims, rec RefreshRecord) (Issued, error) {
+	if a.refresh == nil {
+		return Issued{}, errors.New("issuing pair : no refresh store")
+	}
+	now := time.Now()
+	if rec.Started.IsZero() {
+		rec.Started = now
+	}
+	iss := Issued{
+		TokenPair:      TokenPair{Mode: rec.Mode, Provider: rec.Provider},
+		AccessExpires:  now.Add(a.accessTTL),
+		RefreshExpires: rec.Started.Add(a.refreshTTL), //... not sliding per rotation.
+	}
+	for _, ref := range []*string{&iss.RefAccess, &iss.RefRefresh} {
+		bb, err := id.Nonce(32)
+		if err != nil {
+			return Issued{}, errors.Wrap(err, "issuing pair")
+		}
+		*ref = base64.RawURLEncoding.EncodeToString(bb)
+	}
+
+	jti, err := uuid.NewV4()
+	if err != nil {
+		return Issued{}, errors.Wrap(err, "issuing pair")
+	}
+	access := claims
+	access.Id = jti.String() //... for revocation per jti.
+	access.TokenType = Access
+	access.IssuedAt = IssuedAt(&now)
+	access.ExpiresAt = iss.AccessExpires.Unix()
+
+	refresh := claims
+	refresh.Id = rec.ID
+	refresh.Issuer = id.SumSHA256(iss.RefRefresh)
+	refresh.TokenType = Refresh
+	refresh.IssuedAt = IssuedAt(&now)
+	refresh.ExpiresAt = iss.RefreshExpires.Unix()
+
+	if iss.A, err = a.GenerateToken(access); err != nil {
+		return Issued{}, errors.Wrap(err, "issuing access token")
+	}
+	if iss.R, err = a.GenerateToken(refresh); err != nil {
+		return Issued{}, errors.Wrap(err, "issuing refresh token")
+	}
+
+	rec.ExpiresAt = iss.RefreshExpires
+	if err := a.refresh.Save(ctx, rec); err != nil {
+		return Issued{}, errors.Wrap(err, "saving refresh token")
+	}
+	return iss, nil
+}
+
+// SetCookies sets the token-reference cookies of an issued pair;
+// each of its token's TTL, per the attributes mandated by the __Host- prefix.
+func SetCookies(w http.ResponseWriter, iss Issued) {
+	http.SetCookie(w, refCookie(KeyRefAccess, iss.RefAccess, iss.AccessExpires))
+	http.SetCookie(w, refCookie(KeyRefRefresh, iss.RefRefresh, iss.RefreshExpires))
+}
+
+// ClearCookies expires the token-reference cookies; e.g., upon logout.
+func ClearCookies(w http.ResponseWriter) {
+	for _, key := range []string{KeyRefAccess, KeyRefRefresh} {
+		c := refCookie(key, "", time.Unix(0, 0))
+		c.MaxAge = -1
+		http.SetCookie(w, c)
+	}
+}
+
+func refCookie(key, val string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     key,
+		Value:    val,
+		Path:     "/",
+		Expires:  expires,
+		MaxAge:   int(time.Until(expires).Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: SameSiteMode,
+	}
+}
+
+// ----------------------------------------------------------------------------
+// MemoryRefreshStore
+
+// MemoryRefreshStore is an in-process RefreshStore; for tests and single-instance services.
+type MemoryRefreshStore struct {
+	mu       sync.Mutex
+	tokens   map[string]RefreshRecord
+	families map[string][]string
+	pruned   time.Time
+}
+
+// NewMemoryRefreshStore returns an empty MemoryRefreshStore.
+func NewMemoryRefreshStore() *MemoryRefreshStore {
+	return &MemoryRefreshStore{
+		tokens:   make(map[string]RefreshRecord),
+		families: make(map[string][]string),
+	}
+}
+
+// Save records an issued token; expired tokens are pruned at most once a minute.
+func (s *MemoryRefreshStore) Save(ctx context.Context, rec RefreshRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now := time.Now(); now.Sub(s.pruned) > time.Minute {
+		s.prune(now)
+		s.pruned = now
+	}
+	s.tokens[rec.ID] = rec
+	s.families[rec.Family] = append(s.families[rec.Family], rec.ID)
+	return nil
+}
+
+// Use marks the token of jti spent and returns its record.
+func (s *MemoryRefreshStore) Use(ctx context.Context, jti string) (RefreshRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.tokens[jti]
+	if !ok || !time.Now().Before(rec.ExpiresAt) {
+		return RefreshRecord{}, ErrRefreshUnknown
+	}
+	if rec.Used {
+		s.revoke(rec.Family)
+		return RefreshRecord{}, ErrRefreshReused
+	}
+	rec.Used = true
+	s.tokens[jti] = rec
+	return rec, nil
+}
+
+// RevokeFamily deletes all tokens of family.
+func (s *MemoryRefreshStore) RevokeFamily(ctx context.Context, family string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoke(family)
+	return nil
+}
+
+func (s *MemoryRefreshStore) revoke(family string) {
+	for _, jti := range s.families[family] {
+		delete(s.tokens, jti)
+	}
+	delete(s.families, family)
+}
+
+// prune deletes families whose tokens are all expired.
+func (s *MemoryRefreshStore) prune(now time.Time) {
+	for family, jtis := range s.families {
+		live := false
+		for _, jti := range jtis {
+			if rec, ok := s.tokens[jti]; ok && now.Before(rec.ExpiresAt) {
+				live = true
+				break
+			}
+		}
+		if !live {
+			s.revoke(family)
+		}
+	}
+}
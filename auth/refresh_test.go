@@ -0,0 +1,86 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sempernow/kit/auth"
+	"github.com/sempernow/kit/id"
+	"github.com/sempernow/kit/testkit"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/pkg/errors"
+)
+
+func TestRefresh(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	store := auth.NewMemoryRefreshStore()
+	a, err := auth.New(key, "k1", "ES256", auth.JWKS("k1", key.Public()), auth.WithRefreshStore(store))
+	testkit.Log(t, "New authenticator", err)
+	ctx := context.Background()
+
+	claims := auth.Claims{
+		StandardClaims: jwt.StandardClaims{Subject: "0x01", Issuer: auth.Issuer(auth.PWA)},
+		Roles:          []string{auth.RoleUsrMbr},
+	}
+	first, err := a.IssuePair(ctx, claims, auth.BasicAuth, "")
+	testkit.Log(t, "Issue pair", err)
+
+	t.Log("@ Issued pair")
+	{
+		access, err := a.ValidateToken(first.A)
+		testkit.Log(t, "Validate access token", err)
+		testkit.LogDiff(t, "Access token type", access.TokenType, auth.Access)
+		testkit.LogDiff(t, "Access token iss", access.Issuer, auth.Issuer(auth.PWA))
+
+		refresh, err := a.ValidateToken(first.R)
+		testkit.Log(t, "Validate refresh token", err)
+		testkit.LogDiff(t, "Refresh token type", refresh.TokenType, auth.Refresh)
+		testkit.LogDiff(t, "Refresh token iss is hash of its cookie", refresh.Issuer, id.SumSHA256(first.RefRefresh))
+
+		w := httptest.NewRecorder()
+		auth.SetCookies(w, first)
+		cookies := w.Result().Cookies()
+		testkit.LogDiff(t, "Two cookies", len(cookies), 2)
+		for _, c := range cookies {
+			testkit.LogDiff(t, c.Name+" per __Host- prefix", c.Secure && c.HttpOnly && c.Path == "/" && c.Domain == "", true)
+		}
+		testkit.LogDiff(t, "Refresh cookie", cookies[1].Name+"="+cookies[1].Value, auth.KeyRefRefresh+"="+first.RefRefresh)
+	}
+	t.Log("@ Binding")
+	{
+		_, err := a.Refresh(ctx, first.R, "forged")
+		testkit.LogDiff(t, "Wrong cookie refused", errors.Cause(err), auth.ErrRefreshBinding)
+		_, err = a.Refresh(ctx, first.A, first.RefRefresh)
+		testkit.LogDiff(t, "Access token refused", err != nil, true)
+	}
+
+	var second auth.Issued
+	t.Log("@ Rotation")
+	{
+		r := httptest.NewRequest("POST", "/auth/refresh", nil)
+		r.Header.Set("Authorization", "Bearer "+first.R)
+		r.AddCookie(&http.Cookie{Name: auth.KeyRefRefresh, Value: first.RefRefresh})
+		second, err = a.RefreshRequest(ctx, r)
+		testkit.Log(t, "Refresh per request", err)
+		testkit.LogDiff(t, "Rotated", second.R != first.R && second.RefRefresh != first.RefRefresh, true)
+		testkit.LogDiff(t, "Mode carries over", second.Mode, auth.BasicAuth)
+
+		access, _ := a.ValidateToken(second.A)
+		testkit.LogDiff(t, "Roles carry over", access.Has(auth.RoleUsrMbr), true)
+		testkit.LogDiff(t, "Access iss carries over", access.Issuer, auth.Issuer(auth.PWA))
+		testkit.LogDiff(t, "Family expiry carries over", second.RefreshExpires.Equal(first.RefreshExpires), true)
+	}
+	t.Log("@ Reuse detection")
+	{
+		_, err := a.Refresh(ctx, first.R, first.RefRefresh)
+		testkit.LogDiff(t, "Spent token refused", errors.Cause(err), auth.ErrRefreshReused)
+		_, err = a.Refresh(ctx, second.R, second.RefRefresh)
+		testkit.LogDiff(t, "Family revoked", errors.Cause(err), auth.ErrRefreshUnknown)
+	}
+}
@@ -0,0 +1,266 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RemoteJWKSConfig declares the parameters of NewRemoteJWKS(..); zero values select defaults.
+type RemoteJWKSConfig struct {
+	// Client of fetches (default of 10s timeout).
+	Client *http.Client
+	// MinRefresh is the least interval between fetches (default 1m);
+	// it rate-limits refetches upon an unknown kid, and floors the cache TTL.
+	MinRefresh time.Duration
+	// TTL of the cached document absent cache headers (default 15m).
+	TTL time.Duration
+	// MaxTTL caps the TTL declared per cache headers (default 24h).
+	MaxTTL time.Duration
+}
+
+// RemoteJWKS is a PubKeyLookup of a JWK Set fetched from a URL (jwks_uri),
+// such as that of a sibling service (KeySet) or an OIDC provider.
+// The document is cached per its Cache-Control (max-age) or Expires headers,
+// revalidated per its ETag, and refetched upon an unknown kid at most once per MinRefresh.
+// If a refetch fails, keys of the stale document remain in use.
+// RSA, EC (P-256, P-384, P-521) and OKP (Ed25519) signing keys are recognized; others are ignored.
+//
+//	remote := auth.NewRemoteJWKS("https://idp.foo.com/.well-known/jwks.json", auth.RemoteJWKSConfig{})
+//	a, err := auth.New(privateKey, kid, "RS256", remote.Lookup)
+type RemoteJWKS struct {
+	url string
+	cfg RemoteJWKSConfig
+
+	mu      sync.RWMutex
+	keys    map[string]crypto.PublicKey
+	etag    string
+	fetched time.Time // Of the last attempt.
+	expires time.Time
+	call    *jwksCall // Of the fetch in flight, if any.
+}
+
+// jwksCall is a fetch in flight, shared by the lookups that await it.
+type jwksCall struct {
+	done chan struct{}
+	err  error
+}
+
+// NewRemoteJWKS returns a RemoteJWKS of url; nothing is fetched until the first lookup.
+func NewRemoteJWKS(url string, cfg RemoteJWKSConfig) *RemoteJWKS {
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.MinRefresh <= 0 {
+		cfg.MinRefresh = time.Minute
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 15 * time.Minute
+	}
+	if cfg.MaxTTL <= 0 {
+		cfg.MaxTTL = 24 * time.Hour
+	}
+	return &RemoteJWKS{url: url, cfg: cfg}
+}
+
+// Lookup is the PubKeyLookup of the remote set;
+// it fetches the document if the cache is expired or lacks kid.
+// Lookups of fresh cached keys never await a fetch; those of stale ones await the
+// refetch (theirs, or that in flight), as do misses, which share one.
+func (rj *RemoteJWKS) Lookup(kid string) (crypto.PublicKey, error) {
+	rj.mu.RLock()
+	key, ok := rj.keys[kid]
+	fresh := time.Now().Before(rj.expires)
+	rj.mu.RUnlock()
+	if ok && fresh {
+		return key, nil
+	}
+
+	if err := rj.refresh(); err != nil && !ok {
+		return nil, errors.Wrapf(err, "key id %q", kid)
+	}
+	rj.mu.RLock()
+	key, ok = rj.keys[kid]
+	rj.mu.RUnlock()
+	if !ok {
+		return nil, errors.Errorf("unrecognized key id %q", kid)
+	}
+	return key, nil //... stale if the refetch failed or was rate-limited.
+}
+
+// refresh fetches the document unless fetched within MinRefresh, or awaits the fetch in flight.
+func (rj *RemoteJWKS) refresh() error {
+	rj.mu.Lock()
+	if c := rj.call; c != nil {
+		rj.mu.Unlock()
+		<-c.done
+		return c.err
+	}
+	now := time.Now()
+	if !rj.fetched.IsZero() && now.Sub(rj.fetched) < rj.cfg.MinRefresh {
+		rj.mu.Unlock()
+		return nil
+	}
+	c := &jwksCall{done: make(chan struct{})}
+	rj.call, rj.fetched = c, now
+	etag := ""
+	if rj.keys != nil {
+		etag = rj.etag
+	}
+	rj.mu.Unlock()
+
+	keys, etag, ttl, err := rj.fetch(etag)
+
+	rj.mu.Lock()
+	if err == nil {
+		if keys != nil {
+			rj.keys, rj.etag = keys, etag
+		}
+		rj.expires = now.Add(ttl)
+	}
+	rj.call = nil
+	rj.mu.Unlock()
+
+	c.err = err
+	close(c.done)
+	return err
+}
+
+// fetch returns the keys of the document, its ETag, and its TTL; nil keys if not
+// modified per etag (If-None-Match). It holds no lock.
+func (rj *RemoteJWKS) fetch(etag string) (map[string]crypto.PublicKey, string, time.Duration, error) {
+	req, err := http.NewRequest(http.MethodGet, rj.url, nil)
+	if err != nil {
+		return nil, "", 0, errors.Wrap(err, "remote jwks : request")
+	}
+	req.Header.Set("Accept", "application/jwk-set+json, application/json")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := rj.cfg.Client.Do(req)
+	if err != nil {
+		return nil, "", 0, errors.Wrap(err, "remote jwks : fetch")
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, etag, rj.ttl(resp.Header), nil
+	case http.StatusOK:
+	default:
+		return nil, "", 0, errors.Errorf("remote jwks : fetch : HTTP %d", resp.StatusCode)
+	}
+
+	var set JWKSet
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+		return nil, "", 0, errors.Wrap(err, "remote jwks : decode")
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Kid == "" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		if key, err := jwk.PublicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	return keys, resp.Header.Get("ETag"), rj.ttl(resp.Header), nil
+}
+
+// ttl returns the freshness lifetime per response headers, within [MinRefresh, MaxTTL].
+func (rj *RemoteJWKS) ttl(hdr http.Header) time.Duration {
+	ttl := rj.cfg.TTL
+	if cc := hdr.Get("Cache-Control"); cc != "" {
+		for _, directive := range strings.Split(cc, ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			switch strings.ToLower(k) {
+			case "no-store", "no-cache":
+				ttl = 0
+			case "max-age":
+				if secs, err := strconv.Atoi(strings.Trim(v, `"`)); err == nil {
+					ttl = time.Duration(secs) * time.Second
+				}
+			}
+		}
+	} else if exp, err := http.ParseTime(hdr.Get("Expires")); err == nil {
+		ttl = time.Until(exp)
+	}
+	if ttl < rj.cfg.MinRefresh {
+		ttl = rj.cfg.MinRefresh
+	}
+	if ttl > rj.cfg.MaxTTL {
+		ttl = rj.cfg.MaxTTL
+	}
+	return ttl
+}
+
+// PublicKey returns the public key of the JWK;
+// *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey.
+func (jwk JWK) PublicKey() (crypto.PublicKey, error) {
+	b64 := func(s string) (*big.Int, error) {
+		bb, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+		if err != nil || len(bb) == 0 {
+			return nil, errors.Errorf("jwk %q : invalid parameter", jwk.Kid)
+		}
+		return new(big.Int).SetBytes(bb), nil
+	}
+	switch jwk.Kty {
+	case "RSA":
+		n, err := b64(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64(jwk.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.Errorf("jwk %q : invalid exponent", jwk.Kid)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.Errorf("jwk %q : unsupported curve %q", jwk.Kid, jwk.Crv)
+		}
+		x, err := b64(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.Errorf("jwk %q : point not on curve", jwk.Kid)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, errors.Errorf("jwk %q : unsupported curve %q", jwk.Kid, jwk.Crv)
+		}
+		bb, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(jwk.X, "="))
+		if err != nil || len(bb) != ed25519.PublicKeySize {
+			return nil, errors.Errorf("jwk %q : invalid parameter", jwk.Kid)
+		}
+		return ed25519.PublicKey(bb), nil
+	}
+	return nil, errors.Errorf("jwk %q : unsupported key type %q", jwk.Kid, jwk.Kty)
+}
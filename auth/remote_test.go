@@ -0,0 +1,166 @@
+package auth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sempernow/kit/auth"
+	"github.com/sempernow/kit/testkit"
+)
+
+func TestRemoteJWKS(t *testing.T) {
+	k1, k2 := newRSAKey(t), newRSAKey(t)
+	ks, err := auth.NewKeySet("RS256")
+	testkit.Log(t, "New key set", err)
+	testkit.Log(t, "Rotate to k1", ks.Rotate(auth.Key{KID: "k1", Private: k1}, 0))
+
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testkit.Log(t, "Generate EC key", err)
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	var hits, revalidated int32
+	cacheControl := "max-age=3600"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		set := ks.JWKSet()
+		set.Keys = append(set.Keys,
+			auth.JWK{Kty: "EC", Use: "sig", Kid: "ec1", Crv: "P-256", X: b64(ec.X.Bytes()), Y: b64(ec.Y.Bytes())},
+			auth.JWK{Kty: "RSA", Use: "enc", Kid: "enc1", N: set.Keys[0].N, E: set.Keys[0].E},
+		)
+		bb, _ := json.Marshal(set)
+		sum := sha256.Sum256(bb)
+		etag := `"` + b64(sum[:8]) + `"`
+		w.Header().Set("Cache-Control", cacheControl)
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			atomic.AddInt32(&revalidated, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write(bb)
+	}))
+	defer srv.Close()
+
+	remote := auth.NewRemoteJWKS(srv.URL, auth.RemoteJWKSConfig{MinRefresh: 50 * time.Millisecond})
+
+	t.Log("@ Fetch and cache")
+	{
+		pub, err := remote.Lookup("k1")
+		testkit.Log(t, "Lookup RSA key", err)
+		testkit.LogDiff(t, "RSA key", k1.PublicKey.Equal(pub), true)
+
+		key, err := remote.Lookup("ec1")
+		testkit.Log(t, "Lookup EC key", err)
+		testkit.LogDiff(t, "EC key", ec.PublicKey.Equal(key), true)
+
+		_, err = remote.Lookup("enc1")
+		testkit.LogDiff(t, "Encryption key ignored", err != nil, true)
+		testkit.LogDiff(t, "Fetched once", atomic.LoadInt32(&hits), int32(1))
+	}
+	t.Log("@ Unknown kid is refetched, rate-limited")
+	{
+		testkit.Log(t, "Rotate to k2", ks.Rotate(auth.Key{KID: "k2", Private: k2}, time.Hour))
+
+		a, err := auth.NewWithKeySet(ks)
+		testkit.Log(t, "Sibling authenticator", err)
+		tkn, err := a.GenerateToken(accessClaims(time.Hour))
+		testkit.Log(t, "Sibling mints token per k2", err)
+
+		_, err = remote.Lookup("k2")
+		testkit.LogDiff(t, "Refetch too soon", err != nil, true)
+		testkit.LogDiff(t, "Not fetched", atomic.LoadInt32(&hits), int32(1))
+
+		time.Sleep(60 * time.Millisecond)
+		v, err := auth.New(k1, "k1", "RS256", remote.Lookup)
+		testkit.Log(t, "Validating authenticator per remote JWKS", err)
+		_, err = v.ValidateToken(tkn)
+		testkit.Log(t, "Validate sibling's token", err)
+		testkit.LogDiff(t, "Refetched", atomic.LoadInt32(&hits), int32(2))
+
+		_, err = remote.Lookup("nope")
+		testkit.LogDiff(t, "Unknown kid", err != nil, true)
+		testkit.LogDiff(t, "Not refetched", atomic.LoadInt32(&hits), int32(2))
+	}
+	t.Log("@ Cache headers")
+	{
+		cacheControl = "no-cache"
+		time.Sleep(60 * time.Millisecond)
+		remote.Lookup("nope") //... revalidates; per no-cache, TTL is floored at MinRefresh.
+		time.Sleep(60 * time.Millisecond)
+
+		_, err := remote.Lookup("k1")
+		testkit.Log(t, "Lookup upon expiry", err)
+		testkit.LogDiff(t, "Revalidated per ETag", atomic.LoadInt32(&revalidated), int32(2))
+
+		srv.Close()
+		time.Sleep(60 * time.Millisecond)
+		_, err = remote.Lookup("k1")
+		testkit.Log(t, "Stale key remains upon failed refetch", err)
+	}
+}
+
+func TestRemoteJWKSConcurrent(t *testing.T) {
+	ks, err := auth.NewKeySet("RS256")
+	testkit.Log(t, "New key set", err)
+	testkit.Log(t, "Rotate to k1", ks.Rotate(auth.Key{KID: "k1", Private: newRSAKey(t)}, 0))
+
+	var hits int32
+	gate := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) > 1 {
+			<-gate //... a slow refetch.
+		}
+		w.Header().Set("Cache-Control", "max-age=3600")
+		json.NewEncoder(w).Encode(ks.JWKSet())
+	}))
+	defer srv.Close()
+
+	remote := auth.NewRemoteJWKS(srv.URL, auth.RemoteJWKSConfig{MinRefresh: time.Millisecond})
+	_, err = remote.Lookup("k1")
+	testkit.Log(t, "Lookup", err)
+
+	testkit.Log(t, "Rotate to k2", ks.Rotate(auth.Key{KID: "k2", Private: newRSAKey(t)}, time.Hour))
+	time.Sleep(5 * time.Millisecond)
+
+	const n = 8
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			_, err := remote.Lookup("k2")
+			errs <- err
+		}()
+	}
+	for atomic.LoadInt32(&hits) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cached := make(chan error, 1)
+	go func() {
+		_, err := remote.Lookup("k1")
+		cached <- err
+	}()
+	select {
+	case err := <-cached:
+		testkit.Log(t, "Cached key sans awaiting the refetch", err)
+	case <-time.After(time.Second):
+		t.Fatalf("\t%s\tLookup of cached key blocked by refetch", testkit.Failure)
+	}
+
+	close(gate)
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("\t%s\tLookup of new key : %v", testkit.Failure, err)
+		}
+	}
+	t.Logf("\t%s\tConcurrent lookups of new key.", testkit.Success)
+	testkit.LogDiff(t, "One refetch shared", atomic.LoadInt32(&hits), int32(2))
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrRevoked is of a token revoked per its jti or subject.
+	ErrRevoked = errors.New("token revoked")
+	// ErrRevocationUnavailable is of a revocation store that could not be reached;
+	// the token is neither valid nor invalid, so respond HTTP 5xx, not 401.
+	ErrRevocationUnavailable = errors.New("revocation store unavailable")
+)
+
+// RevocationStore is a denylist of tokens, per token (jti) or per subject (sub).
+// Revocation of a subject denies all its tokens issued before a not-before time;
+// e.g., upon logout from all devices or a password change.
+// Implementations must be safe for concurrent use.
+type RevocationStore interface {
+	// RevokeToken denies the token of jti; exp is when the token expires regardless.
+	RevokeToken(ctx context.Context, jti string, exp time.Time) error
+	// RevokeSubject denies tokens of subject issued before notBefore;
+	// per the iat of tokens issued here, so less IssuedAtOffset (see RevokedBefore).
+	RevokeSubject(ctx context.Context, subject string, notBefore time.Time) error
+	// Revoked reports whether the token of claims is denied;
+	// the error is ErrRevocationUnavailable (wrapped) if the store could not be reached.
+	Revoked(ctx context.Context, claims Claims) (bool, error)
+}
+
+// WithRevocation sets the store consulted by ValidateTokenContext(..).
+func WithRevocation(store RevocationStore) Option {
+	return func(a *Auth) {
+		a.revocation = store
+	}
+}
+
+// RevokedBefore returns the cutoff of the iat of tokens revoked per subject as of notBefore;
+// less IssuedAtOffset, as of tokens issued here, and of whole seconds. Stores record it
+// once, upon RevokeSubject, and compare the raw iat of tokens, of whatever issuer.
+func RevokedBefore(notBefore time.Time) time.Time {
+	return time.Unix(IssuedAt(&notBefore), 0).UTC()
+}
+
+// ----------------------------------------------------------------------------
+// MemoryRevocationStore
+
+// MemoryRevocationStore is an in-process RevocationStore; for tests and single-instance services.
+type MemoryRevocationStore struct {
+	mu       sync.RWMutex
+	tokens   map[string]time.Time // jti : exp
+	subjects map[string]time.Time // sub : cutoff of iat
+	pruned   time.Time
+}
+
+// NewMemoryRevocationStore returns an empty MemoryRevocationStore.
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		tokens:   make(map[string]time.Time),
+		subjects: make(map[string]time.Time),
+	}
+}
+
+// RevokeToken denies the token of jti until exp; expired entries are pruned at most once a minute.
+func (s *MemoryRevocationStore) RevokeToken(ctx context.Context, jti string, exp time.Time) error {
+	if jti == "" {
+		return errors.New("revoke token : jti cannot be blank")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now := time.Now(); now.Sub(s.pruned) > time.Minute {
+		for k, v := range s.tokens {
+			if !now.Before(v) {
+				delete(s.tokens, k)
+			}
+		}
+		s.pruned = now
+	}
+	s.tokens[jti] = exp
+	return nil
+}
+
+// RevokeSubject denies tokens of subject issued before notBefore; a later revocation supersedes.
+func (s *MemoryRevocationStore) RevokeSubject(ctx context.Context, subject string, notBefore time.Time) error {
+	if subject == "" {
+		return errors.New("revoke subject : subject cannot be blank")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if nbf, cutoff := s.subjects[subject], RevokedBefore(notBefore); cutoff.After(nbf) {
+		s.subjects[subject] = cutoff
+	}
+	return nil
+}
+
+// Revoked reports whether the token of claims is denied.
+func (s *MemoryRevocationStore) Revoked(ctx context.Context, claims Claims) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if exp, ok := s.tokens[claims.Id]; ok && claims.Id != "" && time.Now().Before(exp) {
+		return true, nil
+	}
+	if nbf, ok := s.subjects[claims.Subject]; ok && claims.IssuedAt < nbf.Unix() {
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,84 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/sempernow/kit/auth"
+	"github.com/sempernow/kit/testkit"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/pkg/errors"
+)
+
+// failingStore is a RevocationStore that answers with an error other than of its connection.
+type failingStore struct{ auth.RevocationStore }
+
+func (failingStore) Revoked(context.Context, auth.Claims) (bool, error) {
+	return false, errors.New(`relation "auth_revoked_tokens" does not exist`)
+}
+
+func TestRevocation(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	store := auth.NewMemoryRevocationStore()
+	a, err := auth.New(key, "k1", "EdDSA", auth.JWKS("k1", key.Public()),
+		auth.WithRefreshStore(auth.NewMemoryRefreshStore()), auth.WithRevocation(store))
+	testkit.Log(t, "New authenticator", err)
+	ctx := context.Background()
+
+	claims := accessClaims(time.Hour)
+	one, err := a.IssuePair(ctx, claims, auth.BasicAuth, "")
+	testkit.Log(t, "Issue pair", err)
+	two, err := a.IssuePair(ctx, claims, auth.BasicAuth, "")
+	testkit.Log(t, "Issue another pair", err)
+
+	t.Log("@ Per jti")
+	{
+		c, err := a.ValidateTokenContext(ctx, one.A)
+		testkit.Log(t, "Validate access token", err)
+		testkit.Log(t, "Revoke access token", store.RevokeToken(ctx, c.Id, time.Unix(c.ExpiresAt, 0)))
+		_, err = a.ValidateTokenContext(ctx, one.A)
+		testkit.LogDiff(t, "Revoked", errors.Cause(err), auth.ErrRevoked)
+		_, err = a.ValidateTokenContext(ctx, two.A)
+		testkit.Log(t, "Other token unaffected", err)
+	}
+	t.Log("@ Per subject")
+	{
+		testkit.Log(t, "Revoke subject", store.RevokeSubject(ctx, claims.Subject, time.Now().Add(time.Second)))
+		_, err = a.ValidateTokenContext(ctx, two.A)
+		testkit.LogDiff(t, "Access token revoked", errors.Cause(err), auth.ErrRevoked)
+		_, err = a.Refresh(ctx, two.R, two.RefRefresh)
+		testkit.LogDiff(t, "Refresh token revoked", errors.Cause(err), auth.ErrRevoked)
+
+		time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+		three, err := a.IssuePair(ctx, claims, auth.BasicAuth, "")
+		testkit.Log(t, "Issue pair after not-before", err)
+		_, err = a.ValidateTokenContext(ctx, three.A)
+		testkit.Log(t, "New token valid", err)
+	}
+	t.Log("@ Cutoff of raw iat")
+	{
+		s := auth.NewMemoryRevocationStore()
+		at := time.Now()
+		testkit.Log(t, "Revoke subject", s.RevokeSubject(ctx, "sub", at))
+		cutoff := auth.RevokedBefore(at).Unix()
+		testkit.LogDiff(t, "Cutoff less offset", cutoff, at.Unix()-auth.IssuedAtOffset)
+		revoked, _ := s.Revoked(ctx, auth.Claims{StandardClaims: jwt.StandardClaims{Subject: "sub", IssuedAt: cutoff - 1}})
+		testkit.LogDiff(t, "Before cutoff", revoked, true)
+		revoked, _ = s.Revoked(ctx, auth.Claims{StandardClaims: jwt.StandardClaims{Subject: "sub", IssuedAt: cutoff}})
+		testkit.LogDiff(t, "At cutoff", revoked, false)
+		testkit.Log(t, "Revoke earlier", s.RevokeSubject(ctx, "sub", at.Add(-time.Hour)))
+		revoked, _ = s.Revoked(ctx, auth.Claims{StandardClaims: jwt.StandardClaims{Subject: "sub", IssuedAt: cutoff - 1}})
+		testkit.LogDiff(t, "Later revocation supersedes", revoked, true)
+	}
+	t.Log("@ Store failure")
+	{
+		f, _ := auth.New(key, "k1", "EdDSA", auth.JWKS("k1", key.Public()), auth.WithRevocation(failingStore{}))
+		_, err := f.ValidateTokenContext(ctx, one.A)
+		testkit.LogDiff(t, "Other failure denies", err != nil, true)
+		testkit.LogDiff(t, "Other failure is not unavailable", errors.Cause(err) != auth.ErrRevocationUnavailable, true)
+	}
+}
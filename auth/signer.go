@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"math/big"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/pkg/errors"
+)
+
+// ************************************************************
+// Tokens are signed here per crypto.Signer rather than by jwt,
+// whose methods require concrete key types. So a key held by
+// an HSM or KMS (an opaque crypto.Signer) signs as well as
+// *rsa.PrivateKey, *ecdsa.PrivateKey or ed25519.PrivateKey.
+// Verification remains that of jwt.
+// ************************************************************
+
+// checkKey returns an error unless the public key (pub) is of the kind of algorithm;
+// RSA for RS* and PS*, EC of matching curve for ES*, and Ed25519 for EdDSA.
+func checkKey(algorithm string, pub crypto.PublicKey) error {
+	ok := false
+	switch m := jwt.GetSigningMethod(algorithm).(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		_, ok = pub.(*rsa.PublicKey)
+	case *jwt.SigningMethodECDSA:
+		k, isEC := pub.(*ecdsa.PublicKey)
+		ok = isEC && k.Curve.Params().BitSize == m.CurveBits
+	case *jwt.SigningMethodEd25519:
+		_, ok = pub.(ed25519.PublicKey)
+	default:
+		return errors.Errorf("unsupported algorithm %v", algorithm)
+	}
+	if !ok {
+		return errors.Errorf("key of type %T does not fit algorithm %v", pub, algorithm)
+	}
+	return nil
+}
+
+// sign returns the signed token (JWT) of tkn per key.
+func sign(tkn *jwt.Token, key crypto.Signer) (string, error) {
+	str, err := tkn.SigningString()
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		sig  []byte
+		hash crypto.Hash
+		opts crypto.SignerOpts
+	)
+	switch m := tkn.Method.(type) {
+	case *jwt.SigningMethodRSAPSS:
+		hash = m.Hash
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+	case *jwt.SigningMethodRSA:
+		hash, opts = m.Hash, m.Hash
+	case *jwt.SigningMethodECDSA:
+		hash, opts = m.Hash, m.Hash
+	case *jwt.SigningMethodEd25519:
+		opts = crypto.Hash(0) //... signs the message itself.
+	default:
+		return "", errors.Errorf("unsupported algorithm %v", tkn.Method.Alg())
+	}
+
+	msg := []byte(str)
+	if hash != 0 {
+		h := hash.New()
+		h.Write(msg)
+		msg = h.Sum(nil)
+	}
+	if sig, err = key.Sign(rand.Reader, msg, opts); err != nil {
+		return "", err
+	}
+
+	// ECDSA signers return ASN.1 DER; JWS requires R || S, each of the curve's size.
+	// https://www.rfc-editor.org/rfc/rfc7518#section-3.4
+	if m, ok := tkn.Method.(*jwt.SigningMethodECDSA); ok {
+		var rs struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(sig, &rs); err != nil {
+			return "", errors.Wrap(err, "decoding ecdsa signature")
+		}
+		sig = make([]byte, 2*m.KeySize)
+		rs.R.FillBytes(sig[:m.KeySize])
+		rs.S.FillBytes(sig[m.KeySize:])
+	}
+
+	return str + "." + jwt.EncodeSegment(sig), nil
+}
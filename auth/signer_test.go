@@ -0,0 +1,103 @@
+package auth_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sempernow/kit/auth"
+	"github.com/sempernow/kit/testkit"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// opaque hides the concrete type of a key, as would that of an HSM or KMS.
+type opaque struct{ crypto.Signer }
+
+func TestAlgorithms(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		alg string
+		key crypto.Signer
+	}{
+		{"RS256", rsaKey},
+		{"PS256", rsaKey},
+		{"ES256", p256},
+		{"ES384", p384},
+		{"EdDSA", edKey},
+		{"ES256", opaque{p256}},
+		{"EdDSA", opaque{edKey}},
+	}
+	for _, tt := range tests {
+		t.Logf("@ %s per %T", tt.alg, tt.key)
+		a, err := auth.New(tt.key, "k1", tt.alg, auth.JWKS("k1", tt.key.Public()))
+		testkit.Log(t, "New authenticator", err)
+
+		tkn, err := a.GenerateToken(accessClaims(time.Hour))
+		testkit.Log(t, "Generate token", err)
+		claims, err := a.ValidateToken(tkn)
+		testkit.Log(t, "Validate token", err)
+		testkit.LogDiff(t, "Subject", claims.Subject, "0x01")
+
+		_, err = jwt.Parse(tkn, func(*jwt.Token) (interface{}, error) { return tt.key.Public(), nil })
+		testkit.Log(t, "Signature verifies per jwt", err)
+	}
+
+	t.Log("@ Key must fit algorithm")
+	{
+		_, err := auth.New(p384, "k1", "ES256", auth.JWKS("k1", p384.Public()))
+		testkit.LogDiff(t, "P-384 key for ES256", err != nil, true)
+		_, err = auth.New(edKey, "k1", "RS256", auth.JWKS("k1", edKey.Public()))
+		testkit.LogDiff(t, "Ed25519 key for RS256", err != nil, true)
+		_, err = auth.New(rsaKey, "k1", "HS256", auth.JWKS("k1", rsaKey.Public()))
+		testkit.LogDiff(t, "Symmetric algorithm", err != nil, true)
+	}
+	t.Log("@ Nil keys")
+	{
+		lookup := auth.JWKS("k1", edKey.Public())
+		for name, key := range map[string]crypto.Signer{
+			"Ed25519": ed25519.PrivateKey(nil),
+			"RSA":     (*rsa.PrivateKey)(nil),
+			"ECDSA":   (*ecdsa.PrivateKey)(nil),
+		} {
+			_, err := auth.New(key, "k1", "EdDSA", lookup)
+			testkit.LogDiff(t, "Nil "+name+" key", err != nil, true)
+		}
+	}
+	t.Log("@ Algorithm is pinned")
+	{
+		es, _ := auth.New(p256, "k1", "ES256", auth.JWKS("k1", p256.Public()))
+		ed, _ := auth.New(edKey, "k1", "EdDSA", auth.JWKS("k1", p256.Public()))
+		tkn, _ := ed.GenerateToken(accessClaims(time.Hour))
+		_, err := es.ValidateToken(tkn)
+		testkit.LogDiff(t, "EdDSA token refused by ES256 authenticator", err != nil, true)
+	}
+	t.Log("@ EdDSA key set per remote JWKS")
+	{
+		ks, err := auth.NewKeySet("EdDSA")
+		testkit.Log(t, "New key set", err)
+		testkit.LogDiff(t, "RSA key refused", ks.Add(auth.Key{KID: "rsa", Private: rsaKey}) != nil, true)
+		testkit.Log(t, "Rotate to Ed25519 key", ks.Rotate(auth.Key{KID: "ed1", Private: edKey}, 0))
+
+		srv := httptest.NewServer(ks)
+		defer srv.Close()
+		remote := auth.NewRemoteJWKS(srv.URL, auth.RemoteJWKSConfig{})
+
+		signer, _ := auth.NewWithKeySet(ks)
+		verifier, _ := auth.New(edKey, "ed1", "EdDSA", remote.Lookup)
+		tkn, err := signer.GenerateToken(accessClaims(time.Hour))
+		testkit.Log(t, "Generate token", err)
+		_, err = verifier.ValidateToken(tkn)
+		testkit.Log(t, "Validate token per remote JWKS", err)
+	}
+}
@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/pkg/errors"
+)
+
+// ****************************************************************************
+// Claims are validated here rather than per jwt.StandardClaims.Valid(),
+// which has no allowance for clock skew; hence the IssuedAtOffset hack of issuers.
+// WithLeeway(..) is the remedy at the verifier.
+// ****************************************************************************
+
+// validator holds the claims-validation options of an Auth.
+type validator struct {
+	audiences []string
+	issuers   []string
+	types     []string
+	leeway    time.Duration
+	maxAge    time.Duration
+	required  []string
+}
+
+// WithAudience requires the aud claim be one of audiences.
+func WithAudience(audiences ...string) Option {
+	return func(a *Auth) {
+		a.v.audiences = append(a.v.audiences, audiences...)
+	}
+}
+
+// WithIssuer requires the iss claim be one of issuers.
+// Refresh tokens are exempt, their iss binding them to their reference cookie.
+func WithIssuer(issuers ...string) Option {
+	return func(a *Auth) {
+		a.v.issuers = append(a.v.issuers, issuers...)
+	}
+}
+
+// WithTokenTypes requires the tokenType claim be one of types (Access, Refresh);
+// Refresh(..) requires Refresh be among them.
+func WithTokenTypes(types ...string) Option {
+	return func(a *Auth) {
+		a.v.types = append(a.v.types, types...)
+	}
+}
+
+// WithLeeway allows for clock skew between issuer and verifier
+// at the exp, nbf and iat claims.
+func WithLeeway(leeway time.Duration) Option {
+	return func(a *Auth) {
+		a.v.leeway = leeway
+	}
+}
+
+// WithMaxAge requires the iat claim, and rejects tokens issued longer ago than maxAge,
+// regardless of their exp claim.
+func WithMaxAge(maxAge time.Duration) Option {
+	return func(a *Auth) {
+		a.v.maxAge = maxAge
+	}
+}
+
+// WithRequiredClaims requires the claims of names be present (not zero) in the token.
+// Those of Claims, registered (sub, aud, iss, jti, exp, iat, nbf) or not (roles,
+// tokenType, key, scope), are checked as parsed; any other (custom) claim is checked
+// in the raw claims of the token, wherein it is present unless absent or null.
+func WithRequiredClaims(names ...string) Option {
+	return func(a *Auth) {
+		a.v.required = append(a.v.required, names...)
+	}
+}
+
+// check returns an error if the options are invalid.
+func (v validator) check() error {
+	for _, name := range v.required {
+		if name == "" {
+			return errors.New("required claim name cannot be empty")
+		}
+	}
+	if v.leeway < 0 || v.maxAge < 0 {
+		return errors.New("leeway and max age cannot be negative")
+	}
+	return nil
+}
+
+// validate returns an error unless claims are valid at now.
+func (v validator) validate(c Claims, now time.Time) error {
+	t, skew := now.Unix(), int64(v.leeway/time.Second)
+
+	if c.ExpiresAt != 0 && t > c.ExpiresAt+skew {
+		return errors.Errorf("token is expired by %v", time.Duration(t-c.ExpiresAt)*time.Second)
+	}
+	if c.IssuedAt != 0 && t < c.IssuedAt-skew {
+		return errors.New("token used before issued")
+	}
+	if c.NotBefore != 0 && t < c.NotBefore-skew {
+		return errors.New("token is not valid yet")
+	}
+	if v.maxAge > 0 {
+		if c.IssuedAt == 0 {
+			return errors.New("token lacks iat claim")
+		}
+		if t > c.IssuedAt+int64(v.maxAge/time.Second)+skew {
+			return errors.New("token exceeds max age")
+		}
+	}
+
+	if len(v.audiences) > 0 && !oneOf(c.Audience, v.audiences) {
+		return errors.Errorf("token audience %q not accepted", c.Audience)
+	}
+	if len(v.issuers) > 0 && c.TokenType != Refresh && !oneOf(c.Issuer, v.issuers) {
+		return errors.Errorf("token issuer %q not accepted", c.Issuer)
+	}
+	if len(v.types) > 0 && !oneOf(c.TokenType, v.types) {
+		return errors.Errorf("token type %q not accepted", c.TokenType)
+	}
+	for _, name := range v.required {
+		if ok, known := present(c, name); known && !ok {
+			return errors.Errorf("token lacks required claim %q", name)
+		}
+	}
+	return nil
+}
+
+// validateCustom returns an error unless the raw claims of token hold
+// each required claim unknown to Claims.
+func (v validator) validateCustom(token *jwt.Token) error {
+	var custom []string
+	for _, name := range v.required {
+		if _, known := present(Claims{}, name); !known {
+			custom = append(custom, name)
+		}
+	}
+	if len(custom) == 0 {
+		return nil
+	}
+	parts := strings.Split(token.Raw, ".")
+	if len(parts) != 3 {
+		return errors.New("token is malformed")
+	}
+	seg, err := jwt.DecodeSegment(parts[1])
+	if err != nil {
+		return errors.Wrap(err, "decoding token claims")
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(seg, &raw); err != nil {
+		return errors.Wrap(err, "decoding token claims")
+	}
+	for _, name := range custom {
+		if val, ok := raw[name]; !ok || string(val) == "null" {
+			return errors.Errorf("token lacks required claim %q", name)
+		}
+	}
+	return nil
+}
+
+// present reports whether the claim of name is set, and whether name is known.
+func present(c Claims, name string) (set, known bool) {
+	switch name {
+	case "sub":
+		return c.Subject != "", true
+	case "aud":
+		return c.Audience != "", true
+	case "iss":
+		return c.Issuer != "", true
+	case "jti":
+		return c.Id != "", true
+	case "exp":
+		return c.ExpiresAt != 0, true
+	case "iat":
+		return c.IssuedAt != 0, true
+	case "nbf":
+		return c.NotBefore != 0, true
+	case "roles":
+		return len(c.Roles) > 0, true
+	case "tokenType":
+		return c.TokenType != "", true
+	case "key":
+		return c.Key != "", true
+	case "scope":
+		return c.Scope != "", true
+	}
+	return false, false
+}
+
+func oneOf(s string, list []string) bool {
+	for _, x := range list {
+		if s == x {
+			return true
+		}
+	}
+	return false
+}
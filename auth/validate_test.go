@@ -0,0 +1,102 @@
+package auth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sempernow/kit/auth"
+	"github.com/sempernow/kit/testkit"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func TestValidateClaims(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	lookup := auth.JWKS("k1", key.Public())
+	now := time.Now()
+
+	base := func() auth.Claims {
+		return auth.Claims{
+			StandardClaims: jwt.StandardClaims{
+				Subject:   "0x01",
+				Audience:  "api",
+				Issuer:    "idp",
+				ExpiresAt: now.Add(time.Hour).Unix(),
+				IssuedAt:  now.Unix(),
+			},
+			TokenType: auth.Access,
+		}
+	}
+	tests := []struct {
+		name   string
+		opts   []auth.Option
+		modify func(*auth.Claims)
+		errMsg string // Empty if valid.
+	}{
+		{"Sans options", nil, func(c *auth.Claims) {}, ""},
+		{"Expired", nil, func(c *auth.Claims) { c.ExpiresAt = now.Add(-5 * time.Second).Unix() }, "token is expired"},
+		{"Expired within leeway", []auth.Option{auth.WithLeeway(30 * time.Second)},
+			func(c *auth.Claims) { c.ExpiresAt = now.Add(-5 * time.Second).Unix() }, ""},
+		{"Issued in future", nil, func(c *auth.Claims) { c.IssuedAt = now.Add(5 * time.Second).Unix() }, "used before issued"},
+		{"Issued in future within leeway", []auth.Option{auth.WithLeeway(30 * time.Second)},
+			func(c *auth.Claims) { c.IssuedAt = now.Add(5 * time.Second).Unix() }, ""},
+		{"Not yet valid", nil, func(c *auth.Claims) { c.NotBefore = now.Add(time.Minute).Unix() }, "not valid yet"},
+		{"Audience", []auth.Option{auth.WithAudience("web", "api")}, func(c *auth.Claims) {}, ""},
+		{"Audience refused", []auth.Option{auth.WithAudience("web")}, func(c *auth.Claims) {}, "audience"},
+		{"Issuer refused", []auth.Option{auth.WithIssuer("other")}, func(c *auth.Claims) {}, "issuer"},
+		{"Issuer exempt of refresh token", []auth.Option{auth.WithIssuer("other")},
+			func(c *auth.Claims) { c.TokenType = auth.Refresh }, ""},
+		{"Token type refused", []auth.Option{auth.WithTokenTypes(auth.Access)},
+			func(c *auth.Claims) { c.TokenType = auth.Refresh }, "token type"},
+		{"Max age", []auth.Option{auth.WithMaxAge(time.Hour)}, func(c *auth.Claims) {}, ""},
+		{"Max age exceeded", []auth.Option{auth.WithMaxAge(time.Hour)},
+			func(c *auth.Claims) { c.IssuedAt = now.Add(-2 * time.Hour).Unix() }, "max age"},
+		{"Max age requires iat", []auth.Option{auth.WithMaxAge(time.Hour)}, func(c *auth.Claims) { c.IssuedAt = 0 }, "iat"},
+		{"Required claims", []auth.Option{auth.WithRequiredClaims("sub", "aud", "tokenType")}, func(c *auth.Claims) {}, ""},
+		{"Required claim absent", []auth.Option{auth.WithRequiredClaims("roles")}, func(c *auth.Claims) {}, `"roles"`},
+	}
+	for _, tt := range tests {
+		a, err := auth.New(key, "k1", "ES256", lookup, tt.opts...)
+		testkit.Log(t, tt.name+" : New authenticator", err)
+		c := base()
+		tt.modify(&c)
+		tkn, err := a.GenerateToken(c)
+		testkit.Log(t, tt.name+" : Generate token", err)
+
+		_, err = a.ValidateToken(tkn)
+		switch tt.errMsg {
+		case "":
+			testkit.Log(t, tt.name, err)
+		default:
+			testkit.LogDiff(t, tt.name+" : "+tt.errMsg, err != nil && strings.Contains(err.Error(), tt.errMsg), true)
+		}
+	}
+
+	_, err := auth.New(key, "k1", "ES256", lookup, auth.WithRequiredClaims(""))
+	testkit.LogDiff(t, "Empty required claim refused", err != nil, true)
+
+	t.Log("@ Custom claims")
+	{
+		a, err := auth.New(key, "k1", "ES256", lookup, auth.WithRequiredClaims("sub", "tenant"))
+		testkit.Log(t, "New authenticator", err)
+		sign := func(claims jwt.MapClaims) string {
+			tkn := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+			tkn.Header["kid"] = "k1"
+			str, err := tkn.SignedString(key)
+			testkit.Log(t, "Sign token", err)
+			return str
+		}
+		exp := now.Add(time.Hour).Unix()
+
+		_, err = a.ValidateToken(sign(jwt.MapClaims{"sub": "0x01", "exp": exp, "tenant": "acme"}))
+		testkit.Log(t, "Custom claim present", err)
+		_, err = a.ValidateToken(sign(jwt.MapClaims{"sub": "0x01", "exp": exp}))
+		testkit.LogDiff(t, "Custom claim absent", err != nil && strings.Contains(err.Error(), `"tenant"`), true)
+		_, err = a.ValidateToken(sign(jwt.MapClaims{"sub": "0x01", "exp": exp, "tenant": nil}))
+		testkit.LogDiff(t, "Custom claim null", err != nil && strings.Contains(err.Error(), `"tenant"`), true)
+	}
+}
@@ -0,0 +1,777 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sempernow/kit/id"
+
+	"github.com/pkg/errors"
+)
+
+// ****************************************************************************
+// WebAuthn (passkeys) relying party per https://www.w3.org/TR/webauthn-2/
+//
+//	Registration : BeginRegistration -> navigator.credentials.create(options)
+//	               -> FinishRegistration saves the credential (public key).
+//	Login        : BeginLogin -> navigator.credentials.get(options)
+//	               -> FinishLogin verifies the assertion; then IssuePair.
+//
+// Each Begin stores a one-time challenge per session id, which the app relays
+// to its Finish; e.g., per cookie or response body. Options and responses are
+// of the JSON forms of WebAuthn Level 3 (binary fields per base64url), as are
+// PublicKeyCredential.toJSON() and parseCreationOptionsFromJSON() of browsers.
+//
+// Attestation formats "none" and "packed" (self and basic) are verified.
+// Trust of attestation certificates (e.g., per FIDO MDS) is left to the app;
+// see WebAuthnCredential.AttestationType.
+// ****************************************************************************
+
+// WebAuthn ceremonies; the type member of client data.
+const (
+	CeremonyCreate = "webauthn.create"
+	CeremonyGet    = "webauthn.get"
+)
+
+// Authenticator-data flags.
+const (
+	flagUP = 0x01 // User present
+	flagUV = 0x04 // User verified
+	flagAT = 0x40 // Attested credential data included
+	flagED = 0x80 // Extension data included
+)
+
+// oidAAGUID is of the AAGUID extension of packed attestation certificates.
+var oidAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+var (
+	// ErrChallengeUnknown is of a session whose challenge is unknown, used or expired.
+	ErrChallengeUnknown = errors.New("webauthn : challenge unknown or expired")
+	// ErrWebAuthnCredentialNotFound is returned by a WebAuthnStore of an unknown credential.
+	ErrWebAuthnCredentialNotFound = errors.New("webauthn : credential not found")
+	// ErrWebAuthnCounter is of an assertion whose signature counter did not increase;
+	// the authenticator may be cloned.
+	ErrWebAuthnCounter = errors.New("webauthn : signature counter did not increase")
+)
+
+// Base64URL is bytes of JSON per base64url (RFC 4648 section 5) sans padding.
+type Base64URL []byte
+
+// MarshalJSON encodes b per base64url sans padding.
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// UnmarshalJSON decodes b per base64url with or sans padding.
+func (b *Base64URL) UnmarshalJSON(bb []byte) error {
+	var s string
+	if err := json.Unmarshal(bb, &s); err != nil {
+		return err
+	}
+	dec, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return errors.Wrap(err, "base64url")
+	}
+	*b = dec
+	return nil
+}
+
+// WebAuthnConfig declares the parameters of NewPasskeys(..); zero values select defaults.
+type WebAuthnConfig struct {
+	// RPID is the relying party's domain (e.g., "foo.com"); credentials are bound to it.
+	RPID   string
+	RPName string
+	// Origins allowed of client data (e.g., "https://foo.com").
+	Origins []string
+	// Timeout of ceremonies, and the TTL of their challenges (default 5m).
+	Timeout time.Duration
+	// RequireUserVerification requires the authenticator verify the user (PIN, biometric);
+	// else user presence suffices.
+	RequireUserVerification bool
+	// Algorithms of credentials, in order of preference (default ES256, EdDSA, RS256).
+	Algorithms []int64
+	// Attestation conveyance: "none" (default) or "direct".
+	Attestation string
+}
+
+// WebAuthnUser is the user of a registration.
+// Subject is the user handle; opaque, at most 64 bytes, and not personally identifying.
+type WebAuthnUser struct {
+	Subject     string
+	Name        string // e.g., "foo@bar.com"
+	DisplayName string
+}
+
+// WebAuthnCredential is a registered credential (passkey) of a user.
+type WebAuthnCredential struct {
+	ID              []byte    `json:"id"`
+	Subject         string    `json:"subject"`
+	PublicKey       []byte    `json:"public_key"` // COSE_Key
+	SignCount       uint32    `json:"sign_count"`
+	AAGUID          []byte    `json:"aaguid"`
+	AttestationType string    `json:"attestation_type"` // "none", "self" or "basic".
+	Transports      []string  `json:"transports,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// WebAuthnStore persists registered credentials.
+// Lookup returns ErrWebAuthnCredentialNotFound if the credential is unknown.
+type WebAuthnStore interface {
+	Save(ctx context.Context, cred WebAuthnCredential) error
+	Lookup(ctx context.Context, id []byte) (WebAuthnCredential, error)
+	List(ctx context.Context, subject string) ([]WebAuthnCredential, error)
+	UpdateSignCount(ctx context.Context, id []byte, count uint32) error
+}
+
+// Challenge is the state of a ceremony between its Begin and Finish.
+type Challenge struct {
+	Value     []byte
+	Subject   string // Empty of a login sans user (discoverable credentials).
+	Ceremony  string // CeremonyCreate or CeremonyGet
+	ExpiresAt time.Time
+}
+
+// ChallengeStore holds challenges per session id.
+// Take returns and deletes the challenge of session; ErrChallengeUnknown if none or expired.
+type ChallengeStore interface {
+	Put(ctx context.Context, session string, c Challenge) error
+	Take(ctx context.Context, session string) (Challenge, error)
+}
+
+// CredentialParameter is of PublicKeyCredentialCreationOptions.pubKeyCredParams.
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+// CredentialDescriptor is of the excludeCredentials and allowCredentials options.
+type CredentialDescriptor struct {
+	Type       string    `json:"type"`
+	ID         Base64URL `json:"id"`
+	Transports []string  `json:"transports,omitempty"`
+}
+
+// CreationOptions is PublicKeyCredentialCreationOptionsJSON; the options of
+// navigator.credentials.create({publicKey: ...}).
+type CreationOptions struct {
+	RP struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          Base64URL `json:"id"`
+		Name        string    `json:"name"`
+		DisplayName string    `json:"displayName"`
+	} `json:"user"`
+	Challenge              Base64URL              `json:"challenge"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"` // Milliseconds
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials,omitempty"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+}
+
+// RequestOptions is PublicKeyCredentialRequestOptionsJSON; the options of
+// navigator.credentials.get({publicKey: ...}).
+type RequestOptions struct {
+	Challenge        Base64URL              `json:"challenge"`
+	Timeout          int64                  `json:"timeout"` // Milliseconds
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials,omitempty"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// RegistrationResponse is RegistrationResponseJSON; PublicKeyCredential.toJSON() of create().
+type RegistrationResponse struct {
+	ID       string    `json:"id"`
+	RawID    Base64URL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AttestationObject Base64URL `json:"attestationObject"`
+		Transports        []string  `json:"transports,omitempty"`
+	} `json:"response"`
+}
+
+// AssertionResponse is AuthenticationResponseJSON; PublicKeyCredential.toJSON() of get().
+type AssertionResponse struct {
+	ID       string    `json:"id"`
+	RawID    Base64URL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AuthenticatorData Base64URL `json:"authenticatorData"`
+		Signature         Base64URL `json:"signature"`
+		UserHandle        Base64URL `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// Passkeys is a relying party of WebAuthn ceremonies.
+type Passkeys struct {
+	cfg        WebAuthnConfig
+	rpIDHash   [32]byte
+	creds      WebAuthnStore
+	challenges ChallengeStore
+}
+
+// NewPasskeys returns the Passkeys of cfg per its stores of credentials and challenges.
+//
+//	pk, err := auth.NewPasskeys(auth.WebAuthnConfig{
+//		RPID:    "foo.com",
+//		RPName:  "Foo",
+//		Origins: []string{"https://foo.com"},
+//	}, creds, auth.NewMemoryChallengeStore())
+func NewPasskeys(cfg WebAuthnConfig, creds WebAuthnStore, challenges ChallengeStore) (*Passkeys, error) {
+	if cfg.RPID == "" || len(cfg.Origins) == 0 {
+		return nil, errors.New("webauthn : rp id and origins are required")
+	}
+	if creds == nil || challenges == nil {
+		return nil, errors.New("webauthn : stores cannot be nil")
+	}
+	if cfg.RPName == "" {
+		cfg.RPName = cfg.RPID
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Minute
+	}
+	if len(cfg.Algorithms) == 0 {
+		cfg.Algorithms = []int64{COSEAlgES256, COSEAlgEdDSA, COSEAlgRS256}
+	}
+	switch cfg.Attestation {
+	case "":
+		cfg.Attestation = "none"
+	case "none", "direct":
+	default:
+		return nil, errors.Errorf("webauthn : attestation %q not supported", cfg.Attestation)
+	}
+	return &Passkeys{
+		cfg:        cfg,
+		rpIDHash:   sha256.Sum256([]byte(cfg.RPID)),
+		creds:      creds,
+		challenges: challenges,
+	}, nil
+}
+
+// BeginRegistration returns the session id and creation options of registering a credential of user.
+// Credentials already registered of the user are excluded.
+func (pk *Passkeys) BeginRegistration(ctx context.Context, user WebAuthnUser) (string, CreationOptions, error) {
+	if user.Subject == "" || len(user.Subject) > 64 {
+		return "", CreationOptions{}, errors.New("webauthn : user subject must be of 1 to 64 bytes")
+	}
+	existing, err := pk.creds.List(ctx, user.Subject)
+	if err != nil {
+		return "", CreationOptions{}, errors.Wrap(err, "webauthn : listing credentials")
+	}
+	session, c, err := pk.begin(ctx, user.Subject, CeremonyCreate)
+	if err != nil {
+		return "", CreationOptions{}, err
+	}
+
+	var opts CreationOptions
+	opts.RP.ID, opts.RP.Name = pk.cfg.RPID, pk.cfg.RPName
+	opts.User.ID, opts.User.Name, opts.User.DisplayName = Base64URL(user.Subject), user.Name, user.DisplayName
+	if opts.User.DisplayName == "" {
+		opts.User.DisplayName = user.Name
+	}
+	opts.Challenge = c.Value
+	for _, alg := range pk.cfg.Algorithms {
+		opts.PubKeyCredParams = append(opts.PubKeyCredParams, CredentialParameter{Type: "public-key", Alg: alg})
+	}
+	opts.Timeout = pk.cfg.Timeout.Milliseconds()
+	opts.ExcludeCredentials = descriptors(existing)
+	opts.AuthenticatorSelection.ResidentKey = "preferred"
+	opts.AuthenticatorSelection.UserVerification = pk.userVerification()
+	opts.Attestation = pk.cfg.Attestation
+	return session, opts, nil
+}
+
+// FinishRegistration verifies the response of the registration of session, and saves its credential.
+func (pk *Passkeys) FinishRegistration(ctx context.Context, session string, resp RegistrationResponse) (WebAuthnCredential, error) {
+	c, err := pk.challenges.Take(ctx, session)
+	if err != nil {
+		return WebAuthnCredential{}, err
+	}
+	if c.Ceremony != CeremonyCreate {
+		return WebAuthnCredential{}, ErrChallengeUnknown
+	}
+	if resp.Type != "public-key" {
+		return WebAuthnCredential{}, errors.Errorf("webauthn : credential type %q not supported", resp.Type)
+	}
+	if err := pk.verifyClientData(resp.Response.ClientDataJSON, CeremonyCreate, c.Value); err != nil {
+		return WebAuthnCredential{}, err
+	}
+
+	item, rest, err := decodeCBOR(resp.Response.AttestationObject)
+	if err != nil || len(rest) != 0 {
+		return WebAuthnCredential{}, errors.New("webauthn : malformed attestation object")
+	}
+	obj, err := cborMap(item)
+	if err != nil {
+		return WebAuthnCredential{}, errors.Wrap(err, "webauthn : attestation object")
+	}
+	format, _ := obj["fmt"].(string)
+	stmt, err := cborMap(obj["attStmt"])
+	if err != nil {
+		return WebAuthnCredential{}, errors.Wrap(err, "webauthn : attestation statement")
+	}
+	rawAuthData, err := cborBytes(obj, "authData")
+	if err != nil {
+		return WebAuthnCredential{}, errors.Wrap(err, "webauthn : authenticator data")
+	}
+	ad, err := pk.parseAuthData(rawAuthData)
+	if err != nil {
+		return WebAuthnCredential{}, err
+	}
+	if ad.flags&flagAT == 0 {
+		return WebAuthnCredential{}, errors.New("webauthn : authenticator data lacks attested credential")
+	}
+	if !bytes.Equal(ad.credID, resp.RawID) {
+		return WebAuthnCredential{}, errors.New("webauthn : credential id mismatch")
+	}
+	pub, alg, err := parseCOSEKey(ad.credKey)
+	if err != nil {
+		return WebAuthnCredential{}, errors.Wrap(err, "webauthn")
+	}
+	if !int64OneOf(alg, pk.cfg.Algorithms) {
+		return WebAuthnCredential{}, errors.Errorf("webauthn : credential alg %d not accepted", alg)
+	}
+
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)
+	var attType string
+	switch format {
+	case "none":
+		if len(stmt) != 0 {
+			return WebAuthnCredential{}, errors.New("webauthn : none attestation has a statement")
+		}
+		attType = "none"
+	case "packed":
+		if attType, err = verifyPacked(stmt, signed, ad.aaguid, pub, alg); err != nil {
+			return WebAuthnCredential{}, err
+		}
+	default:
+		return WebAuthnCredential{}, errors.Errorf("webauthn : attestation format %q not supported", format)
+	}
+
+	if _, err := pk.creds.Lookup(ctx, ad.credID); err == nil {
+		return WebAuthnCredential{}, errors.New("webauthn : credential already registered")
+	} else if errors.Cause(err) != ErrWebAuthnCredentialNotFound {
+		return WebAuthnCredential{}, errors.Wrap(err, "webauthn : lookup")
+	}
+	cred := WebAuthnCredential{
+		ID:              ad.credID,
+		Subject:         c.Subject,
+		PublicKey:       ad.credKey,
+		SignCount:       ad.signCount,
+		AAGUID:          ad.aaguid,
+		AttestationType: attType,
+		Transports:      resp.Response.Transports,
+		CreatedAt:       time.Now().UTC(),
+	}
+	if err := pk.creds.Save(ctx, cred); err != nil {
+		return WebAuthnCredential{}, errors.Wrap(err, "webauthn : saving credential")
+	}
+	return cred, nil
+}
+
+// BeginLogin returns the session id and request options of a login of subject;
+// of any discoverable credential (passkey) if subject is empty.
+func (pk *Passkeys) BeginLogin(ctx context.Context, subject string) (string, RequestOptions, error) {
+	var allow []CredentialDescriptor
+	if subject != "" {
+		creds, err := pk.creds.List(ctx, subject)
+		if err != nil {
+			return "", RequestOptions{}, errors.Wrap(err, "webauthn : listing credentials")
+		}
+		if len(creds) == 0 {
+			return "", RequestOptions{}, ErrWebAuthnCredentialNotFound
+		}
+		allow = descriptors(creds)
+	}
+	session, c, err := pk.begin(ctx, subject, CeremonyGet)
+	if err != nil {
+		return "", RequestOptions{}, err
+	}
+	return session, RequestOptions{
+		Challenge:        c.Value,
+		Timeout:          pk.cfg.Timeout.Milliseconds(),
+		RPID:             pk.cfg.RPID,
+		AllowCredentials: allow,
+		UserVerification: pk.userVerification(),
+	}, nil
+}
+
+// FinishLogin verifies the assertion of the login of session, and returns the claims of its user;
+// those of an Access token per WebAuthn mode, sans roles. The app adds roles, then issues its pair.
+//
+//	claims, err := pk.FinishLogin(ctx, session, resp)
+//	claims.Roles = user.Roles
+//	iss, err := pk.IssuePair(ctx, a, claims)
+func (pk *Passkeys) FinishLogin(ctx context.Context, session string, resp AssertionResponse) (Claims, error) {
+	c, err := pk.challenges.Take(ctx, session)
+	if err != nil {
+		return Claims{}, err
+	}
+	if c.Ceremony != CeremonyGet {
+		return Claims{}, ErrChallengeUnknown
+	}
+	if resp.Type != "public-key" {
+		return Claims{}, errors.Errorf("webauthn : credential type %q not supported", resp.Type)
+	}
+	cred, err := pk.creds.Lookup(ctx, resp.RawID)
+	if err != nil {
+		return Claims{}, errors.Wrap(err, "webauthn")
+	}
+	handle := resp.Response.UserHandle
+	switch {
+	case c.Subject != "" && c.Subject != cred.Subject:
+		return Claims{}, errors.New("webauthn : credential not of the user")
+	case c.Subject == "" && len(handle) == 0:
+		return Claims{}, errors.New("webauthn : user handle required of a discoverable login")
+	case len(handle) != 0 && string(handle) != cred.Subject:
+		return Claims{}, errors.New("webauthn : user handle mismatch")
+	}
+	if err := pk.verifyClientData(resp.Response.ClientDataJSON, CeremonyGet, c.Value); err != nil {
+		return Claims{}, err
+	}
+	ad, err := pk.parseAuthData(resp.Response.AuthenticatorData)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	pub, alg, err := parseCOSEKey(cred.PublicKey)
+	if err != nil {
+		return Claims{}, errors.Wrap(err, "webauthn : stored credential")
+	}
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(append([]byte(nil), resp.Response.AuthenticatorData...), clientDataHash[:]...)
+	if err := verifyCOSE(alg, pub, signed, resp.Response.Signature); err != nil {
+		return Claims{}, errors.Wrap(err, "webauthn : assertion")
+	}
+
+	// Authenticators sans counter report zero always; else it must increase.
+	if ad.signCount != 0 || cred.SignCount != 0 {
+		if ad.signCount <= cred.SignCount {
+			return Claims{}, ErrWebAuthnCounter
+		}
+		if err := pk.creds.UpdateSignCount(ctx, cred.ID, ad.signCount); err != nil {
+			return Claims{}, errors.Wrap(err, "webauthn : updating signature counter")
+		}
+	}
+	return credentialClaims(Credential{Subject: cred.Subject}, WebAuthn), nil
+}
+
+// IssuePair issues our own token pair of claims per a WebAuthn login; of Mode WebAuthn.
+// See Auth.IssuePair(..).
+func (pk *Passkeys) IssuePair(ctx context.Context, a *Auth, claims Claims) (Issued, error) {
+	return a.IssuePair(ctx, claims, WebAuthn, "")
+}
+
+// begin stores a new challenge of subject and ceremony, per a new session id.
+func (pk *Passkeys) begin(ctx context.Context, subject, ceremony string) (string, Challenge, error) {
+	value, err := id.Nonce(32)
+	if err != nil {
+		return "", Challenge{}, errors.Wrap(err, "webauthn : challenge")
+	}
+	sid, err := id.Nonce(16)
+	if err != nil {
+		return "", Challenge{}, errors.Wrap(err, "webauthn : session")
+	}
+	session := base64.RawURLEncoding.EncodeToString(sid)
+	c := Challenge{
+		Value:     value,
+		Subject:   subject,
+		Ceremony:  ceremony,
+		ExpiresAt: time.Now().Add(pk.cfg.Timeout),
+	}
+	if err := pk.challenges.Put(ctx, session, c); err != nil {
+		return "", Challenge{}, errors.Wrap(err, "webauthn : storing challenge")
+	}
+	return session, c, nil
+}
+
+func (pk *Passkeys) userVerification() string {
+	if pk.cfg.RequireUserVerification {
+		return "required"
+	}
+	return "preferred"
+}
+
+// verifyClientData verifies the type, challenge and origin of client data.
+func (pk *Passkeys) verifyClientData(raw []byte, ceremony string, challenge []byte) error {
+	var cd struct {
+		Type        string `json:"type"`
+		Challenge   string `json:"challenge"`
+		Origin      string `json:"origin"`
+		CrossOrigin bool   `json:"crossOrigin"`
+	}
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return errors.Wrap(err, "webauthn : client data")
+	}
+	got, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(cd.Challenge, "="))
+	switch {
+	case cd.Type != ceremony:
+		return errors.Errorf("webauthn : client data type %q is not %q", cd.Type, ceremony)
+	case err != nil || subtle.ConstantTimeCompare(got, challenge) != 1:
+		return errors.New("webauthn : client data challenge mismatch")
+	case !oneOf(cd.Origin, pk.cfg.Origins):
+		return errors.Errorf("webauthn : origin %q not accepted", cd.Origin)
+	case cd.CrossOrigin:
+		return errors.New("webauthn : cross-origin ceremony not accepted")
+	}
+	return nil
+}
+
+// authData is the parsed authenticator data.
+type authData struct {
+	flags     byte
+	signCount uint32
+	aaguid    []byte
+	credID    []byte
+	credKey   []byte // COSE_Key
+}
+
+// parseAuthData parses authenticator data, and verifies its RP ID hash and user flags.
+func (pk *Passkeys) parseAuthData(bb []byte) (authData, error) {
+	if len(bb) < 37 {
+		return authData{}, errors.New("webauthn : authenticator data too short")
+	}
+	if subtle.ConstantTimeCompare(bb[:32], pk.rpIDHash[:]) != 1 {
+		return authData{}, errors.New("webauthn : rp id hash mismatch")
+	}
+	ad := authData{flags: bb[32], signCount: binary.BigEndian.Uint32(bb[33:37])}
+	if ad.flags&flagUP == 0 {
+		return authData{}, errors.New("webauthn : user not present")
+	}
+	if pk.cfg.RequireUserVerification && ad.flags&flagUV == 0 {
+		return authData{}, errors.New("webauthn : user not verified")
+	}
+
+	rest := bb[37:]
+	if ad.flags&flagAT != 0 {
+		if len(rest) < 18 {
+			return authData{}, errors.New("webauthn : attested credential data too short")
+		}
+		ad.aaguid = append([]byte(nil), rest[:16]...)
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if n > 1023 || len(rest) < n {
+			return authData{}, errors.New("webauthn : malformed credential id")
+		}
+		ad.credID = append([]byte(nil), rest[:n]...)
+		rest = rest[n:]
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return authData{}, errors.Wrap(err, "webauthn : credential public key")
+		}
+		ad.credKey = append([]byte(nil), rest[:len(rest)-len(after)]...)
+		rest = after
+	}
+	if ad.flags&flagED != 0 {
+		item, after, err := decodeCBOR(rest)
+		if err != nil {
+			return authData{}, errors.Wrap(err, "webauthn : extensions")
+		}
+		if _, err := cborMap(item); err != nil {
+			return authData{}, errors.Wrap(err, "webauthn : extensions")
+		}
+		rest = after
+	}
+	if len(rest) != 0 {
+		return authData{}, errors.New("webauthn : trailing bytes of authenticator data")
+	}
+	return ad, nil
+}
+
+// verifyPacked verifies a packed attestation statement (stmt) over signed,
+// and returns its type; "basic" if per certificate (x5c), else "self".
+// https://www.w3.org/TR/webauthn-2/#sctn-packed-attestation
+func verifyPacked(stmt map[interface{}]interface{}, signed, aaguid []byte, credPub crypto.PublicKey, credAlg int64) (string, error) {
+	alg, err := cborInt(stmt, "alg")
+	if err != nil {
+		return "", errors.Wrap(err, "webauthn : packed attestation")
+	}
+	sig, err := cborBytes(stmt, "sig")
+	if err != nil {
+		return "", errors.Wrap(err, "webauthn : packed attestation")
+	}
+
+	x5c, ok := stmt["x5c"].([]interface{})
+	if !ok {
+		// Self attestation; signed by the credential's own key.
+		if alg != credAlg {
+			return "", errors.New("webauthn : packed self attestation alg mismatch")
+		}
+		if err := verifyCOSE(alg, credPub, signed, sig); err != nil {
+			return "", errors.Wrap(err, "webauthn : packed self attestation")
+		}
+		return "self", nil
+	}
+
+	var der []byte
+	if len(x5c) > 0 {
+		der, _ = x5c[0].([]byte)
+	}
+	if len(der) == 0 {
+		return "", errors.New("webauthn : packed attestation : malformed x5c")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return "", errors.Wrap(err, "webauthn : packed attestation certificate")
+	}
+	if err := verifyCOSE(alg, cert.PublicKey, signed, sig); err != nil {
+		return "", errors.Wrap(err, "webauthn : packed attestation")
+	}
+	// Certificate requirements: https://www.w3.org/TR/webauthn-2/#sctn-packed-attestation-cert-requirements
+	switch {
+	case cert.Version != 3:
+		return "", errors.New("webauthn : packed attestation certificate not of version 3")
+	case !oneOf("Authenticator Attestation", cert.Subject.OrganizationalUnit):
+		return "", errors.New("webauthn : packed attestation certificate subject OU mismatch")
+	case cert.BasicConstraintsValid && cert.IsCA:
+		return "", errors.New("webauthn : packed attestation certificate is of a CA")
+	}
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidAAGUID) {
+			continue
+		}
+		var v []byte
+		if _, err := asn1.Unmarshal(ext.Value, &v); err != nil || !bytes.Equal(v, aaguid) {
+			return "", errors.New("webauthn : packed attestation certificate aaguid mismatch")
+		}
+	}
+	return "basic", nil
+}
+
+func descriptors(creds []WebAuthnCredential) []CredentialDescriptor {
+	var dd []CredentialDescriptor
+	for _, c := range creds {
+		dd = append(dd, CredentialDescriptor{Type: "public-key", ID: c.ID, Transports: c.Transports})
+	}
+	return dd
+}
+
+func int64OneOf(n int64, list []int64) bool {
+	for _, x := range list {
+		if n == x {
+			return true
+		}
+	}
+	return false
+}
+
+// ----------------------------------------------------------------------------
+// MemoryWebAuthnStore
+
+// MemoryWebAuthnStore is an in-process WebAuthnStore; for tests and single-instance services.
+type MemoryWebAuthnStore struct {
+	mu    sync.RWMutex
+	creds map[string]WebAuthnCredential // Per string(ID).
+}
+
+// NewMemoryWebAuthnStore returns an empty MemoryWebAuthnStore.
+func NewMemoryWebAuthnStore() *MemoryWebAuthnStore {
+	return &MemoryWebAuthnStore{creds: make(map[string]WebAuthnCredential)}
+}
+
+// Save adds or replaces a credential.
+func (s *MemoryWebAuthnStore) Save(ctx context.Context, cred WebAuthnCredential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.creds[string(cred.ID)] = cred
+	return nil
+}
+
+// Lookup returns the credential of id.
+func (s *MemoryWebAuthnStore) Lookup(ctx context.Context, id []byte) (WebAuthnCredential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cred, ok := s.creds[string(id)]
+	if !ok {
+		return WebAuthnCredential{}, ErrWebAuthnCredentialNotFound
+	}
+	return cred, nil
+}
+
+// List returns the credentials of subject.
+func (s *MemoryWebAuthnStore) List(ctx context.Context, subject string) ([]WebAuthnCredential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var creds []WebAuthnCredential
+	for _, cred := range s.creds {
+		if cred.Subject == subject {
+			creds = append(creds, cred)
+		}
+	}
+	return creds, nil
+}
+
+// UpdateSignCount sets the signature counter of the credential of id.
+func (s *MemoryWebAuthnStore) UpdateSignCount(ctx context.Context, id []byte, count uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cred, ok := s.creds[string(id)]
+	if !ok {
+		return ErrWebAuthnCredentialNotFound
+	}
+	cred.SignCount = count
+	s.creds[string(id)] = cred
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// MemoryChallengeStore
+
+// MemoryChallengeStore is an in-process ChallengeStore; for tests and single-instance services.
+type MemoryChallengeStore struct {
+	mu         sync.Mutex
+	challenges map[string]Challenge
+	pruned     time.Time
+}
+
+// NewMemoryChallengeStore returns an empty MemoryChallengeStore.
+func NewMemoryChallengeStore() *MemoryChallengeStore {
+	return &MemoryChallengeStore{challenges: make(map[string]Challenge)}
+}
+
+// Put stores the challenge of session; expired challenges are pruned at most once a minute.
+func (s *MemoryChallengeStore) Put(ctx context.Context, session string, c Challenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now := time.Now(); now.Sub(s.pruned) > time.Minute {
+		for k, v := range s.challenges {
+			if !now.Before(v.ExpiresAt) {
+				delete(s.challenges, k)
+			}
+		}
+		s.pruned = now
+	}
+	s.challenges[session] = c
+	return nil
+}
+
+// Take returns and deletes the challenge of session.
+func (s *MemoryChallengeStore) Take(ctx context.Context, session string) (Challenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.challenges[session]
+	delete(s.challenges, session)
+	if !ok || !time.Now().Before(c.ExpiresAt) {
+		return Challenge{}, ErrChallengeUnknown
+	}
+	return c, nil
+}
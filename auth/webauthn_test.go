@@ -0,0 +1,185 @@
+package auth_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sempernow/kit/auth"
+	"github.com/sempernow/kit/testkit"
+
+	"github.com/pkg/errors"
+)
+
+// ceremony is a recorded WebAuthn ceremony; the challenge of its session, and the browser's response.
+type ceremony struct {
+	Session   string          `json:"session"`
+	Subject   string          `json:"subject"`
+	Challenge string          `json:"challenge"`
+	Response  json.RawMessage `json:"response"`
+}
+
+// passkeyFixture is a registration and the assertions thereafter, of one authenticator.
+type passkeyFixture struct {
+	RPID         string     `json:"rpId"`
+	Origin       string     `json:"origin"`
+	Subject      string     `json:"subject"`
+	Registration ceremony   `json:"registration"`
+	Assertions   []ceremony `json:"assertions"`
+}
+
+func loadPasskeyFixture(t *testing.T, name string) passkeyFixture {
+	t.Helper()
+	bb, err := os.ReadFile(filepath.Join("testdata", "webauthn", name+".json"))
+	testkit.Log(t, "Read fixture "+name, err)
+	var f passkeyFixture
+	testkit.Log(t, "Decode fixture "+name, json.Unmarshal(bb, &f))
+	return f
+}
+
+// replay stores the recorded challenge of c under its session, as would Begin.
+func replay(t *testing.T, store auth.ChallengeStore, c ceremony, subject, kind string) {
+	t.Helper()
+	v, err := base64.RawURLEncoding.DecodeString(c.Challenge)
+	testkit.Log(t, "Decode challenge", err)
+	err = store.Put(testkit.Context(), c.Session, auth.Challenge{
+		Value:     v,
+		Subject:   subject,
+		Ceremony:  kind,
+		ExpiresAt: time.Now().Add(time.Minute),
+	})
+	testkit.Log(t, "Store challenge", err)
+}
+
+func TestPasskeys(t *testing.T) {
+	ctx := testkit.Context()
+	tests := []struct {
+		fixture     string
+		attestation string
+		assertions  []error // Of each recorded assertion.
+	}{
+		{"none-es256", "none", []error{nil, nil, auth.ErrWebAuthnCounter}},
+		{"packed-self-eddsa", "self", []error{nil, nil}},
+		{"packed-x5c-rs256", "basic", []error{nil}},
+	}
+	for _, tt := range tests {
+		t.Log("@ " + tt.fixture)
+		f := loadPasskeyFixture(t, tt.fixture)
+		creds, challenges := auth.NewMemoryWebAuthnStore(), auth.NewMemoryChallengeStore()
+		pk, err := auth.NewPasskeys(auth.WebAuthnConfig{
+			RPID:        f.RPID,
+			Origins:     []string{f.Origin},
+			Attestation: "direct",
+		}, creds, challenges)
+		testkit.Log(t, "New passkeys", err)
+
+		var reg auth.RegistrationResponse
+		testkit.Log(t, "Decode registration", json.Unmarshal(f.Registration.Response, &reg))
+		replay(t, challenges, f.Registration, f.Subject, auth.CeremonyCreate)
+		cred, err := pk.FinishRegistration(ctx, f.Registration.Session, reg)
+		testkit.Log(t, "Finish registration", err)
+		testkit.LogDiff(t, "Attestation type", cred.AttestationType, tt.attestation)
+		testkit.LogDiff(t, "Subject", cred.Subject, f.Subject)
+
+		_, err = pk.FinishRegistration(ctx, f.Registration.Session, reg)
+		testkit.LogDiff(t, "Challenge is one-time", errors.Cause(err), auth.ErrChallengeUnknown)
+
+		for i, c := range f.Assertions {
+			var resp auth.AssertionResponse
+			testkit.Log(t, "Decode assertion", json.Unmarshal(c.Response, &resp))
+			replay(t, challenges, c, c.Subject, auth.CeremonyGet)
+			claims, err := pk.FinishLogin(ctx, c.Session, resp)
+			testkit.LogDiff(t, "Assertion "+c.Session, errors.Cause(err), tt.assertions[i])
+			if err == nil {
+				testkit.LogDiff(t, "Claims subject", claims.Subject, f.Subject)
+				testkit.LogDiff(t, "Claims issuer", claims.Issuer, auth.Issuer(auth.WebAuthn))
+			}
+		}
+	}
+}
+
+func TestPasskeysRejects(t *testing.T) {
+	ctx := testkit.Context()
+	f := loadPasskeyFixture(t, "none-es256")
+	creds, challenges := auth.NewMemoryWebAuthnStore(), auth.NewMemoryChallengeStore()
+	cfg := auth.WebAuthnConfig{RPID: f.RPID, Origins: []string{f.Origin}}
+	pk, err := auth.NewPasskeys(cfg, creds, challenges)
+	testkit.Log(t, "New passkeys", err)
+
+	var reg auth.RegistrationResponse
+	testkit.Log(t, "Decode registration", json.Unmarshal(f.Registration.Response, &reg))
+
+	other, _ := auth.NewPasskeys(auth.WebAuthnConfig{RPID: f.RPID, Origins: []string{"https://evil.com"}}, creds, challenges)
+	replay(t, challenges, f.Registration, f.Subject, auth.CeremonyCreate)
+	_, err = other.FinishRegistration(ctx, f.Registration.Session, reg)
+	testkit.LogDiff(t, "Origin not accepted", err != nil, true)
+
+	rp, _ := auth.NewPasskeys(auth.WebAuthnConfig{RPID: "evil.com", Origins: []string{f.Origin}}, creds, challenges)
+	replay(t, challenges, f.Registration, f.Subject, auth.CeremonyCreate)
+	_, err = rp.FinishRegistration(ctx, f.Registration.Session, reg)
+	testkit.LogDiff(t, "RP ID not accepted", err != nil, true)
+
+	uv, _ := auth.NewPasskeys(auth.WebAuthnConfig{RPID: f.RPID, Origins: []string{f.Origin}, RequireUserVerification: true}, creds, challenges)
+	replay(t, challenges, f.Registration, f.Subject, auth.CeremonyCreate)
+	_, err = uv.FinishRegistration(ctx, f.Registration.Session, reg)
+	testkit.Log(t, "User verified at registration", err)
+
+	c := f.Assertions[0]
+	var resp auth.AssertionResponse
+	testkit.Log(t, "Decode assertion", json.Unmarshal(c.Response, &resp))
+
+	replay(t, challenges, c, c.Subject, auth.CeremonyCreate)
+	_, err = pk.FinishLogin(ctx, c.Session, resp)
+	testkit.LogDiff(t, "Ceremony mismatch", errors.Cause(err), auth.ErrChallengeUnknown)
+
+	replay(t, challenges, c, "0x02", auth.CeremonyGet)
+	_, err = pk.FinishLogin(ctx, c.Session, resp)
+	testkit.LogDiff(t, "Credential of another user", err != nil, true)
+
+	resp.Response.Signature[len(resp.Response.Signature)-1] ^= 0xff
+	replay(t, challenges, c, c.Subject, auth.CeremonyGet)
+	_, err = pk.FinishLogin(ctx, c.Session, resp)
+	testkit.LogDiff(t, "Tampered signature", err != nil, true)
+}
+
+func TestPasskeysBegin(t *testing.T) {
+	ctx := testkit.Context()
+	creds := auth.NewMemoryWebAuthnStore()
+	pk, err := auth.NewPasskeys(auth.WebAuthnConfig{RPID: "example.com", Origins: []string{"https://example.com"}},
+		creds, auth.NewMemoryChallengeStore())
+	testkit.Log(t, "New passkeys", err)
+
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	testkit.Log(t, "Save credential", creds.Save(ctx, auth.WebAuthnCredential{ID: pub[:16], Subject: "0x01"}))
+
+	session, opts, err := pk.BeginRegistration(ctx, auth.WebAuthnUser{Subject: "0x01", Name: "foo@bar.com"})
+	testkit.Log(t, "Begin registration", err)
+	testkit.LogDiff(t, "Session", session != "", true)
+	testkit.LogDiff(t, "Challenge", len(opts.Challenge), 32)
+	testkit.LogDiff(t, "User handle", string(opts.User.ID), "0x01")
+	testkit.LogDiff(t, "Algorithms", len(opts.PubKeyCredParams), 3)
+	testkit.LogDiff(t, "Excludes registered", len(opts.ExcludeCredentials), 1)
+
+	bb, err := json.Marshal(opts)
+	testkit.Log(t, "Encode options", err)
+	var m map[string]interface{}
+	testkit.Log(t, "Decode options", json.Unmarshal(bb, &m))
+	testkit.LogDiff(t, "Challenge per base64url", m["challenge"], base64.RawURLEncoding.EncodeToString(opts.Challenge))
+
+	_, req, err := pk.BeginLogin(ctx, "0x01")
+	testkit.Log(t, "Begin login", err)
+	testkit.LogDiff(t, "Allows registered", len(req.AllowCredentials), 1)
+	testkit.LogDiff(t, "RP ID", req.RPID, "example.com")
+
+	_, req, err = pk.BeginLogin(ctx, "")
+	testkit.Log(t, "Begin discoverable login", err)
+	testkit.LogDiff(t, "Allows any", len(req.AllowCredentials), 0)
+
+	_, _, err = pk.BeginLogin(ctx, "0x02")
+	testkit.LogDiff(t, "No credentials", errors.Cause(err), auth.ErrWebAuthnCredentialNotFound)
+}
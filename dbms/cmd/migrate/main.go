@@ -0,0 +1,131 @@
+// Package dbms/cmd/migrate applies the SQL migrations of a directory to the
+// database of DATABASE_URL (or -url); for CI and deploy jobs.
+//
+//	migrate -dir ./migrations status
+//	migrate -dir ./migrations -dry-run up
+//	migrate -dir ./migrations up [VERSION]
+//	migrate -dir ./migrations down [STEPS]
+//
+// It exits 1 on error, and 2 if of status with pending, modified or missing migrations.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+
+	"github.com/sempernow/kit/dbms"
+)
+
+func main() {
+	code, err := run()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "migrate :", err)
+	}
+	os.Exit(code)
+}
+
+func run() (int, error) {
+	var (
+		dir        = flag.String("dir", "migrations", "directory of the migrations")
+		dbURL      = flag.String("url", "", "connection URL (default $"+dbms.EnvURL+")")
+		table      = flag.String("table", "", "table of applied migrations (default schema_migrations)")
+		dryRun     = flag.Bool("dry-run", false, "report the migrations that would run, and run none")
+		outOfOrder = flag.Bool("allow-out-of-order", false, "apply pending migrations below the latest applied")
+		timeout    = flag.Duration("timeout", 30*time.Second, "how long to wait for the database")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [FLAGS] status|up [VERSION]|down [STEPS]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var n int64
+	if flag.NArg() == 2 {
+		var err error
+		if n, err = strconv.ParseInt(flag.Arg(1), 10, 64); err != nil {
+			return 1, fmt.Errorf("invalid argument %q", flag.Arg(1))
+		}
+	}
+	if flag.NArg() < 1 || flag.NArg() > 2 {
+		flag.Usage()
+		return 1, nil
+	}
+
+	cfg, err := dbms.FromEnv()
+	if *dbURL != "" {
+		cfg, err = dbms.ParseURL(*dbURL)
+	}
+	if err != nil {
+		return 1, err
+	}
+	cfg.StartupTimeout = *timeout
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	db, err := dbms.Connect(ctx, cfg)
+	if err != nil {
+		return 1, err
+	}
+	defer db.Close()
+
+	m, err := dbms.NewMigrator(db, os.DirFS(*dir), dbms.MigrateConfig{Table: *table, DryRun: *dryRun, AllowOutOfOrder: *outOfOrder})
+	if err != nil {
+		return 1, err
+	}
+
+	switch cmd := flag.Arg(0); cmd {
+	case "status":
+		ss, err := m.Status(ctx)
+		if err != nil {
+			return 1, err
+		}
+		code := 0
+		for _, s := range ss {
+			state := "applied " + s.AppliedAt.Format(time.RFC3339)
+			switch {
+			case s.Modified:
+				state, code = "MODIFIED", 2
+			case s.Missing:
+				state, code = "MISSING", 2
+			case !s.Applied:
+				state, code = "pending", 2
+			}
+			fmt.Printf("%6d  %-40s  %s\n", s.Version, s.Name, state)
+		}
+		return code, nil
+
+	case "up", "down":
+		var (
+			mm  []dbms.Migration
+			err error
+		)
+		verb := "applied"
+		if cmd == "up" {
+			mm, err = m.Up(ctx, n)
+		} else {
+			mm, err = m.Down(ctx, int(n))
+			verb = "reverted"
+		}
+		if *dryRun {
+			verb = "would have " + verb
+		}
+		for _, mig := range mm {
+			fmt.Printf("%s %d (%s)\n", verb, mig.Version, mig.Name)
+		}
+		if err != nil {
+			return 1, err
+		}
+		if len(mm) == 0 {
+			fmt.Println("nothing to do")
+		}
+		return 0, nil
+	}
+	flag.Usage()
+	return 1, nil
+}
@@ -6,42 +6,159 @@ package dbms
 
 import (
 	"context"
-	"fmt"
 	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq" // The database driver in use.
+	"github.com/pkg/errors"
 	"go.opentelemetry.io/otel/trace"
 )
 
-// Config is the required properties to use the database.
+// EnvURL is the environment variable of a connection URL; see FromEnv().
+const EnvURL = "DATABASE_URL"
+
+// Config is the required properties to use the database; zero values select defaults.
 type Config struct {
 	User       string `json:"user,omitempty"`
 	Password   string `json:"pass,omitempty"`
-	Host       string `json:"host,omitempty"`
+	Host       string `json:"host,omitempty"` // host[:port]
 	Name       string `json:"name,omitempty"`
 	DisableTLS bool   `json:"disable_tls,omitempty"`
-	//PathSQL    string
+
+	// TLS : SSLMode is of disable, require, verify-ca or verify-full; default "require",
+	// "disable" if DisableTLS, or "verify-full" if SSLRootCert.
+	SSLMode     string `json:"ssl_mode,omitempty"`
+	SSLRootCert string `json:"ssl_root_cert,omitempty"` // CA file of the server's certificate.
+	SSLCert     string `json:"ssl_cert,omitempty"`      // Client certificate file.
+	SSLKey      string `json:"ssl_key,omitempty"`       // Client key file.
+
+	// Session : run-time parameters of each connection.
+	Timezone         string        `json:"timezone,omitempty"` // Default "utc".
+	SearchPath       []string      `json:"search_path,omitempty"`
+	ApplicationName  string        `json:"application_name,omitempty"`
+	StatementTimeout time.Duration `json:"statement_timeout,omitempty"` // Zero is none.
+	ConnectTimeout   time.Duration `json:"connect_timeout,omitempty"`   // Per connection attempt; zero is none.
+	// Params are other run-time parameters, or driver options, by name.
+	Params map[string]string `json:"params,omitempty"`
+
+	// Pool : see sql.DB. Zero values are those of database/sql (unlimited).
+	MaxOpenConns    int           `json:"max_open_conns,omitempty"`
+	MaxIdleConns    int           `json:"max_idle_conns,omitempty"`
+	ConnMaxLifetime time.Duration `json:"conn_max_lifetime,omitempty"`
+	ConnMaxIdleTime time.Duration `json:"conn_max_idle_time,omitempty"`
+
+	// StartupTimeout is how long Connect(..) waits for the database (default 30s).
+	StartupTimeout time.Duration `json:"startup_timeout,omitempty"`
 }
 
-// Store wraps `DB`
-// type Store struct {
-// 	*sqlx.DB
-// 	PathSQL string
-// }
+// sslModes are those of libpq less "allow" and "prefer", which permit plaintext.
+var sslModes = map[string]bool{"disable": true, "require": true, "verify-ca": true, "verify-full": true}
 
 // Open knows how to open a database connection based on the configuration.
-//func Open(cfg Config) (DB, error) {
+// It does not connect; see Connect(..) to wait for the database.
+// func Open(cfg Config) (DB, error) {
 func Open(cfg Config) (*sqlx.DB, error) {
-	sslMode := "require"
-	if cfg.DisableTLS {
-		sslMode = "disable"
+	dsn, err := cfg.DSN()
+	if err != nil {
+		return nil, err
+	}
+	db, err := sqlx.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	return db, nil
+}
+
+// Connect opens the database per cfg and waits until it answers, retrying per
+// exponential backoff (250ms doubling to 5s) for up to cfg.StartupTimeout;
+// as when a service starts alongside its database. Only failures of connection
+// (per Classify, ErrConnection) are retried; others, e.g., of a bad password
+// (28P01), an unknown database (3D000) or TLS, are returned at once.
+func Connect(ctx context.Context, cfg Config) (*sqlx.DB, error) {
+	db, err := Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+	timeout := cfg.StartupTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for delay := 250 * time.Millisecond; ; delay *= 2 {
+		if err = Status(ctx, db); err == nil {
+			return db, nil
+		}
+		if !errors.Is(Classify(err), ErrConnection) {
+			db.Close()
+			return nil, errors.Wrapf(err, "connecting to database %s", cfg.Host)
+		}
+		if delay > 5*time.Second {
+			delay = 5 * time.Second
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			db.Close()
+			return nil, errors.Wrapf(err, "waiting for database %s", cfg.Host)
+		case <-t.C:
+		}
+	}
+}
+
+// DSN returns the connection URL of cfg; error if of an invalid SSLMode.
+func (cfg Config) DSN() (string, error) {
+	mode := cfg.SSLMode
+	switch {
+	case mode != "":
+	case cfg.DisableTLS:
+		mode = "disable"
+	case cfg.SSLRootCert != "":
+		mode = "verify-full"
+	default:
+		mode = "require"
+	}
+	if !sslModes[mode] {
+		return "", errors.Errorf("dbms : invalid ssl mode %q", mode)
 	}
 
 	q := make(url.Values)
-	q.Set("sslmode", sslMode)
-	q.Set("timezone", "utc")
+	for k, v := range cfg.Params {
+		q.Set(k, v)
+	}
+	q.Set("sslmode", mode)
+	set := func(k, v string) {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	set("sslrootcert", cfg.SSLRootCert)
+	set("sslcert", cfg.SSLCert)
+	set("sslkey", cfg.SSLKey)
+	if cfg.Timezone == "" {
+		cfg.Timezone = "utc"
+	}
+	q.Set("timezone", cfg.Timezone)
+	set("search_path", strings.Join(cfg.SearchPath, ","))
+	set("application_name", cfg.ApplicationName)
+	if cfg.StatementTimeout > 0 {
+		q.Set("statement_timeout", strconv.FormatInt(cfg.StatementTimeout.Milliseconds(), 10))
+	}
+	if cfg.ConnectTimeout > 0 {
+		//... of whole seconds, per libpq; at least 1.
+		q.Set("connect_timeout", strconv.Itoa(int((cfg.ConnectTimeout+time.Second-1)/time.Second)))
+	}
 
 	dbURL := url.URL{
 		Scheme:   "postgres",
@@ -51,7 +168,83 @@ func Open(cfg Config) (*sqlx.DB, error) {
 		RawQuery: q.Encode(),
 	}
 
-	return sqlx.Open("postgres", dbURL.String())
+	return dbURL.String(), nil
+}
+
+// ParseURL returns the Config of a connection URL (postgres:// or postgresql://),
+// as of DATABASE_URL; its query parameters set the fields of the same (libpq) names,
+// else Params. Other fields of Config, such as of the pool, remain zero.
+//
+//	postgres://app:secret@db:5432/app?sslmode=verify-full&sslrootcert=/etc/ssl/db-ca.pem&application_name=api
+func ParseURL(raw string) (Config, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Config{}, errors.Wrap(err, "dbms : parsing url")
+	}
+	if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+		return Config{}, errors.Errorf("dbms : url scheme %q is not postgres", u.Scheme)
+	}
+	cfg := Config{
+		Host: u.Host,
+		Name: strings.TrimPrefix(u.Path, "/"),
+	}
+	if u.User != nil {
+		cfg.User = u.User.Username()
+		cfg.Password, _ = u.User.Password()
+	}
+
+	for k, vv := range u.Query() {
+		v := vv[0]
+		switch k {
+		case "sslmode":
+			cfg.SSLMode = v
+		case "sslrootcert":
+			cfg.SSLRootCert = v
+		case "sslcert":
+			cfg.SSLCert = v
+		case "sslkey":
+			cfg.SSLKey = v
+		case "timezone":
+			cfg.Timezone = v
+		case "search_path":
+			cfg.SearchPath = strings.Split(v, ",")
+		case "application_name":
+			cfg.ApplicationName = v
+		case "statement_timeout":
+			ms, err := strconv.Atoi(v)
+			if err != nil {
+				return Config{}, errors.Errorf("dbms : invalid statement_timeout %q", v)
+			}
+			cfg.StatementTimeout = time.Duration(ms) * time.Millisecond
+		case "connect_timeout":
+			secs, err := strconv.Atoi(v)
+			if err != nil {
+				return Config{}, errors.Errorf("dbms : invalid connect_timeout %q", v)
+			}
+			cfg.ConnectTimeout = time.Duration(secs) * time.Second
+		default:
+			if cfg.Params == nil {
+				cfg.Params = make(map[string]string)
+			}
+			cfg.Params[k] = v
+		}
+	}
+	if cfg.SSLMode == "disable" {
+		cfg.DisableTLS = true
+	}
+	if cfg.SSLMode != "" && !sslModes[cfg.SSLMode] {
+		return Config{}, errors.Errorf("dbms : invalid ssl mode %q", cfg.SSLMode)
+	}
+	return cfg, nil
+}
+
+// FromEnv returns the Config of the connection URL of environment variable EnvURL (DATABASE_URL).
+func FromEnv() (Config, error) {
+	raw := os.Getenv(EnvURL)
+	if raw == "" {
+		return Config{}, errors.Errorf("dbms : %s is not set", EnvURL)
+	}
+	return ParseURL(raw)
 }
 
 // Status returns nil if it can successfully talk to the database. It
@@ -71,57 +264,14 @@ func Status(ctx context.Context, db *sqlx.DB) error {
 	return db.QueryRowContext(ctx, q).Scan(&tmp)
 }
 
-// Log provides a pretty print version of the SQL query and parameters.
+// Log provides a pretty print version of the SQL query and parameters,
+// each argument redacted; see LogWith to print (some of) them.
 func Log(query string, args ...interface{}) string {
-	for i, arg := range args {
-		n := fmt.Sprintf("$%d", i+1)
-
-		var a string
-		switch v := arg.(type) {
-		case string:
-			a = fmt.Sprintf("%q", v)
-		case []byte:
-			a = string(v)
-		case []string:
-			a = strings.Join(v, ",")
-		default:
-			a = fmt.Sprintf("%v", v)
-		}
-
-		query = strings.Replace(query, n, a, 1)
-		query = strings.Replace(query, "\t", "", -1)
-		query = strings.Replace(query, "\n", " ", -1)
-	}
-
-	return query
+	return format(query, args, RedactAll)
 }
 
-// ===  NULLable scheme : DEPRICATED  ===
-
-// var (
-// 	// Insert dbUNIL instead of NULL; validates as uuid, but not uuid4
-// 	// @ Golang: `uuid.Nil`; @ Postgres "uuid-oosp" ext: `uuid_nil()`
-// 	//dbUNIL = fmt.Sprintf("%s", uuid.Nil)
-// 	dbUNIL sql.NullString = sql.NullString{String: uuid.Nil.String(), Valid: true}
-// 	//dbNULL sql.NullString = sql.NullString{}
-// )
-
-// // IfEmptyToNullString ...
-// // https://stackoverflow.com/questions/40266633/golang-insert-null-into-sql-instead-of-empty-string
-// func IfEmptyToNullString(s string) sql.NullString {
-// 	if len(s) == 0 {
-// 		return sql.NullString{}
-// 	}
-// 	return sql.NullString{
-// 		String: s,
-// 		Valid:  true,
-// 	}
-// }
-
-// // IfEmptyToUNIL ...
-// func IfEmptyToUNIL(s string) sql.NullString {
-// 	if len(s) == 0 {
-// 		return sql.NullString{}
-// 	}
-// 	return sql.NullString{String: uuid.Nil.String(), Valid: true}
-// }
+// LogWith is Log of the arguments redacted per redact;
+// those of Secret regardless.
+func LogWith(redact Redactor, query string, args ...interface{}) string {
+	return format(query, args, redact)
+}
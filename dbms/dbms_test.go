@@ -0,0 +1,111 @@
+package dbms_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sempernow/kit/dbms"
+	"github.com/sempernow/kit/testkit"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+func TestDSN(t *testing.T) {
+	cfg := dbms.Config{
+		User:             "app",
+		Password:         "p@ss",
+		Host:             "db:5432",
+		Name:             "app",
+		SSLRootCert:      "/etc/ssl/db-ca.pem",
+		SearchPath:       []string{"app", "public"},
+		ApplicationName:  "api",
+		StatementTimeout: 5 * time.Second,
+		ConnectTimeout:   1500 * time.Millisecond,
+	}
+	dsn, err := cfg.DSN()
+	testkit.Log(t, "DSN", err)
+	u, _ := url.Parse(dsn)
+	q := u.Query()
+	testkit.LogDiff(t, "Verify full per root cert", q.Get("sslmode"), "verify-full")
+	testkit.LogDiff(t, "Timezone default", q.Get("timezone"), "utc")
+	testkit.LogDiff(t, "Search path", q.Get("search_path"), "app,public")
+	testkit.LogDiff(t, "Statement timeout [ms]", q.Get("statement_timeout"), "5000")
+	testkit.LogDiff(t, "Connect timeout [s]", q.Get("connect_timeout"), "2")
+
+	back, err := dbms.ParseURL(dsn)
+	testkit.Log(t, "Parse URL", err)
+	testkit.LogCmp(t, "Round trip", back, dbms.Config{
+		User:             "app",
+		Password:         "p@ss",
+		Host:             "db:5432",
+		Name:             "app",
+		SSLMode:          "verify-full",
+		SSLRootCert:      "/etc/ssl/db-ca.pem",
+		Timezone:         "utc",
+		SearchPath:       []string{"app", "public"},
+		ApplicationName:  "api",
+		StatementTimeout: 5 * time.Second,
+		ConnectTimeout:   2 * time.Second,
+	})
+
+	_, err = dbms.Config{SSLMode: "prefer"}.DSN()
+	testkit.LogDiff(t, "Plaintext-permitting mode refused", err != nil, true)
+
+	disabled, _ := dbms.Config{DisableTLS: true}.DSN()
+	u, _ = url.Parse(disabled)
+	testkit.LogDiff(t, "DisableTLS", u.Query().Get("sslmode"), "disable")
+}
+
+func TestParseURL(t *testing.T) {
+	os.Setenv(dbms.EnvURL, "postgresql://u:p@localhost/db?sslmode=disable&lock_timeout=1000")
+	defer os.Unsetenv(dbms.EnvURL)
+
+	cfg, err := dbms.FromEnv()
+	testkit.Log(t, "From env", err)
+	testkit.LogDiff(t, "DisableTLS", cfg.DisableTLS, true)
+	testkit.LogDiff(t, "Other params", cfg.Params["lock_timeout"], "1000")
+
+	_, err = dbms.ParseURL("mysql://u:p@localhost/db")
+	testkit.LogDiff(t, "Scheme refused", err != nil, true)
+}
+
+func TestConnectTimeout(t *testing.T) {
+	cfg := dbms.Config{Host: "127.0.0.1:1", DisableTLS: true, StartupTimeout: 600 * time.Millisecond}
+	start := time.Now()
+	_, err := dbms.Connect(context.Background(), cfg)
+	testkit.LogDiff(t, "Unreachable", err != nil, true)
+	testkit.LogDiff(t, "Waited per backoff", time.Since(start) >= 500*time.Millisecond, true)
+}
+
+func TestConnectPermanent(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	testkit.Log(t, "Listen", err)
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var hdr [4]byte
+			io.ReadFull(c, hdr[:]) //... startup message, sans type.
+			io.CopyN(io.Discard, c, int64(binary.BigEndian.Uint32(hdr[:])-4))
+			c.Write(msg('E', []byte("SFATAL\x00C28P01\x00Mpassword authentication failed\x00\x00")))
+			c.Close()
+		}
+	}()
+
+	cfg := dbms.Config{Host: ln.Addr().String(), User: "app", Name: "app", DisableTLS: true, StartupTimeout: 5 * time.Second}
+	start := time.Now()
+	_, err = dbms.Connect(context.Background(), cfg)
+	var pqErr *pq.Error
+	testkit.LogDiff(t, "Bad password", errors.As(err, &pqErr) && pqErr.Code == "28P01", true)
+	testkit.LogDiff(t, "Not retried", time.Since(start) < time.Second, true)
+}
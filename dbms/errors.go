@@ -0,0 +1,168 @@
+package dbms
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/sempernow/kit/web"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// Kinds of database errors, per Classify(..); test per errors.Is(..).
+var (
+	ErrNotFound            = errors.New("dbms : not found")
+	ErrUniqueViolation     = errors.New("dbms : unique violation")
+	ErrForeignKeyViolation = errors.New("dbms : foreign key violation")
+	ErrCheckViolation      = errors.New("dbms : check violation")
+	ErrNotNullViolation    = errors.New("dbms : not null violation")
+	ErrSerialization       = errors.New("dbms : serialization failure")
+	ErrConnection          = errors.New("dbms : connection failure")
+)
+
+// Error is a database error of a Kind (ErrNotFound, ErrUniqueViolation, ...),
+// of the table, column and constraint that the server reports, if any.
+// It unwraps to the driver error (Err).
+//
+//	var dbErr *dbms.Error
+//	if errors.As(err, &dbErr) && dbErr.Kind == dbms.ErrUniqueViolation {
+//		... dbErr.Constraint
+//	}
+type Error struct {
+	Kind       error
+	Table      string
+	Column     string
+	Constraint string
+	// Columns of the key of a unique or foreign-key violation.
+	Columns []string
+	Err     error
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	msg := e.Kind.Error()
+	if e.Constraint != "" {
+		msg += " (" + e.Constraint + ")"
+	}
+	return msg + " : " + e.Err.Error()
+}
+
+// Is reports whether target is the Kind of e.
+func (e *Error) Is(target error) bool { return target == e.Kind }
+
+// Unwrap returns the driver error.
+func (e *Error) Unwrap() error { return e.Err }
+
+// reKeyColumns matches the columns of the detail of a key violation:
+// "Key (org_id, email)=(...) already exists."
+var reKeyColumns = regexp.MustCompile(`^Key \(([^)]+)\)=`)
+
+// Classify returns err as an *Error if of a known kind, else err as is; nil if nil.
+//
+//	sql.ErrNoRows                           : ErrNotFound
+//	23505                                   : ErrUniqueViolation
+//	23503                                   : ErrForeignKeyViolation
+//	23514                                   : ErrCheckViolation
+//	23502                                   : ErrNotNullViolation
+//	40001, 40P01                            : ErrSerialization
+//	08*, 53300, 57P01-3, network, bad conn  : ErrConnection
+func Classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	var dbErr *Error
+	if errors.As(err, &dbErr) {
+		return err
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return &Error{Kind: ErrNotFound, Err: err}
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		e := &Error{Table: pqErr.Table, Column: pqErr.Column, Constraint: pqErr.Constraint, Err: err}
+		switch code := pqErr.Code; {
+		case code == "23505":
+			e.Kind = ErrUniqueViolation
+		case code == "23503":
+			e.Kind = ErrForeignKeyViolation
+		case code == "23514":
+			e.Kind = ErrCheckViolation
+		case code == "23502":
+			e.Kind = ErrNotNullViolation
+		case code == "40001" || code == "40P01":
+			e.Kind = ErrSerialization
+		case code.Class() == "08" || code == "53300" || code == "57P01" || code == "57P02" || code == "57P03":
+			e.Kind = ErrConnection
+		default:
+			return err
+		}
+		if m := reKeyColumns.FindStringSubmatch(pqErr.Detail); m != nil {
+			for _, c := range strings.Split(m[1], ",") {
+				e.Columns = append(e.Columns, strings.Trim(strings.TrimSpace(c), `"`))
+			}
+		}
+		return e
+	}
+
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr) {
+		return &Error{Kind: ErrConnection, Err: err}
+	}
+	return err
+}
+
+// RequestError returns err, per Classify(..), as a web.Error of an HTTP status
+// and a message (and fields) safe for the client; sans that of the driver.
+// Else err as is, which web.RespondError(..) reports as 500.
+//
+//	ErrNotFound                                : 404
+//	ErrUniqueViolation, ErrForeignKeyViolation : 409
+//	ErrCheckViolation, ErrNotNullViolation     : 422
+//	ErrSerialization, ErrConnection            : 503
+//
+//	if err := db.GetContext(ctx, &u, q, id); err != nil {
+//		return dbms.RequestError(err)
+//	}
+func RequestError(err error) error {
+	var e *Error
+	if !errors.As(Classify(err), &e) {
+		return err
+	}
+	fields := func(cols []string, msg string) []web.FieldError {
+		var ff []web.FieldError
+		for _, c := range cols {
+			if c != "" {
+				ff = append(ff, web.FieldError{Field: c, Error: msg})
+			}
+		}
+		return ff
+	}
+
+	var webErr web.Error
+	switch e.Kind {
+	case ErrNotFound:
+		webErr = web.Error{Err: errors.New("not found"), Status: http.StatusNotFound}
+	case ErrUniqueViolation:
+		webErr = web.Error{Err: errors.New("already exists"), Status: http.StatusConflict,
+			Fields: fields(e.Columns, "already exists")}
+	case ErrForeignKeyViolation:
+		webErr = web.Error{Err: errors.New("conflicts with a related resource"), Status: http.StatusConflict,
+			Fields: fields(e.Columns, "references a missing or dependent resource")}
+	case ErrCheckViolation:
+		webErr = web.Error{Err: errors.New("invalid value"), Status: http.StatusUnprocessableEntity,
+			Fields: fields([]string{e.Column}, "is invalid")}
+	case ErrNotNullViolation:
+		webErr = web.Error{Err: errors.New("missing value"), Status: http.StatusUnprocessableEntity,
+			Fields: fields([]string{e.Column}, "is required")}
+	default: // ErrSerialization, ErrConnection
+		webErr = web.Error{Err: errors.New(http.StatusText(http.StatusServiceUnavailable)), Status: http.StatusServiceUnavailable}
+	}
+	return &webErr
+}
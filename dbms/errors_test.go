@@ -0,0 +1,94 @@
+package dbms_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sempernow/kit/dbms"
+	"github.com/sempernow/kit/testkit"
+	"github.com/sempernow/kit/web"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+func TestClassify(t *testing.T) {
+	unique := &pq.Error{
+		Code:       "23505",
+		Message:    `duplicate key value violates unique constraint "users_org_id_email_key"`,
+		Detail:     "Key (org_id, email)=(7, a@b.c) already exists.",
+		Table:      "users",
+		Constraint: "users_org_id_email_key",
+	}
+	cases := []struct {
+		name string
+		err  error
+		kind error
+	}{
+		{"No rows", errors.Wrap(sql.ErrNoRows, "user"), dbms.ErrNotFound},
+		{"Unique", unique, dbms.ErrUniqueViolation},
+		{"Foreign key", &pq.Error{Code: "23503"}, dbms.ErrForeignKeyViolation},
+		{"Check", &pq.Error{Code: "23514"}, dbms.ErrCheckViolation},
+		{"Not null", &pq.Error{Code: "23502", Column: "email"}, dbms.ErrNotNullViolation},
+		{"Serialization", &pq.Error{Code: "40001"}, dbms.ErrSerialization},
+		{"Deadlock", &pq.Error{Code: "40P01"}, dbms.ErrSerialization},
+		{"Connection exception", &pq.Error{Code: "08006"}, dbms.ErrConnection},
+		{"Admin shutdown", &pq.Error{Code: "57P01"}, dbms.ErrConnection},
+		{"Bad conn", driver.ErrBadConn, dbms.ErrConnection},
+	}
+	for _, c := range cases {
+		err := dbms.Classify(c.err)
+		testkit.LogDiff(t, c.name, errors.Is(err, c.kind), true)
+	}
+	testkit.LogDiff(t, "Nil", dbms.Classify(nil), nil)
+	other := &pq.Error{Code: "42P01"}
+	testkit.LogDiff(t, "Other as is", dbms.Classify(other), error(other))
+
+	var dbErr *dbms.Error
+	err := dbms.Classify(errors.Wrap(unique, "inserting user"))
+	testkit.LogDiff(t, "As *Error", errors.As(err, &dbErr), true)
+	testkit.LogDiff(t, "Constraint", dbErr.Constraint, "users_org_id_email_key")
+	testkit.LogCmp(t, "Columns of key", dbErr.Columns, []string{"org_id", "email"})
+	testkit.LogDiff(t, "Unwraps to driver error", errors.Is(err, error(unique)), true)
+	testkit.LogDiff(t, "Retryable unaffected", dbms.Retryable(dbms.Classify(&pq.Error{Code: "40001"})), true)
+}
+
+func TestRequestError(t *testing.T) {
+	cases := []struct {
+		name   string
+		err    error
+		status int
+		fields []web.FieldError
+	}{
+		{"No rows", sql.ErrNoRows, http.StatusNotFound, nil},
+		{"Unique", &pq.Error{Code: "23505", Detail: `Key ("Email")=(a@b.c) already exists.`},
+			http.StatusConflict, []web.FieldError{{Field: "Email", Error: "already exists"}}},
+		{"Foreign key", &pq.Error{Code: "23503"}, http.StatusConflict, nil},
+		{"Check", &pq.Error{Code: "23514", Constraint: "age_positive"}, http.StatusUnprocessableEntity, nil},
+		{"Not null", &pq.Error{Code: "23502", Column: "email"},
+			http.StatusUnprocessableEntity, []web.FieldError{{Field: "email", Error: "is required"}}},
+		{"Serialization", &pq.Error{Code: "40001"}, http.StatusServiceUnavailable, nil},
+		{"Connection", driver.ErrBadConn, http.StatusServiceUnavailable, nil},
+	}
+	for _, c := range cases {
+		var webErr *web.Error
+		testkit.LogDiff(t, c.name+" : web.Error", errors.As(dbms.RequestError(c.err), &webErr), true)
+		testkit.LogDiff(t, c.name+" : status", webErr.Status, c.status)
+		testkit.LogCmp(t, c.name+" : fields", webErr.Fields, c.fields)
+	}
+	other := errors.New("pq: relation \"users\" does not exist")
+	testkit.LogDiff(t, "Other as is", dbms.RequestError(other), other)
+
+	t.Log("@ Response sans driver message")
+	{
+		w := httptest.NewRecorder()
+		err := dbms.RequestError(&pq.Error{Code: "23505", Message: `duplicate key value violates unique constraint "users_email_key"`})
+		web.RespondError(testkit.Context(), w, err)
+		testkit.LogDiff(t, "Status", w.Code, http.StatusConflict)
+		testkit.LogDiff(t, "No driver message", strings.Contains(w.Body.String(), "users_email_key"), false)
+	}
+}
@@ -0,0 +1,229 @@
+package dbms
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ****************************************************************************
+// LISTEN/NOTIFY of Postgres, per a connection dedicated to it (pq.Listener).
+// Upon loss of the connection, pq.Listener reconnects per backoff, and LISTENs
+// again to each channel. Notifications sent while disconnected are lost;
+// each subscriber is told so (its gap func), so it can resync; e.g., flush
+// a cache, or have SSE clients refetch.
+// ****************************************************************************
+
+// ListenerConfig declares the parameters of a Listener; zero values select defaults.
+type ListenerConfig struct {
+	// MinReconnect is the delay before reconnecting, doubled per failure
+	// up to MaxReconnect (defaults 1s and 1m).
+	MinReconnect time.Duration
+	MaxReconnect time.Duration
+	// PingInterval is the idle time after which the connection is checked (default 90s).
+	PingInterval time.Duration
+	// Log of connection events and handler errors; nil for none.
+	Log *log.Logger
+}
+
+// subscription is that of a channel.
+type subscription struct {
+	notify func(ctx context.Context, payload string) error
+	gap    func(ctx context.Context)
+}
+
+// Listener dispatches the notifications of the channels it subscribes to, per Run(..).
+type Listener struct {
+	pql *pq.Listener
+	cfg ListenerConfig
+
+	mu      sync.Mutex
+	subs    map[string]subscription
+	running bool
+}
+
+// NewListener returns a Listener of the database of cfg.
+// Its connection is established in the background; see Run(..).
+func NewListener(cfg Config, lc ListenerConfig) (*Listener, error) {
+	dsn, err := cfg.DSN()
+	if err != nil {
+		return nil, err
+	}
+	if lc.MinReconnect <= 0 {
+		lc.MinReconnect = time.Second
+	}
+	if lc.MaxReconnect < lc.MinReconnect {
+		lc.MaxReconnect = time.Minute
+	}
+	if lc.PingInterval <= 0 {
+		lc.PingInterval = 90 * time.Second
+	}
+	l := &Listener{cfg: lc, subs: make(map[string]subscription)}
+	l.pql = pq.NewListener(dsn, lc.MinReconnect, lc.MaxReconnect, l.event)
+	return l, nil
+}
+
+// event logs the connection events of the pq.Listener.
+func (l *Listener) event(ev pq.ListenerEventType, err error) {
+	if l.cfg.Log == nil {
+		return
+	}
+	switch ev {
+	case pq.ListenerEventConnected:
+		l.cfg.Log.Printf("dbms : listener : connected")
+	case pq.ListenerEventDisconnected:
+		l.cfg.Log.Printf("dbms : listener : disconnected : %v", err)
+	case pq.ListenerEventReconnected:
+		l.cfg.Log.Printf("dbms : listener : reconnected")
+	case pq.ListenerEventConnectionAttemptFailed:
+		l.cfg.Log.Printf("dbms : listener : connecting : %v", err)
+	}
+}
+
+// Subscribe calls notify with the payload of each notification of channel,
+// and gap (if not nil) upon reconnecting, as notifications may have been missed.
+// Both are called by the goroutine of Run(..), in order of receipt; they should not block.
+// If of a running Listener, it returns once the server acknowledges the LISTEN.
+func (l *Listener) Subscribe(channel string, notify func(ctx context.Context, payload string) error, gap func(ctx context.Context)) error {
+	l.mu.Lock()
+	if _, ok := l.subs[channel]; ok {
+		l.mu.Unlock()
+		return errors.Errorf("dbms : listener : already subscribed to %q", channel)
+	}
+	l.subs[channel] = subscription{notify: notify, gap: gap}
+	running := l.running
+	l.mu.Unlock()
+
+	if running {
+		if err := l.listen(channel); err != nil {
+			l.mu.Lock()
+			delete(l.subs, channel)
+			l.mu.Unlock()
+			return err
+		}
+	}
+	return nil
+}
+
+// SubscribeJSON is Subscribe(..) of payloads of JSON, decoded as of T.
+//
+//	err := dbms.SubscribeJSON(l, "users_changed", func(ctx context.Context, ev UserChanged) error {
+//		cache.Delete(ev.ID)
+//		return nil
+//	}, func(ctx context.Context) { cache.Flush() })
+func SubscribeJSON[T any](l *Listener, channel string, notify func(ctx context.Context, v T) error, gap func(ctx context.Context)) error {
+	return l.Subscribe(channel, func(ctx context.Context, payload string) error {
+		var v T
+		if err := json.Unmarshal([]byte(payload), &v); err != nil {
+			return errors.Wrap(err, "decoding payload")
+		}
+		return notify(ctx, v)
+	}, gap)
+}
+
+func (l *Listener) listen(channel string) error {
+	if err := l.pql.Listen(channel); err != nil && err != pq.ErrChannelAlreadyOpen {
+		return errors.Wrapf(err, "dbms : listener : listening to %q", channel)
+	}
+	return nil
+}
+
+// Run listens to the channels subscribed, and dispatches their notifications
+// until ctx is done, whereupon it closes the connection and returns nil.
+// It returns an error if of a LISTEN rejected by the server. A Listener runs once.
+func (l *Listener) Run(ctx context.Context) error {
+	l.mu.Lock()
+	if l.running {
+		l.mu.Unlock()
+		return errors.New("dbms : listener : already running")
+	}
+	l.running = true
+	channels := make([]string, 0, len(l.subs))
+	for ch := range l.subs {
+		channels = append(channels, ch)
+	}
+	l.mu.Unlock()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stop:
+		}
+		l.pql.Close() //... unblocks Listen(..), and closes the notification channel.
+	}()
+
+	for _, ch := range channels {
+		if err := l.listen(ch); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+
+	ping := time.NewTicker(l.cfg.PingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case n, ok := <-l.pql.NotificationChannel():
+			if !ok {
+				return nil
+			}
+			l.dispatch(ctx, n)
+		case <-ping.C:
+			go l.pql.Ping() //... a dead connection errs, and so reconnects.
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// dispatch calls the subscriber of n; each subscriber's gap func if n is nil (of a reconnect).
+func (l *Listener) dispatch(ctx context.Context, n *pq.Notification) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "kit.dbms.listener")
+	defer span.End()
+
+	l.mu.Lock()
+	if n == nil {
+		gaps := make([]func(context.Context), 0, len(l.subs))
+		for _, s := range l.subs {
+			if s.gap != nil {
+				gaps = append(gaps, s.gap)
+			}
+		}
+		l.mu.Unlock()
+		for _, gap := range gaps {
+			gap(ctx)
+		}
+		return
+	}
+	s, ok := l.subs[n.Channel]
+	l.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	if err := s.notify(ctx, n.Extra); err != nil && l.cfg.Log != nil {
+		l.cfg.Log.Printf("dbms : listener : %s : %v", n.Channel, err)
+	}
+}
+
+// Notify sends a notification of v, JSON encoded, to channel; per pg_notify(..),
+// so it is delivered upon commit if of a transaction (db of *sqlx.Tx).
+func Notify(ctx context.Context, db sqlx.ExecerContext, channel string, v interface{}) error {
+	bb, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "dbms : notify")
+	}
+	_, err = db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, channel, string(bb))
+	return errors.Wrap(err, "dbms : notify")
+}
@@ -0,0 +1,256 @@
+package dbms_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sempernow/kit/dbms"
+	"github.com/sempernow/kit/testkit"
+)
+
+// fakePG is a Postgres server of the protocol (v3) of LISTEN/NOTIFY, and no more;
+// it refuses LISTEN of channel "denied".
+type fakePG struct {
+	ln      net.Listener
+	mu      sync.Mutex
+	conns   []net.Conn
+	listens chan string
+}
+
+func newFakePG(t *testing.T) *fakePG {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	testkit.Log(t, "Listen of fake server", err)
+	pg := &fakePG{ln: ln, listens: make(chan string, 16)}
+	go pg.serve()
+	t.Cleanup(func() { ln.Close(); pg.drop() })
+	return pg
+}
+
+func (pg *fakePG) serve() {
+	for {
+		c, err := pg.ln.Accept()
+		if err != nil {
+			return
+		}
+		pg.mu.Lock()
+		pg.conns = append(pg.conns, c)
+		pg.mu.Unlock()
+		go pg.session(c)
+	}
+}
+
+func msg(typ byte, body ...[]byte) []byte {
+	b := bytes.Join(body, nil)
+	out := []byte{typ, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(out[1:], uint32(4+len(b)))
+	return append(out, b...)
+}
+
+func cstr(s string) []byte { return append([]byte(s), 0) }
+
+func int32b(n int) []byte { return binary.BigEndian.AppendUint32(nil, uint32(n)) }
+
+func (pg *fakePG) session(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil { // Startup message, sans type.
+		return
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(binary.BigEndian.Uint32(hdr[:])-4)); err != nil {
+		return
+	}
+	ready := msg('Z', []byte{'I'})
+	c.Write(append(msg('R', int32b(0)), ready...))
+
+	for {
+		typ, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return
+		}
+		body := make([]byte, binary.BigEndian.Uint32(hdr[:])-4)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+		switch typ {
+		case 'Q':
+			q := strings.TrimRight(string(body), "\x00")
+			if q == "" {
+				c.Write(append(msg('I'), ready...))
+				continue
+			}
+			if q == `LISTEN "denied"` {
+				e := msg('E', []byte("SERROR\x00C42501\x00Mpermission denied\x00\x00"))
+				c.Write(append(e, ready...))
+				continue
+			}
+			c.Write(append(msg('C', cstr(strings.Fields(q)[0])), ready...))
+			if ch := strings.TrimPrefix(q, "LISTEN "); ch != q {
+				pg.listens <- strings.Trim(ch, `"`)
+			}
+		case 'X':
+			return
+		}
+	}
+}
+
+// notify sends a notification to the latest connection.
+func (pg *fakePG) notify(channel, payload string) {
+	pg.mu.Lock()
+	defer pg.mu.Unlock()
+	pg.conns[len(pg.conns)-1].Write(msg('A', int32b(42), cstr(channel), cstr(payload)))
+}
+
+// deliver sends a notification until received of got, as those of a
+// connection of LISTENs yet in sync are dropped, then drains got of dupes.
+func (pg *fakePG) deliver(t *testing.T, channel, payload string, got chan userChanged) userChanged {
+	t.Helper()
+	for i := 0; i < 60; i++ {
+		pg.notify(channel, payload)
+		select {
+		case v := <-got:
+			for {
+				select {
+				case <-got:
+				case <-time.After(100 * time.Millisecond):
+					return v
+				}
+			}
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	t.Fatalf("\t%s\tTimeout waiting for notification", testkit.Failure)
+	return userChanged{}
+}
+
+// drop closes all connections.
+func (pg *fakePG) drop() {
+	pg.mu.Lock()
+	defer pg.mu.Unlock()
+	for _, c := range pg.conns {
+		c.Close()
+	}
+	pg.conns = nil
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+type userChanged struct {
+	ID int `json:"id"`
+}
+
+func TestListener(t *testing.T) {
+	pg := newFakePG(t)
+	var logs syncBuffer
+	l, err := dbms.NewListener(
+		dbms.Config{Host: pg.ln.Addr().String(), User: "app", Name: "app", DisableTLS: true},
+		dbms.ListenerConfig{MinReconnect: 10 * time.Millisecond, MaxReconnect: 20 * time.Millisecond, Log: log.New(&logs, "", 0)},
+	)
+	testkit.Log(t, "NewListener", err)
+
+	events := make(chan userChanged, 4)
+	gaps := make(chan struct{}, 4)
+	err = dbms.SubscribeJSON(l, "users", func(ctx context.Context, v userChanged) error {
+		events <- v
+		return nil
+	}, func(ctx context.Context) { gaps <- struct{}{} })
+	testkit.Log(t, "SubscribeJSON", err)
+	testkit.LogDiff(t, "Duplicate subscription", l.Subscribe("users", nil, nil) != nil, true)
+
+	ctx, cancel := context.WithCancel(testkit.Context())
+	done := make(chan error)
+	go func() { done <- l.Run(ctx) }()
+
+	wait := func(what string, ch chan string) string {
+		t.Helper()
+		select {
+		case v := <-ch:
+			return v
+		case <-time.After(3 * time.Second):
+			t.Fatalf("\t%s\tTimeout waiting for %s", testkit.Failure, what)
+		}
+		return ""
+	}
+	testkit.LogDiff(t, "LISTEN", wait("LISTEN", pg.listens), "users")
+
+	t.Log("@ Notification")
+	{
+		testkit.LogDiff(t, "Decoded payload", pg.deliver(t, "users", `{"id":7}`, events), userChanged{ID: 7})
+	}
+	t.Log("@ Subscribe while running")
+	{
+		got := make(chan string, 1)
+		err := l.Subscribe("orders", func(ctx context.Context, payload string) error {
+			got <- payload
+			return nil
+		}, nil)
+		testkit.Log(t, "Subscribe", err)
+		testkit.LogDiff(t, "LISTEN", wait("LISTEN", pg.listens), "orders")
+		pg.notify("orders", "raw")
+		testkit.LogDiff(t, "Raw payload", wait("notification", got), "raw")
+
+		err = l.Subscribe("denied", func(ctx context.Context, payload string) error { return nil }, nil)
+		testkit.LogDiff(t, "LISTEN refused", err != nil, true)
+		err = l.Subscribe("denied", func(ctx context.Context, payload string) error { return nil }, nil)
+		testkit.LogDiff(t, "Not left subscribed", err != nil && !strings.Contains(err.Error(), "already subscribed"), true)
+	}
+	t.Log("@ Reconnect")
+	{
+		pg.drop()
+		relistened := map[string]bool{}
+		relistened[wait("re-LISTEN", pg.listens)] = true
+		relistened[wait("re-LISTEN", pg.listens)] = true
+		testkit.LogCmp(t, "Re-LISTEN of all", relistened, map[string]bool{"users": true, "orders": true})
+		select {
+		case <-gaps:
+			t.Logf("\t%s\tGap reported.", testkit.Success)
+		case <-time.After(3 * time.Second):
+			t.Fatalf("\t%s\tTimeout waiting for gap", testkit.Failure)
+		}
+		testkit.LogDiff(t, "Notification after reconnect", pg.deliver(t, "users", `{"id":8}`, events), userChanged{ID: 8})
+	}
+	t.Log("@ Bad payload")
+	{
+		pg.notify("users", `not json`)
+		pg.deliver(t, "users", `{"id":9}`, events)
+		testkit.LogDiff(t, "Logged", strings.Contains(logs.String(), "dbms : listener : users : decoding payload"), true)
+	}
+	t.Log("@ Shutdown")
+	{
+		cancel()
+		select {
+		case err := <-done:
+			testkit.Log(t, "Run returns", err)
+		case <-time.After(3 * time.Second):
+			t.Fatalf("\t%s\tTimeout waiting for Run to return", testkit.Failure)
+		}
+	}
+}
@@ -0,0 +1,393 @@
+package dbms
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"hash/fnv"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ****************************************************************************
+// Versioned SQL migrations, loaded from an fs.FS (embed.FS, os.DirFS, ...)
+// of files named per version, name and direction:
+//
+//	0001_create_users.up.sql
+//	0001_create_users.down.sql
+//	0002_add_roles.up.sql
+//
+// Each is applied in its own transaction, along with its record (version, name,
+// checksum) in the migrations table, so a failed migration leaves no trace.
+// A Postgres advisory lock, held for the duration of a run, serializes runs
+// of replicas that start together; the first migrates, the others then find
+// nothing pending. The checksum (SHA-256 of the up script) of each applied
+// migration must match that of its file; editing an applied migration is an
+// error, not a silent divergence of schemas.
+// ****************************************************************************
+
+var (
+	// ErrMigrationChecksum is of an applied migration whose file has since changed.
+	ErrMigrationChecksum = errors.New("dbms : migration checksum mismatch")
+	// ErrMigrationOrder is of pending migrations of versions below the latest applied;
+	// e.g., of a merged branch, written against a schema that has since moved on.
+	ErrMigrationOrder = errors.New("dbms : migrations out of order")
+)
+
+var (
+	reMigrationFile = regexp.MustCompile(`^(\d+)_([\w-]+)\.(up|down)\.sql$`)
+	reIdentifier    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+)
+
+// Migration is a versioned pair of SQL scripts.
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string // Empty if irreversible.
+	Checksum string // Hex of SHA-256 of Up.
+}
+
+// MigrationStatus is that of a Migration, per the migrations table.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	Modified  bool // Applied, yet its Checksum differs from that recorded.
+	Missing   bool // Applied, yet absent from the source; Up and Down are empty.
+}
+
+// MigrateConfig declares the parameters of a Migrator; zero values select defaults.
+type MigrateConfig struct {
+	// Dir of the migrations in the fs.FS (default ".").
+	Dir string
+	// Table of applied migrations, optionally schema qualified (default "schema_migrations").
+	Table string
+	// LockKey of the advisory lock (default per Table).
+	LockKey int64
+	// DryRun reports the migrations that would run, and runs none.
+	DryRun bool
+	// AllowOutOfOrder applies pending migrations of versions below the latest applied,
+	// rather than refusing per ErrMigrationOrder.
+	AllowOutOfOrder bool
+}
+
+// Migrator applies, reverts, and reports the migrations of a source to a database.
+type Migrator struct {
+	db         *sqlx.DB
+	cfg        MigrateConfig
+	migrations []Migration
+}
+
+// NewMigrator returns a Migrator of the migrations of fsys per cfg.
+func NewMigrator(db *sqlx.DB, fsys fs.FS, cfg MigrateConfig) (*Migrator, error) {
+	if cfg.Dir == "" {
+		cfg.Dir = "."
+	}
+	if cfg.Table == "" {
+		cfg.Table = "schema_migrations"
+	}
+	if !reIdentifier.MatchString(cfg.Table) {
+		return nil, errors.Errorf("dbms : invalid migrations table %q", cfg.Table)
+	}
+	if cfg.LockKey == 0 {
+		h := fnv.New64a()
+		h.Write([]byte("kit.dbms.migrate:" + cfg.Table))
+		cfg.LockKey = int64(h.Sum64())
+	}
+	mm, err := LoadMigrations(fsys, cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, cfg: cfg, migrations: mm}, nil
+}
+
+// LoadMigrations returns the migrations of dir of fsys, sorted by version.
+// Files of other names are ignored; each version requires an up script.
+func LoadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "dbms : reading migrations")
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, e := range entries {
+		m := reMigrationFile.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+		v, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil || v <= 0 {
+			return nil, errors.Errorf("dbms : invalid migration version of %s", e.Name())
+		}
+		bb, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, errors.Wrap(err, "dbms : reading migrations")
+		}
+
+		mig := byVersion[v]
+		if mig == nil {
+			mig = &Migration{Version: v, Name: m[2]}
+			byVersion[v] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, errors.Errorf("dbms : migration %d is of names %q and %q", v, mig.Name, m[2])
+		}
+		script := &mig.Up
+		if m[3] == "down" {
+			script = &mig.Down
+		}
+		if *script != "" {
+			return nil, errors.Errorf("dbms : duplicate %s migration of version %d", m[3], v)
+		}
+		*script = string(bb)
+	}
+
+	mm := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if strings.TrimSpace(mig.Up) == "" {
+			return nil, errors.Errorf("dbms : migration %d (%s) has no up script", mig.Version, mig.Name)
+		}
+		sum := sha256.Sum256([]byte(mig.Up))
+		mig.Checksum = hex.EncodeToString(sum[:])
+		mm = append(mm, *mig)
+	}
+	sort.Slice(mm, func(i, j int) bool { return mm[i].Version < mm[j].Version })
+	return mm, nil
+}
+
+// Migrations returns those of the source, sorted by version.
+func (m *Migrator) Migrations() []Migration {
+	return append([]Migration(nil), m.migrations...)
+}
+
+// applied is a record of the migrations table.
+type applied struct {
+	Version   int64     `db:"version"`
+	Name      string    `db:"name"`
+	Checksum  string    `db:"checksum"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+// Status returns the status of each migration, of the source and of the
+// migrations table, sorted by version. It does not create the table.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "kit.dbms.migrate.status")
+	defer span.End()
+
+	conn, err := m.db.Connx(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "dbms : migrate")
+	}
+	defer conn.Close()
+
+	recs, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	return m.status(recs), nil
+}
+
+// Up applies the pending migrations of versions through target (0 is all),
+// in order, and returns those applied; those that would be if DryRun.
+// It fails, applying none, if an applied migration is modified or missing,
+// or if any pending precedes the latest applied (ErrMigrationOrder), unless AllowOutOfOrder.
+func (m *Migrator) Up(ctx context.Context, target int64) ([]Migration, error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "kit.dbms.migrate.up")
+	defer span.End()
+
+	var done []Migration
+	err := m.run(ctx, func(conn *sqlx.Conn, ss []MigrationStatus) error {
+		for _, s := range ss {
+			switch {
+			case s.Modified:
+				return errors.Wrapf(ErrMigrationChecksum, "%d (%s)", s.Version, s.Name)
+			case s.Missing:
+				return errors.Errorf("dbms : applied migration %d (%s) is missing from the source", s.Version, s.Name)
+			}
+		}
+		if !m.cfg.AllowOutOfOrder {
+			var latest int64
+			for _, s := range ss {
+				if s.Applied && s.Version > latest {
+					latest = s.Version
+				}
+			}
+			var late []string
+			for _, s := range ss {
+				if !s.Applied && s.Version < latest {
+					late = append(late, strconv.FormatInt(s.Version, 10))
+				}
+			}
+			if len(late) > 0 {
+				return errors.Wrapf(ErrMigrationOrder, "pending %s below applied %d", strings.Join(late, ", "), latest)
+			}
+		}
+		for _, s := range ss {
+			if s.Applied || (target > 0 && s.Version > target) {
+				continue
+			}
+			if !m.cfg.DryRun {
+				q := `INSERT INTO ` + m.cfg.Table + ` (version, name, checksum) VALUES ($1, $2, $3)`
+				err := m.exec(ctx, conn, s.Migration, s.Up, q, s.Version, s.Name, s.Checksum)
+				if err != nil {
+					return err
+				}
+			}
+			done = append(done, s.Migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the latest steps (at least 1) of the applied migrations, in
+// reverse order, and returns those reverted; those that would be if DryRun.
+// It fails, reverting none, if any of them lacks a down script.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "kit.dbms.migrate.down")
+	defer span.End()
+
+	if steps < 1 {
+		steps = 1
+	}
+	var done []Migration
+	err := m.run(ctx, func(conn *sqlx.Conn, ss []MigrationStatus) error {
+		var todo []Migration
+		for i := len(ss) - 1; i >= 0 && len(todo) < steps; i-- {
+			s := ss[i]
+			if !s.Applied {
+				continue
+			}
+			if s.Missing || strings.TrimSpace(s.Down) == "" {
+				return errors.Errorf("dbms : migration %d (%s) is irreversible", s.Version, s.Name)
+			}
+			todo = append(todo, s.Migration)
+		}
+		for _, mig := range todo {
+			if !m.cfg.DryRun {
+				q := `DELETE FROM ` + m.cfg.Table + ` WHERE version = $1`
+				if err := m.exec(ctx, conn, mig, mig.Down, q, mig.Version); err != nil {
+					return err
+				}
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// run calls fn with a connection holding the advisory lock, and the status
+// of the migrations as of having acquired it. Sans DryRun, it creates the
+// migrations table if absent.
+func (m *Migrator) run(ctx context.Context, fn func(conn *sqlx.Conn, ss []MigrationStatus) error) error {
+	// The lock is of the session, so all is of one connection.
+	conn, err := m.db.Connx(ctx)
+	if err != nil {
+		return errors.Wrap(err, "dbms : migrate")
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, m.cfg.LockKey); err != nil {
+		return errors.Wrap(err, "dbms : acquiring migration lock")
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, m.cfg.LockKey)
+
+	if !m.cfg.DryRun {
+		q := `CREATE TABLE IF NOT EXISTS ` + m.cfg.Table + ` (
+			version    bigint      PRIMARY KEY,
+			name       text        NOT NULL,
+			checksum   text        NOT NULL,
+			applied_at timestamptz NOT NULL DEFAULT now()
+		)`
+		if _, err := conn.ExecContext(ctx, q); err != nil {
+			return errors.Wrap(err, "dbms : creating migrations table")
+		}
+	}
+	recs, err := m.applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn, m.status(recs))
+}
+
+// exec runs script, and the query q (of args) recording it, in a transaction of conn.
+func (m *Migrator) exec(ctx context.Context, conn *sqlx.Conn, mig Migration, script, q string, args ...interface{}) error {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "dbms : migrate")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return errors.Wrapf(err, "dbms : migration %d (%s)", mig.Version, mig.Name)
+	}
+	if _, err := tx.ExecContext(ctx, q, args...); err != nil {
+		return errors.Wrapf(err, "dbms : recording migration %d (%s)", mig.Version, mig.Name)
+	}
+	return errors.Wrapf(tx.Commit(), "dbms : committing migration %d (%s)", mig.Version, mig.Name)
+}
+
+// applied returns the records of the migrations table; none if it does not exist.
+func (m *Migrator) applied(ctx context.Context, conn *sqlx.Conn) ([]applied, error) {
+	var exists sql.NullString
+	if err := conn.QueryRowxContext(ctx, `SELECT to_regclass($1)::text`, m.cfg.Table).Scan(&exists); err != nil {
+		return nil, errors.Wrap(err, "dbms : reading migrations table")
+	}
+	if !exists.Valid {
+		return nil, nil
+	}
+	var recs []applied
+	q := `SELECT version, name, checksum, applied_at FROM ` + m.cfg.Table + ` ORDER BY version`
+	rows, err := conn.QueryxContext(ctx, q)
+	if err != nil {
+		return nil, errors.Wrap(err, "dbms : reading migrations table")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r applied
+		if err := rows.StructScan(&r); err != nil {
+			return nil, errors.Wrap(err, "dbms : reading migrations table")
+		}
+		recs = append(recs, r)
+	}
+	return recs, errors.Wrap(rows.Err(), "dbms : reading migrations table")
+}
+
+// status merges the migrations of the source with the records of those applied.
+func (m *Migrator) status(recs []applied) []MigrationStatus {
+	byVersion := make(map[int64]applied, len(recs))
+	for _, r := range recs {
+		byVersion[r.Version] = r
+	}
+	ss := make([]MigrationStatus, 0, len(m.migrations)+len(recs))
+	for _, mig := range m.migrations {
+		s := MigrationStatus{Migration: mig}
+		if r, ok := byVersion[mig.Version]; ok {
+			s.Applied, s.AppliedAt = true, r.AppliedAt
+			s.Modified = r.Checksum != mig.Checksum
+			delete(byVersion, mig.Version)
+		}
+		ss = append(ss, s)
+	}
+	for _, r := range byVersion {
+		ss = append(ss, MigrationStatus{
+			Migration: Migration{Version: r.Version, Name: r.Name, Checksum: r.Checksum},
+			Applied:   true,
+			AppliedAt: r.AppliedAt,
+			Missing:   true,
+		})
+	}
+	sort.Slice(ss, func(i, j int) bool { return ss[i].Version < ss[j].Version })
+	return ss
+}
@@ -0,0 +1,239 @@
+package dbms_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/sempernow/kit/dbms"
+	"github.com/sempernow/kit/testkit"
+
+	"github.com/pkg/errors"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql/0002_add_roles.up.sql":         {Data: []byte("ALTER TABLE users ADD roles text[];")},
+		"sql/0001_create_users.up.sql":      {Data: []byte("CREATE TABLE users (id uuid PRIMARY KEY);")},
+		"sql/0001_create_users.down.sql":    {Data: []byte("DROP TABLE users;")},
+		"sql/README.md":                     {Data: []byte("Ignored.")},
+		"sql/0010_seed.up.sql":              {Data: []byte("INSERT INTO users VALUES (gen_random_uuid());")},
+		"other/0001_create_users.up.sql":    {Data: []byte("Not of dir.")},
+		"bad/0001_a.up.sql":                 {Data: []byte("SELECT 1;")},
+		"bad/0001_b.down.sql":               {Data: []byte("SELECT 1;")},
+		"down-only/0001_a.down.sql":         {Data: []byte("SELECT 1;")},
+		"leading-zeros/01_a.up.sql":         {Data: []byte("SELECT 1;")},
+		"leading-zeros/001_b.up.sql":        {Data: []byte("SELECT 2;")},
+		"leading-zeros/001_b.down.sql":      {Data: []byte("SELECT 2;")},
+		"zero/0000_zero.up.sql":             {Data: []byte("SELECT 0;")},
+		"empty/0001_blank.up.sql":           {Data: []byte(" \n")},
+		"empty/0001_blank.down.sql":         {Data: []byte("SELECT 1;")},
+		"checksum/0001_create_users.up.sql": {Data: []byte("CREATE TABLE users (id uuid PRIMARY KEY);\n")},
+	}
+
+	t.Log("@ Valid")
+	{
+		mm, err := dbms.LoadMigrations(fsys, "sql")
+		testkit.Log(t, "LoadMigrations", err)
+		testkit.LogDiff(t, "Count", len(mm), 3)
+		var got []int64
+		for _, m := range mm {
+			got = append(got, m.Version)
+		}
+		testkit.LogCmp(t, "Sorted by version", got, []int64{1, 2, 10})
+		testkit.LogDiff(t, "Name", mm[0].Name, "create_users")
+		testkit.LogDiff(t, "Down", mm[0].Down, "DROP TABLE users;")
+		testkit.LogDiff(t, "Irreversible", mm[1].Down, "")
+		sum := sha256.Sum256([]byte(mm[0].Up))
+		testkit.LogDiff(t, "Checksum of up", mm[0].Checksum, hex.EncodeToString(sum[:]))
+
+		mm2, _ := dbms.LoadMigrations(fsys, "checksum")
+		testkit.LogDiff(t, "Checksum differs per content", mm2[0].Checksum != mm[0].Checksum, true)
+	}
+	t.Log("@ Invalid")
+	{
+		for _, dir := range []string{"bad", "down-only", "leading-zeros", "zero", "empty", "absent"} {
+			_, err := dbms.LoadMigrations(fsys, dir)
+			testkit.LogDiff(t, "Error of "+dir, err != nil, true)
+		}
+	}
+	t.Log("@ Table")
+	{
+		_, err := dbms.NewMigrator(nil, fsys, dbms.MigrateConfig{Dir: "sql", Table: "app.schema_migrations"})
+		testkit.Log(t, "Schema-qualified table", err)
+		_, err = dbms.NewMigrator(nil, fsys, dbms.MigrateConfig{Dir: "sql", Table: "x; DROP TABLE users"})
+		testkit.LogDiff(t, "Invalid table", err != nil, true)
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := testkit.Context()
+	fsys := fstest.MapFS{
+		"0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id uuid PRIMARY KEY);")},
+		"0001_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		"0002_add_roles.up.sql":      {Data: []byte("ALTER TABLE users ADD roles text[];")},
+		"0004_seed.up.sql":           {Data: []byte("UPDATE users SET roles = '{}';")},
+		"0004_seed.down.sql":         {Data: []byte("TRUNCATE users;")},
+	}
+	db := newFakeDB(t)
+	migrator := func(fsys fstest.MapFS, cfg dbms.MigrateConfig) *dbms.Migrator {
+		m, err := dbms.NewMigrator(db, fsys, cfg)
+		testkit.Log(t, "NewMigrator", err)
+		return m
+	}
+	// log returns that of the fake since its last call.
+	log := func() string {
+		s := fake.Log()
+		fake.mu.Lock()
+		fake.log = nil
+		fake.mu.Unlock()
+		return s
+	}
+	versions := func(mm []dbms.Migration) (vv []int64) {
+		for _, m := range mm {
+			vv = append(vv, m.Version)
+		}
+		return vv
+	}
+	m := migrator(fsys, dbms.MigrateConfig{})
+
+	t.Log("@ Status of a fresh database")
+	{
+		ss, err := m.Status(ctx)
+		testkit.Log(t, "Status", err)
+		testkit.LogDiff(t, "Count", len(ss), 3)
+		testkit.LogDiff(t, "None applied", ss[0].Applied || ss[1].Applied || ss[2].Applied, false)
+		testkit.LogDiff(t, "Log sans lock or table", log(), "SELECT to_regclass")
+	}
+	t.Log("@ DryRun")
+	{
+		dry := migrator(fsys, dbms.MigrateConfig{DryRun: true})
+		mm, err := dry.Up(ctx, 0)
+		testkit.Log(t, "Up", err)
+		testkit.LogCmp(t, "Would apply", versions(mm), []int64{1, 2, 4})
+		testkit.LogDiff(t, "Log sans table or transaction", log(),
+			"SELECT pg_advisory_lock; SELECT to_regclass; SELECT pg_advisory_unlock")
+	}
+	t.Log("@ Up")
+	{
+		mm, err := m.Up(ctx, 2)
+		testkit.Log(t, "Up to 2", err)
+		testkit.LogCmp(t, "Applied", versions(mm), []int64{1, 2})
+		testkit.LogDiff(t, "Log", log(), strings.Join([]string{
+			"SELECT pg_advisory_lock", "CREATE", "SELECT to_regclass", "SELECT version",
+			"BEGIN", "CREATE", "INSERT", "COMMIT",
+			"BEGIN", "ALTER", "INSERT", "COMMIT",
+			"SELECT pg_advisory_unlock",
+		}, "; "))
+
+		mm, err = m.Up(ctx, 0)
+		testkit.Log(t, "Up", err)
+		testkit.LogCmp(t, "Applied", versions(mm), []int64{4})
+		testkit.LogDiff(t, "Log", log(), strings.Join([]string{
+			"SELECT pg_advisory_lock", "CREATE", "SELECT to_regclass", "SELECT version",
+			"BEGIN", "UPDATE", "INSERT", "COMMIT",
+			"SELECT pg_advisory_unlock",
+		}, "; "))
+
+		mm, err = m.Up(ctx, 0)
+		testkit.Log(t, "Up", err)
+		testkit.LogDiff(t, "None pending", len(mm), 0)
+		log()
+	}
+	t.Log("@ Status")
+	{
+		ss, err := m.Status(ctx)
+		testkit.Log(t, "Status", err)
+		testkit.LogDiff(t, "All applied", ss[0].Applied && ss[1].Applied && ss[2].Applied, true)
+		testkit.LogDiff(t, "Applied at", ss[0].AppliedAt.IsZero(), false)
+		testkit.LogDiff(t, "Not modified", ss[0].Modified || ss[1].Modified || ss[2].Modified, false)
+		log()
+	}
+	t.Log("@ Refusals of Up")
+	{
+		modified := fstest.MapFS{}
+		for k, v := range fsys {
+			modified[k] = v
+		}
+		modified["0002_add_roles.up.sql"] = &fstest.MapFile{Data: []byte("ALTER TABLE users ADD roles text[] NOT NULL;")}
+		_, err := migrator(modified, dbms.MigrateConfig{}).Up(ctx, 0)
+		testkit.LogDiff(t, "Modified", errors.Cause(err), dbms.ErrMigrationChecksum)
+		testkit.LogDiff(t, "Log sans transaction", log(),
+			"SELECT pg_advisory_lock; CREATE; SELECT to_regclass; SELECT version; SELECT pg_advisory_unlock")
+
+		missing := fstest.MapFS{}
+		for k, v := range fsys {
+			if !strings.HasPrefix(k, "0004") {
+				missing[k] = v
+			}
+		}
+		_, err = migrator(missing, dbms.MigrateConfig{}).Up(ctx, 0)
+		testkit.LogDiff(t, "Missing", err != nil && strings.Contains(err.Error(), "missing from the source"), true)
+		testkit.LogDiff(t, "Log sans transaction", log(),
+			"SELECT pg_advisory_lock; CREATE; SELECT to_regclass; SELECT version; SELECT pg_advisory_unlock")
+
+		late := fstest.MapFS{"0003_late.up.sql": {Data: []byte("ALTER TABLE users ADD email text;")}}
+		for k, v := range fsys {
+			late[k] = v
+		}
+		_, err = migrator(late, dbms.MigrateConfig{}).Up(ctx, 0)
+		testkit.LogDiff(t, "Out of order", errors.Cause(err), dbms.ErrMigrationOrder)
+		testkit.LogDiff(t, "Names versions", strings.Contains(err.Error(), "pending 3 below applied 4"), true)
+		testkit.LogDiff(t, "Log sans transaction", log(),
+			"SELECT pg_advisory_lock; CREATE; SELECT to_regclass; SELECT version; SELECT pg_advisory_unlock")
+
+		mm, err := migrator(late, dbms.MigrateConfig{AllowOutOfOrder: true, DryRun: true}).Up(ctx, 0)
+		testkit.Log(t, "Up of AllowOutOfOrder", err)
+		testkit.LogCmp(t, "Would apply", versions(mm), []int64{3})
+		log()
+	}
+	t.Log("@ Down")
+	{
+		mm, err := migrator(fsys, dbms.MigrateConfig{DryRun: true}).Down(ctx, 1)
+		testkit.Log(t, "DryRun Down", err)
+		testkit.LogCmp(t, "Would revert", versions(mm), []int64{4})
+		testkit.LogDiff(t, "Log sans transaction", log(),
+			"SELECT pg_advisory_lock; SELECT to_regclass; SELECT version; SELECT pg_advisory_unlock")
+
+		mm, err = m.Down(ctx, 1)
+		testkit.Log(t, "Down", err)
+		testkit.LogCmp(t, "Reverted", versions(mm), []int64{4})
+		testkit.LogDiff(t, "Log", log(), strings.Join([]string{
+			"SELECT pg_advisory_lock", "CREATE", "SELECT to_regclass", "SELECT version",
+			"BEGIN", "TRUNCATE", "DELETE", "COMMIT",
+			"SELECT pg_advisory_unlock",
+		}, "; "))
+
+		mm, err = m.Down(ctx, 2)
+		testkit.LogDiff(t, "Irreversible", err != nil && strings.Contains(err.Error(), "irreversible"), true)
+		testkit.LogDiff(t, "None reverted", len(mm), 0)
+		testkit.LogDiff(t, "Log sans transaction", log(),
+			"SELECT pg_advisory_lock; CREATE; SELECT to_regclass; SELECT version; SELECT pg_advisory_unlock")
+	}
+	t.Log("@ Failed migration")
+	{
+		failing := fstest.MapFS{
+			"0001_create_users.up.sql": fsys["0001_create_users.up.sql"],
+			"0002_add_roles.up.sql":    fsys["0002_add_roles.up.sql"],
+			"0003_bad.up.sql":          {Data: []byte("FAIL 42601")},
+		}
+		db = newFakeDB(t)
+		f := migrator(failing, dbms.MigrateConfig{})
+		mm, err := f.Up(ctx, 0)
+		testkit.LogDiff(t, "Error", err != nil && strings.Contains(err.Error(), "migration 3 (bad)"), true)
+		testkit.LogCmp(t, "Applied before", versions(mm), []int64{1, 2})
+		testkit.LogDiff(t, "Log", log(), strings.Join([]string{
+			"SELECT pg_advisory_lock", "CREATE", "SELECT to_regclass", "SELECT version",
+			"BEGIN", "CREATE", "INSERT", "COMMIT",
+			"BEGIN", "ALTER", "INSERT", "COMMIT",
+			"BEGIN", "FAIL", "ROLLBACK",
+			"SELECT pg_advisory_unlock",
+		}, "; "))
+
+		ss, err := f.Status(ctx)
+		testkit.Log(t, "Status", err)
+		testkit.LogDiff(t, "Failed not recorded", ss[2].Applied, false)
+	}
+}
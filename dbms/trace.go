@@ -0,0 +1,187 @@
+package dbms
+
+import (
+	"context"
+	"database/sql"
+	"expvar"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// sqlErrors counts the errors of TracedDB queries by SQLSTATE class ("23", "40", ...),
+// else "other"; published as expvar "dbms_errors".
+var sqlErrors = expvar.NewMap("dbms_errors")
+
+// Secret is an argument that is always redacted when logged, regardless of Redactor.
+// It is passed to the driver as a string.
+//
+//	db.ExecContext(ctx, q, email, dbms.Secret(token))
+type Secret string
+
+// Redacted replaces the value of each redacted argument when logged.
+const Redacted = "[REDACTED]"
+
+// Redactor reports whether the argument of placeholder $n is redacted when logged.
+type Redactor func(n int, arg interface{}) bool
+
+// RedactAll redacts every argument; the default of TracedDB and Log.
+func RedactAll(int, interface{}) bool { return true }
+
+// RedactNone redacts no argument, less those of Secret.
+func RedactNone(int, interface{}) bool { return false }
+
+// RedactArgs redacts the arguments of placeholders ns.
+func RedactArgs(ns ...int) Redactor {
+	return func(n int, _ interface{}) bool {
+		for _, x := range ns {
+			if x == n {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// TraceConfig declares the parameters of TracedDB; zero values select defaults.
+type TraceConfig struct {
+	// Log of slow queries; nil for none.
+	Log *log.Logger
+	// SlowQuery is the duration from which a query is logged (default 200ms).
+	SlowQuery time.Duration
+	// Redact selects the arguments redacted when logged (default RedactAll).
+	Redact Redactor
+}
+
+// TracedDB wraps a sqlx.DB, instrumenting its queries of context:
+// each is of an OpenTelemetry span (kit.dbms.query), those of SlowQuery or longer are
+// logged with arguments redacted per Redact, and errors are counted by SQLSTATE class
+// (see expvar "dbms_errors"). Methods sans context, and transactions, are those of the
+// embedded DB, and are not instrumented.
+//
+//	db := dbms.NewTracedDB(sqlxDB, dbms.TraceConfig{Log: log})
+//	err := db.GetContext(ctx, &u, `SELECT * FROM users WHERE email = $1`, email)
+type TracedDB struct {
+	*sqlx.DB
+	cfg TraceConfig
+}
+
+// NewTracedDB returns db instrumented per cfg.
+func NewTracedDB(db *sqlx.DB, cfg TraceConfig) *TracedDB {
+	if cfg.SlowQuery <= 0 {
+		cfg.SlowQuery = 200 * time.Millisecond
+	}
+	if cfg.Redact == nil {
+		cfg.Redact = RedactAll
+	}
+	return &TracedDB{DB: db, cfg: cfg}
+}
+
+// ExecContext is that of sqlx.DB, instrumented.
+func (db *TracedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, done := db.start(ctx, query, args)
+	res, err := db.DB.ExecContext(ctx, query, args...)
+	done(err)
+	return res, err
+}
+
+// QueryContext is that of sqlx.DB, instrumented until the driver returns its rows;
+// errors of iterating and scanning them are not of the span, but of rows.Err().
+func (db *TracedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, done := db.start(ctx, query, args)
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	done(err)
+	return rows, err
+}
+
+// QueryxContext is that of sqlx.DB, instrumented until the driver returns its rows;
+// errors of iterating and scanning them are not of the span, but of rows.Err().
+func (db *TracedDB) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	ctx, done := db.start(ctx, query, args)
+	rows, err := db.DB.QueryxContext(ctx, query, args...)
+	done(err)
+	return rows, err
+}
+
+// QueryRowxContext is that of sqlx.DB, instrumented.
+func (db *TracedDB) QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row {
+	ctx, done := db.start(ctx, query, args)
+	row := db.DB.QueryRowxContext(ctx, query, args...)
+	done(row.Err())
+	return row
+}
+
+// GetContext is that of sqlx.DB, instrumented.
+func (db *TracedDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return sqlx.GetContext(ctx, db, dest, query, args...)
+}
+
+// SelectContext is that of sqlx.DB, instrumented.
+func (db *TracedDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return sqlx.SelectContext(ctx, db, dest, query, args...)
+}
+
+// start begins the span of a query, and returns its context and the func that ends it.
+func (db *TracedDB) start(ctx context.Context, query string, args []interface{}) (context.Context, func(error)) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "kit.dbms.query")
+	span.SetAttributes(semconv.DBSystemPostgres, semconv.DBStatementKey.String(query))
+	t0 := time.Now()
+
+	return ctx, func(err error) {
+		defer span.End()
+		took := time.Since(t0)
+
+		if err != nil && err != sql.ErrNoRows {
+			class := "other"
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) {
+				class = string(pqErr.Code.Class())
+			}
+			sqlErrors.Add(class, 1)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, class)
+		}
+		if db.cfg.Log != nil && took >= db.cfg.SlowQuery {
+			db.cfg.Log.Printf("dbms : slow query (%s) : %s", took.Round(time.Millisecond), format(query, args, db.cfg.Redact))
+		}
+	}
+}
+
+var rePlaceholder = regexp.MustCompile(`\$(\d+)`)
+
+// format returns query on one line, with each placeholder ($n) replaced by
+// its argument, else Redacted per redact, or if of a Secret.
+func format(query string, args []interface{}, redact Redactor) string {
+	query = rePlaceholder.ReplaceAllStringFunc(query, func(p string) string {
+		n, _ := strconv.Atoi(p[1:])
+		if n < 1 || n > len(args) {
+			return p
+		}
+		arg := args[n-1]
+		if _, ok := arg.(Secret); ok || (redact != nil && redact(n, arg)) {
+			return Redacted
+		}
+		switch v := arg.(type) {
+		case string:
+			return fmt.Sprintf("%q", v)
+		case []byte:
+			return string(v)
+		case []string:
+			return strings.Join(v, ",")
+		default:
+			return fmt.Sprintf("%v", v)
+		}
+	})
+	query = strings.Replace(query, "\t", "", -1)
+	return strings.Replace(query, "\n", " ", -1)
+}
@@ -0,0 +1,77 @@
+package dbms_test
+
+import (
+	"bytes"
+	"expvar"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sempernow/kit/dbms"
+	"github.com/sempernow/kit/testkit"
+)
+
+func TestLog(t *testing.T) {
+	args := make([]interface{}, 10)
+	for i := range args {
+		args[i] = i + 1
+	}
+	q := "SELECT *\n\tFROM foo\n\tWHERE a = $1 AND j = $10 AND x = $11"
+	testkit.LogDiff(t, "$1 is not of $10", dbms.LogWith(dbms.RedactNone, q, args...),
+		"SELECT * FROM foo WHERE a = 1 AND j = 10 AND x = $11")
+
+	testkit.LogDiff(t, "Argument is not substituted", dbms.LogWith(dbms.RedactNone, "SELECT $1, $2", "$2", "b"),
+		`SELECT "$2", "b"`)
+	testkit.LogDiff(t, "Secret is redacted", dbms.LogWith(dbms.RedactNone, "UPDATE u SET token = $1 WHERE id = $2", dbms.Secret("s3cr3t"), 7),
+		"UPDATE u SET token = "+dbms.Redacted+" WHERE id = 7")
+	testkit.LogDiff(t, "Redacted by default", dbms.Log("SELECT $1, $2", "a", 2),
+		"SELECT "+dbms.Redacted+", "+dbms.Redacted)
+}
+
+func TestTracedDB(t *testing.T) {
+	ctx := testkit.Context()
+	var buf bytes.Buffer
+	logger := log.New(&buf, "", 0)
+
+	t.Log("@ Slow query")
+	{
+		db := dbms.NewTracedDB(newFakeDB(t), dbms.TraceConfig{Log: logger, SlowQuery: time.Nanosecond})
+		_, err := db.ExecContext(ctx, "INSERT INTO users VALUES ($1, $2)", "alice", "hunter2")
+		testkit.Log(t, "ExecContext", err)
+		got := buf.String()
+		testkit.LogDiff(t, "Logged", strings.HasPrefix(got, "dbms : slow query ("), true)
+		testkit.LogDiff(t, "Redacted by default",
+			strings.HasSuffix(got, "INSERT INTO users VALUES ([REDACTED], [REDACTED])\n"), true)
+
+		buf.Reset()
+		db = dbms.NewTracedDB(newFakeDB(t), dbms.TraceConfig{Log: logger, SlowQuery: time.Nanosecond, Redact: dbms.RedactArgs(2)})
+		db.ExecContext(ctx, "INSERT INTO users VALUES ($1, $2, $3)", "alice", "hunter2", dbms.Secret("tok"))
+		testkit.LogDiff(t, "Redacted per policy",
+			strings.HasSuffix(buf.String(), `INSERT INTO users VALUES ("alice", [REDACTED], [REDACTED])`+"\n"), true)
+
+		buf.Reset()
+		db = dbms.NewTracedDB(newFakeDB(t), dbms.TraceConfig{Log: logger})
+		db.ExecContext(ctx, "INSERT INTO users VALUES ($1)", "alice")
+		testkit.LogDiff(t, "Not logged under threshold", buf.Len(), 0)
+	}
+	t.Log("@ Errors by SQLSTATE class")
+	{
+		count := func(class string) int64 {
+			v := expvar.Get("dbms_errors").(*expvar.Map).Get(class)
+			if v == nil {
+				return 0
+			}
+			return v.(*expvar.Int).Value()
+		}
+		n23, n40 := count("23"), count("40")
+		db := dbms.NewTracedDB(newFakeDB(t), dbms.TraceConfig{})
+		_, err := db.ExecContext(ctx, "FAIL 23505")
+		testkit.LogDiff(t, "Error returned", err != nil, true)
+		db.ExecContext(ctx, "FAIL 23503")
+		db.ExecContext(ctx, "FAIL 40001")
+		db.ExecContext(ctx, "SELECT 1")
+		testkit.LogDiff(t, "Class 23", count("23")-n23, int64(2))
+		testkit.LogDiff(t, "Class 40", count("40")-n40, int64(1))
+	}
+}
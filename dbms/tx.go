@@ -0,0 +1,143 @@
+package dbms
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TxOptions declares the parameters of WithTx(..); zero values select defaults.
+type TxOptions struct {
+	// Isolation level (default that of the database; READ COMMITTED of Postgres).
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+	// MaxRetries of the transaction upon a serialization failure or deadlock (default 3);
+	// negative for none.
+	MaxRetries int
+	// RetryDelay is the base of the jittered exponential backoff between attempts (default 20ms).
+	RetryDelay time.Duration
+}
+
+// savepoints numbers the savepoints of Savepoint(..); unique per process.
+var savepoints uint64
+
+// WithTx runs fn in a transaction of db, and commits it if fn returns nil, else rolls it back.
+// If fn panics, the transaction is rolled back and the panic resumes.
+// Upon a serialization failure (40001) or deadlock (40P01), of fn or of commit,
+// the whole transaction is retried, so fn must be safe to rerun; e.g., sans side effects
+// outside the database. Errors of fn are returned as is.
+//
+//	err := dbms.WithTx(ctx, db, dbms.TxOptions{Isolation: sql.LevelSerializable}, func(tx *sqlx.Tx) error {
+//		if _, err := tx.ExecContext(ctx, q1, ...); err != nil {
+//			return err
+//		}
+//		return dbms.Savepoint(ctx, tx, func(tx *sqlx.Tx) error { ... })
+//	})
+func WithTx(ctx context.Context, db *sqlx.DB, opts TxOptions, fn func(tx *sqlx.Tx) error) error {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "kit.dbms.withtx")
+	defer span.End()
+
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = 3
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = 20 * time.Millisecond
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := runTx(ctx, db, opts, fn)
+		if err == nil || !Retryable(err) || attempt >= opts.MaxRetries {
+			return err
+		}
+		// Jittered over [delay/2, delay*3/2) of the doubling delay, capped at 1s.
+		delay := opts.RetryDelay << attempt
+		if delay > time.Second || delay <= 0 {
+			delay = time.Second
+		}
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay)))
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return errors.Wrapf(ctx.Err(), "retrying transaction : %v", err)
+		case <-t.C:
+		}
+	}
+}
+
+// runTx is one attempt of WithTx(..).
+func runTx(ctx context.Context, db *sqlx.DB, opts TxOptions, fn func(tx *sqlx.Tx) error) (err error) {
+	tx, err := db.BeginTxx(ctx, &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly})
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		if errRB := tx.Rollback(); errRB != nil && errRB != sql.ErrTxDone {
+			return errors.Wrapf(err, "rollback failed : %v", errRB)
+		}
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+	return nil
+}
+
+// Savepoint runs fn within a savepoint of tx; a nested transaction.
+// If fn returns an error (or panics), only its work is rolled back, and tx remains usable.
+// A serialization failure is of the whole transaction, so WithTx(..) retries it regardless.
+func Savepoint(ctx context.Context, tx *sqlx.Tx, fn func(tx *sqlx.Tx) error) (err error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "kit.dbms.savepoint")
+	defer span.End()
+
+	name := fmt.Sprintf("kit_sp_%d", atomic.AddUint64(&savepoints, 1))
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return errors.Wrap(err, "creating savepoint")
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		if _, errRB := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); errRB != nil {
+			return errors.Wrapf(err, "rollback to savepoint failed : %v", errRB)
+		}
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		return errors.Wrap(err, "releasing savepoint")
+	}
+	return nil
+}
+
+// Retryable reports whether err is of a transaction that may succeed if retried;
+// a serialization failure (40001) or deadlock (40P01).
+func Retryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	switch pqErr.Code {
+	case "40001", "40P01":
+		return true
+	}
+	return false
+}
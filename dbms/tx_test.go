@@ -0,0 +1,229 @@
+package dbms_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sempernow/kit/dbms"
+	"github.com/sempernow/kit/testkit"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// fakeDB is a database/sql driver that logs statements and transaction outcomes,
+// and fails commits per the codes of its queue. It keeps the migrations table
+// (schema_migrations) of Migrator, sans transactional semantics.
+type fakeDB struct {
+	mu      sync.Mutex
+	log     []string
+	failing []pq.ErrorCode // Of successive commits.
+	table   bool           // Migrations table exists.
+	applied [][]driver.Value
+}
+
+func (d *fakeDB) record(s string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.log = append(d.log, s)
+}
+
+func (d *fakeDB) Log() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return strings.Join(d.log, "; ")
+}
+
+func (d *fakeDB) Open(string) (driver.Conn, error) { return fakeConn{d}, nil }
+
+type fakeConn struct{ d *fakeDB }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c fakeConn) Close() error                        { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)           { c.d.record("BEGIN"); return fakeTx(c), nil }
+
+// label is that of q in the log: its first word, and the first item selected if a SELECT.
+func label(q string) string {
+	ff := strings.Fields(q)
+	if ff[0] == "SELECT" && len(ff) > 1 {
+		return "SELECT " + strings.TrimRight(strings.SplitN(ff[1], "(", 2)[0], ",")
+	}
+	return ff[0]
+}
+
+func (c fakeConn) ExecContext(ctx context.Context, q string, args []driver.NamedValue) (driver.Result, error) {
+	ff := strings.Fields(q)
+	c.d.record(label(q))
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	switch {
+	case ff[0] == "FAIL": //... FAIL <SQLSTATE>
+		return nil, &pq.Error{Code: pq.ErrorCode(ff[1])}
+	case strings.HasPrefix(q, "CREATE TABLE IF NOT EXISTS schema_migrations"):
+		c.d.table = true
+	case strings.HasPrefix(q, "INSERT INTO schema_migrations"):
+		c.d.applied = append(c.d.applied, []driver.Value{args[0].Value, args[1].Value, args[2].Value, time.Now()})
+	case strings.HasPrefix(q, "DELETE FROM schema_migrations"):
+		for i, row := range c.d.applied {
+			if row[0] == args[0].Value {
+				c.d.applied = append(c.d.applied[:i], c.d.applied[i+1:]...)
+				break
+			}
+		}
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (c fakeConn) QueryContext(ctx context.Context, q string, args []driver.NamedValue) (driver.Rows, error) {
+	c.d.record(label(q))
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	switch {
+	case strings.HasPrefix(q, "SELECT to_regclass"):
+		rows := &fakeRows{cols: []string{"to_regclass"}, vals: [][]driver.Value{{nil}}}
+		if c.d.table {
+			rows.vals[0][0] = args[0].Value
+		}
+		return rows, nil
+	case strings.HasPrefix(q, "SELECT version, name, checksum, applied_at FROM schema_migrations"):
+		vals := append([][]driver.Value(nil), c.d.applied...)
+		sort.Slice(vals, func(i, j int) bool { return vals[i][0].(int64) < vals[j][0].(int64) })
+		return &fakeRows{cols: []string{"version", "name", "checksum", "applied_at"}, vals: vals}, nil
+	}
+	return nil, errors.Errorf("fake : query not supported : %s", q)
+}
+
+type fakeRows struct {
+	cols []string
+	vals [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.vals) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.vals[0])
+	r.vals = r.vals[1:]
+	return nil
+}
+
+type fakeTx fakeConn
+
+func (tx fakeTx) Commit() error {
+	tx.d.mu.Lock()
+	defer tx.d.mu.Unlock()
+	if len(tx.d.failing) > 0 {
+		code := tx.d.failing[0]
+		tx.d.failing = tx.d.failing[1:]
+		tx.d.log = append(tx.d.log, "COMMIT "+string(code))
+		return &pq.Error{Code: code}
+	}
+	tx.d.log = append(tx.d.log, "COMMIT")
+	return nil
+}
+
+func (tx fakeTx) Rollback() error { fakeConn(tx).d.record("ROLLBACK"); return nil }
+
+var fake = &fakeDB{}
+
+func init() {
+	sql.Register("kit-fake", fake)
+}
+
+func newFakeDB(t *testing.T, failing ...pq.ErrorCode) *sqlx.DB {
+	t.Helper()
+	fake.mu.Lock()
+	fake.log, fake.failing = nil, failing
+	fake.table, fake.applied = false, nil
+	fake.mu.Unlock()
+	db, err := sqlx.Open("kit-fake", "")
+	testkit.Log(t, "Open fake database", err)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestWithTx(t *testing.T) {
+	ctx := testkit.Context()
+	insert := func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, "INSERT INTO foo VALUES (1)")
+		return err
+	}
+
+	t.Log("@ Commit")
+	{
+		db := newFakeDB(t)
+		testkit.Log(t, "WithTx", dbms.WithTx(ctx, db, dbms.TxOptions{}, insert))
+		testkit.LogDiff(t, "Log", fake.Log(), "BEGIN; INSERT; COMMIT")
+	}
+	t.Log("@ Rollback")
+	{
+		db := newFakeDB(t)
+		errFn := errors.New("fn failed")
+		err := dbms.WithTx(ctx, db, dbms.TxOptions{}, func(tx *sqlx.Tx) error { insert(tx); return errFn })
+		testkit.LogDiff(t, "Error of fn as is", err, errFn)
+		testkit.LogDiff(t, "Log", fake.Log(), "BEGIN; INSERT; ROLLBACK")
+	}
+	t.Log("@ Retry")
+	{
+		db := newFakeDB(t, "40001", "40P01")
+		testkit.Log(t, "WithTx", dbms.WithTx(ctx, db, dbms.TxOptions{}, insert))
+		testkit.LogDiff(t, "Log", fake.Log(),
+			"BEGIN; INSERT; COMMIT 40001; BEGIN; INSERT; COMMIT 40P01; BEGIN; INSERT; COMMIT")
+
+		db = newFakeDB(t, "40001", "40001")
+		err := dbms.WithTx(ctx, db, dbms.TxOptions{MaxRetries: 1}, insert)
+		testkit.LogDiff(t, "Retries exhausted", dbms.Retryable(err), true)
+
+		db = newFakeDB(t, "23505")
+		err = dbms.WithTx(ctx, db, dbms.TxOptions{}, insert)
+		testkit.LogDiff(t, "Not retryable", err != nil && !dbms.Retryable(err), true)
+		testkit.LogDiff(t, "Single attempt", fake.Log(), "BEGIN; INSERT; COMMIT 23505")
+	}
+	t.Log("@ Canceled during backoff")
+	{
+		db := newFakeDB(t, "40001")
+		ctx, cancel := context.WithCancel(ctx)
+		time.AfterFunc(50*time.Millisecond, cancel) //... backoff is at least 500ms.
+		err := dbms.WithTx(ctx, db, dbms.TxOptions{RetryDelay: time.Minute}, insert)
+		testkit.LogDiff(t, "Error is of context", errors.Is(err, context.Canceled), true)
+		testkit.LogDiff(t, "Single attempt", fake.Log(), "BEGIN; INSERT; COMMIT 40001")
+	}
+	t.Log("@ Panic")
+	{
+		db := newFakeDB(t)
+		func() {
+			defer func() {
+				testkit.LogDiff(t, "Panic resumes", recover(), "boom")
+			}()
+			dbms.WithTx(ctx, db, dbms.TxOptions{}, func(tx *sqlx.Tx) error { panic("boom") })
+		}()
+		testkit.LogDiff(t, "Log", fake.Log(), "BEGIN; ROLLBACK")
+	}
+	t.Log("@ Savepoint")
+	{
+		db := newFakeDB(t)
+		err := dbms.WithTx(ctx, db, dbms.TxOptions{}, func(tx *sqlx.Tx) error {
+			if err := insert(tx); err != nil {
+				return err
+			}
+			errInner := dbms.Savepoint(ctx, tx, func(tx *sqlx.Tx) error { insert(tx); return errors.New("inner") })
+			testkit.LogDiff(t, "Inner error", errInner.Error(), "inner")
+			return dbms.Savepoint(ctx, tx, insert)
+		})
+		testkit.Log(t, "WithTx", err)
+		testkit.LogDiff(t, "Log", fake.Log(),
+			"BEGIN; INSERT; SAVEPOINT; INSERT; ROLLBACK; SAVEPOINT; INSERT; RELEASE; COMMIT")
+	}
+}
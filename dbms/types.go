@@ -0,0 +1,263 @@
+package dbms
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// ****************************************************************************
+// Column types that round-trip, unchanged, through sqlx (sql.Scanner and
+// driver.Valuer) and through JSON (of web.Respond(..) and web.Decode(..)):
+//
+//	Null[T]       : T, or NULL of SQL and null of JSON.
+//	JSONB[T]      : T of a json or jsonb column.
+//	StringArray   : text[]; nil and empty are both {} and [].
+//	Int64Array    : bigint[]; nil and empty are both {} and [].
+//
+//	type User struct {
+//		ID       string          `db:"id" json:"id"`
+//		Nickname Null[string]    `db:"nickname" json:"nickname"`
+//		LastSeen Null[time.Time] `db:"last_seen" json:"last_seen"`
+//		Prefs    JSONB[Prefs]    `db:"prefs" json:"prefs"`
+//		Roles    StringArray     `db:"roles" json:"roles"`
+//	}
+// ****************************************************************************
+
+// Null is a T that may be NULL (!Valid); per sql.NullString and such, of any T.
+type Null[T any] struct {
+	V     T
+	Valid bool
+}
+
+// NullOf returns the valid Null of v.
+func NullOf[T any](v T) Null[T] {
+	return Null[T]{V: v, Valid: true}
+}
+
+// NullIfZero returns the Null of v, which is NULL if v is the zero value of T;
+// e.g., of an empty string.
+func NullIfZero[T comparable](v T) Null[T] {
+	var zero T
+	return Null[T]{V: v, Valid: v != zero}
+}
+
+// Ptr returns a pointer to V, else nil if NULL.
+func (n Null[T]) Ptr() *T {
+	if !n.Valid {
+		return nil
+	}
+	return &n.V
+}
+
+// Scan implements sql.Scanner.
+func (n *Null[T]) Scan(src interface{}) error {
+	var zero T
+	n.V, n.Valid = zero, false
+	if src == nil {
+		return nil
+	}
+	if err := scanValue(&n.V, src); err != nil {
+		return errors.Wrapf(err, "dbms : scanning Null[%T]", zero)
+	}
+	n.Valid = true
+	return nil
+}
+
+// Value implements driver.Valuer.
+func (n Null[T]) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+	return driver.DefaultParameterConverter.ConvertValue(n.V) //... of V if a driver.Valuer.
+}
+
+// MarshalJSON implements json.Marshaler; null if NULL.
+func (n Null[T]) MarshalJSON() ([]byte, error) {
+	if !n.Valid {
+		return []byte("null"), nil
+	}
+	return json.Marshal(n.V)
+}
+
+// UnmarshalJSON implements json.Unmarshaler; NULL if null.
+func (n *Null[T]) UnmarshalJSON(bb []byte) error {
+	var zero T
+	n.V, n.Valid = zero, false
+	if bytes.Equal(bytes.TrimSpace(bb), []byte("null")) {
+		return nil
+	}
+	if err := json.Unmarshal(bb, &n.V); err != nil {
+		return err
+	}
+	n.Valid = true
+	return nil
+}
+
+// scanValue assigns src, a non-nil value of a driver, to dest, per the
+// conversions of database/sql, by way of its Null types; else per reflection.
+func scanValue(dest interface{}, src interface{}) error {
+	if s, ok := dest.(sql.Scanner); ok {
+		return s.Scan(src)
+	}
+	switch d := dest.(type) {
+	case *string:
+		var v sql.NullString
+		err := v.Scan(src)
+		*d = v.String
+		return err
+	case *[]byte:
+		switch s := src.(type) {
+		case []byte:
+			*d = append([]byte(nil), s...)
+		case string:
+			*d = []byte(s)
+		default:
+			return errors.Errorf("cannot convert %T to []byte", src)
+		}
+		return nil
+	case *int64:
+		var v sql.NullInt64
+		err := v.Scan(src)
+		*d = v.Int64
+		return err
+	case *int:
+		var v sql.NullInt64
+		err := v.Scan(src)
+		*d = int(v.Int64)
+		return err
+	case *int32:
+		var v sql.NullInt32
+		err := v.Scan(src)
+		*d = v.Int32
+		return err
+	case *float64:
+		var v sql.NullFloat64
+		err := v.Scan(src)
+		*d = v.Float64
+		return err
+	case *bool:
+		var v sql.NullBool
+		err := v.Scan(src)
+		*d = v.Bool
+		return err
+	case *time.Time:
+		var v sql.NullTime
+		err := v.Scan(src)
+		*d = v.Time
+		return err
+	}
+	//... of named types; e.g., type Role string, type Level int.
+	dv, sv := reflect.ValueOf(dest).Elem(), reflect.ValueOf(src)
+	switch dv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := src.(int64); ok && !dv.OverflowInt(i) {
+			dv.SetInt(i)
+			return nil
+		}
+	default:
+		if sv.Kind() == dv.Kind() && sv.Type().ConvertibleTo(dv.Type()) {
+			dv.Set(sv.Convert(dv.Type()))
+			return nil
+		}
+	}
+	return errors.Errorf("cannot convert %T to %s", src, dv.Type())
+}
+
+// JSONB is a T of a json or jsonb column; its JSON is that of T.
+// A NULL column scans as the zero T; see Null[JSONB[T]] to tell them apart.
+type JSONB[T any] struct {
+	V T
+}
+
+// Scan implements sql.Scanner.
+func (j *JSONB[T]) Scan(src interface{}) error {
+	var zero T
+	j.V = zero
+	var bb []byte
+	switch s := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		bb = s
+	case string:
+		bb = []byte(s)
+	default:
+		return errors.Errorf("dbms : cannot convert %T to JSONB", src)
+	}
+	return errors.Wrap(json.Unmarshal(bb, &j.V), "dbms : scanning JSONB")
+}
+
+// Value implements driver.Valuer; JSON as text, which Postgres casts to json or jsonb,
+// whereas lib/pq would send []byte as bytea.
+func (j JSONB[T]) Value() (driver.Value, error) {
+	bb, err := json.Marshal(j.V)
+	if err != nil {
+		return nil, errors.Wrap(err, "dbms : JSONB value")
+	}
+	return string(bb), nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (j JSONB[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(j.V)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (j *JSONB[T]) UnmarshalJSON(bb []byte) error {
+	return json.Unmarshal(bb, &j.V)
+}
+
+// StringArray is a text[] column; NULL scans as empty, and nil is stored as {} and encoded as [].
+type StringArray []string
+
+// Scan implements sql.Scanner.
+func (a *StringArray) Scan(src interface{}) error {
+	return (*pq.StringArray)(a).Scan(src)
+}
+
+// Value implements driver.Valuer.
+func (a StringArray) Value() (driver.Value, error) {
+	if a == nil {
+		a = StringArray{}
+	}
+	return pq.StringArray(a).Value()
+}
+
+// MarshalJSON implements json.Marshaler.
+func (a StringArray) MarshalJSON() ([]byte, error) {
+	if a == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]string(a))
+}
+
+// Int64Array is a bigint[] column; NULL scans as empty, and nil is stored as {} and encoded as [].
+type Int64Array []int64
+
+// Scan implements sql.Scanner.
+func (a *Int64Array) Scan(src interface{}) error {
+	return (*pq.Int64Array)(a).Scan(src)
+}
+
+// Value implements driver.Valuer.
+func (a Int64Array) Value() (driver.Value, error) {
+	if a == nil {
+		a = Int64Array{}
+	}
+	return pq.Int64Array(a).Value()
+}
+
+// MarshalJSON implements json.Marshaler.
+func (a Int64Array) MarshalJSON() ([]byte, error) {
+	if a == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]int64(a))
+}
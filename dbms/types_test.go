@@ -0,0 +1,127 @@
+package dbms_test
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/sempernow/kit/dbms"
+	"github.com/sempernow/kit/testkit"
+)
+
+type role string
+
+type prefs struct {
+	Theme string   `json:"theme"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+type user struct {
+	ID       string                     `db:"id" json:"id"`
+	Nickname dbms.Null[string]          `db:"nickname" json:"nickname"`
+	Age      dbms.Null[int]             `db:"age" json:"age"`
+	Role     dbms.Null[role]            `db:"role" json:"role"`
+	LastSeen dbms.Null[time.Time]       `db:"last_seen" json:"last_seen"`
+	Prefs    dbms.JSONB[prefs]          `db:"prefs" json:"prefs"`
+	Roles    dbms.StringArray           `db:"roles" json:"roles"`
+	Scores   dbms.Null[dbms.Int64Array] `db:"scores" json:"scores"`
+}
+
+func TestNull(t *testing.T) {
+	t.Log("@ Scan")
+	{
+		var s dbms.Null[string]
+		testkit.Log(t, "Scan of []byte", s.Scan([]byte("bob")))
+		testkit.LogDiff(t, "Value", s, dbms.NullOf("bob"))
+		testkit.Log(t, "Scan of nil", s.Scan(nil))
+		testkit.LogDiff(t, "NULL", s, dbms.Null[string]{})
+
+		var n dbms.Null[int]
+		testkit.Log(t, "Scan of int64", n.Scan(int64(42)))
+		testkit.LogDiff(t, "Int", n, dbms.NullOf(42))
+
+		var r dbms.Null[role]
+		testkit.Log(t, "Scan of named type", r.Scan("admin"))
+		testkit.LogDiff(t, "Role", r, dbms.NullOf(role("admin")))
+
+		at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		var tm dbms.Null[time.Time]
+		testkit.Log(t, "Scan of time", tm.Scan(at))
+		testkit.LogDiff(t, "Time", tm.V.Equal(at) && tm.Valid, true)
+
+		var a dbms.Null[dbms.Int64Array]
+		testkit.Log(t, "Scan of Scanner", a.Scan([]byte("{1,2,3}")))
+		testkit.LogCmp(t, "Array", a, dbms.NullOf(dbms.Int64Array{1, 2, 3}))
+
+		testkit.LogDiff(t, "Error of mismatch", n.Scan("x") != nil, true)
+		testkit.LogDiff(t, "NULL upon error", n.Valid, false)
+	}
+	t.Log("@ Value")
+	{
+		for _, c := range []struct {
+			name string
+			v    driver.Valuer
+			exp  driver.Value
+		}{
+			{"NULL", dbms.Null[string]{}, nil},
+			{"String", dbms.NullOf("bob"), "bob"},
+			{"Int", dbms.NullOf(42), int64(42)},
+			{"Named", dbms.NullOf(role("admin")), "admin"},
+			{"Of Valuer", dbms.NullOf(dbms.StringArray{"a", "b c"}), `{"a","b c"}`},
+			{"Zero", dbms.NullIfZero(""), nil},
+			{"Not zero", dbms.NullIfZero(7), int64(7)},
+		} {
+			got, err := c.v.Value()
+			testkit.Log(t, c.name, err)
+			testkit.LogCmp(t, c.name, got, c.exp)
+		}
+	}
+}
+
+func TestColumnTypes(t *testing.T) {
+	t.Log("@ JSONB")
+	{
+		j := dbms.JSONB[prefs]{V: prefs{Theme: "dark", Tags: []string{"a"}}}
+		v, err := j.Value()
+		testkit.Log(t, "Value", err)
+		testkit.LogDiff(t, "Value of text", v, driver.Value(`{"theme":"dark","tags":["a"]}`))
+
+		var got dbms.JSONB[prefs]
+		testkit.Log(t, "Scan", got.Scan([]byte(v.(string))))
+		testkit.LogCmp(t, "Round trip", got, j)
+		testkit.LogDiff(t, "Scan of invalid", got.Scan([]byte("{")) != nil, true)
+	}
+	t.Log("@ Arrays")
+	{
+		var ss dbms.StringArray
+		testkit.Log(t, "Scan", ss.Scan([]byte(`{a,"b c"}`)))
+		testkit.LogCmp(t, "StringArray", ss, dbms.StringArray{"a", "b c"})
+		testkit.Log(t, "Scan of NULL", ss.Scan(nil))
+		v, _ := ss.Value()
+		testkit.LogCmp(t, "Nil as {}", v, driver.Value("{}"))
+
+		var ii dbms.Int64Array
+		testkit.Log(t, "Scan", ii.Scan([]byte(`{1,-2}`)))
+		testkit.LogCmp(t, "Int64Array", ii, dbms.Int64Array{1, -2})
+	}
+	t.Log("@ JSON")
+	{
+		u := user{
+			ID:       "u1",
+			Age:      dbms.NullOf(30),
+			Role:     dbms.NullOf(role("admin")),
+			LastSeen: dbms.NullOf(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)),
+			Prefs:    dbms.JSONB[prefs]{V: prefs{Theme: "dark"}},
+		}
+		bb, err := json.Marshal(u)
+		testkit.Log(t, "Marshal", err)
+		testkit.LogDiff(t, "JSON", string(bb), `{"id":"u1","nickname":null,"age":30,"role":"admin",`+
+			`"last_seen":"2024-01-02T03:04:05Z","prefs":{"theme":"dark"},"roles":[],"scores":null}`)
+
+		var got user
+		testkit.Log(t, "Unmarshal", json.Unmarshal(bb, &got))
+		u.Roles = dbms.StringArray{}
+		testkit.LogCmp(t, "Round trip", got, u)
+	}
+}
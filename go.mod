@@ -3,7 +3,6 @@ module github.com/sempernow/kit
 go 1.19
 
 require (
-	github.com/NYTimes/gziphandler v1.1.1
 	github.com/dimfeld/httptreemux/v5 v5.4.0
 	github.com/go-playground/locales v0.14.0
 	github.com/go-playground/universal-translator v0.18.0
@@ -16,6 +15,7 @@ require (
 	github.com/orcaman/concurrent-map v1.0.0
 	github.com/pkg/errors v0.9.1
 	go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp v0.17.0
+	go.opentelemetry.io/otel v0.17.0
 	go.opentelemetry.io/otel/trace v0.17.0
 	gopkg.in/go-playground/validator.v9 v9.31.0
 	lukechampine.com/blake3 v1.1.7
@@ -27,7 +27,6 @@ require (
 	github.com/klauspost/cpuid/v2 v2.0.11 // indirect
 	github.com/leodido/go-urn v1.2.1 // indirect
 	go.opentelemetry.io/contrib v0.17.0 // indirect
-	go.opentelemetry.io/otel v0.17.0 // indirect
 	go.opentelemetry.io/otel/metric v0.17.0 // indirect
 	gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
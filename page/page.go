@@ -0,0 +1,291 @@
+// Package page provides keyset (cursor) pagination of list endpoints.
+//
+// A page is of the rows after the sort key of the last row of the prior page,
+// per a keyset predicate, rather than of an OFFSET that the database must
+// scan through. The cursor of the next page is that sort key, signed (HMAC)
+// and opaque to the client.
+//
+//	ks := page.Keyset{Columns: []string{"created_at", "id"}, Desc: true}
+//
+//	req, err := pager.Parse(r) // ?limit=50&cursor=...
+//	if err != nil {
+//		return err // 400
+//	}
+//	q, args := `SELECT * FROM posts WHERE author_id = $1`, []interface{}{authorID}
+//	if req.After() {
+//		var at time.Time
+//		var id string
+//		if err := req.Scan(&at, &id); err != nil {
+//			return err // 400
+//		}
+//		q += ` AND ` + ks.Predicate(2)
+//		args = append(args, at, id)
+//	}
+//	q += ` ORDER BY ` + ks.OrderBy() + ` LIMIT ` + strconv.Itoa(req.Fetch())
+//	... db.SelectContext(ctx, &posts, q, args...)
+//
+//	pg, err := page.New(pager, req, posts, func(p Post) []interface{} { return []interface{}{p.CreatedAt, p.ID} })
+//	if err != nil {
+//		return err
+//	}
+//	page.SetLink(w, r, pg.Next)
+//	return web.Respond(ctx, w, pg, http.StatusOK)
+package page
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sempernow/kit/web"
+
+	"github.com/pkg/errors"
+)
+
+// ErrCursor is of a malformed or tampered cursor.
+var ErrCursor = errors.New("page : invalid cursor")
+
+// macSize is that of the truncated HMAC-SHA256 of a cursor.
+const macSize = 16
+
+// Config declares the parameters of a Pager; zero values select defaults.
+type Config struct {
+	// Key of the HMAC of cursors; at least 32 bytes. Required.
+	Key []byte
+	// DefaultLimit is the page size sans limit parameter (default 20).
+	DefaultLimit int
+	// MaxLimit bounds the limit parameter (default 100).
+	MaxLimit int
+}
+
+// Pager encodes, signs, and verifies cursors, and parses the paging parameters of requests.
+type Pager struct {
+	cfg Config
+}
+
+// NewPager returns a Pager per cfg.
+func NewPager(cfg Config) (*Pager, error) {
+	if len(cfg.Key) < 32 {
+		return nil, errors.New("page : key of at least 32 bytes required")
+	}
+	if cfg.DefaultLimit <= 0 {
+		cfg.DefaultLimit = 20
+	}
+	if cfg.MaxLimit <= 0 {
+		cfg.MaxLimit = 100
+	}
+	if cfg.DefaultLimit > cfg.MaxLimit {
+		cfg.DefaultLimit = cfg.MaxLimit
+	}
+	return &Pager{cfg: cfg}, nil
+}
+
+// Encode returns the signed cursor of the sort key of a row; its values are JSON encoded.
+func (p *Pager) Encode(key ...interface{}) (string, error) {
+	bb, err := json.Marshal(key)
+	if err != nil {
+		return "", errors.Wrap(err, "page : encoding cursor")
+	}
+	payload := base64.RawURLEncoding.EncodeToString(bb)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(p.sign(payload)), nil
+}
+
+// Decode verifies cursor, and decodes its sort key into dest, of as many (pointers) as its values.
+func (p *Pager) Decode(cursor string, dest ...interface{}) error {
+	vals, err := p.verify(cursor)
+	if err != nil {
+		return err
+	}
+	return scan(vals, dest)
+}
+
+func (p *Pager) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, p.cfg.Key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)[:macSize]
+}
+
+// verify returns the values of the sort key of cursor, else ErrCursor.
+func (p *Pager) verify(cursor string) ([]json.RawMessage, error) {
+	payload, sig, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return nil, ErrCursor
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, p.sign(payload)) {
+		return nil, ErrCursor
+	}
+	bb, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrCursor
+	}
+	var vals []json.RawMessage
+	if err := json.Unmarshal(bb, &vals); err != nil || len(vals) == 0 {
+		return nil, ErrCursor
+	}
+	return vals, nil
+}
+
+func scan(vals []json.RawMessage, dest []interface{}) error {
+	if len(vals) != len(dest) {
+		return errors.Wrapf(ErrCursor, "of %d values, not %d", len(vals), len(dest))
+	}
+	for i, v := range vals {
+		if err := json.Unmarshal(v, dest[i]); err != nil {
+			return errors.Wrapf(ErrCursor, "value %d : %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// Request is the paging parameters of a request.
+type Request struct {
+	// Limit is the page size, per the limit parameter, else the DefaultLimit.
+	Limit int
+	// Cursor as received; empty if of the first page.
+	Cursor string
+
+	vals []json.RawMessage
+}
+
+// Parse returns the paging parameters, limit and cursor, of the query of r.
+// Its errors are of web.NewRequestError(.., 400).
+func (p *Pager) Parse(r *http.Request) (Request, error) {
+	q := r.URL.Query()
+	req := Request{Limit: p.cfg.DefaultLimit, Cursor: q.Get("cursor")}
+
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > p.cfg.MaxLimit {
+			return Request{}, &web.Error{
+				Err:    errors.New("invalid paging parameters"),
+				Status: http.StatusBadRequest,
+				Fields: []web.FieldError{{Field: "limit", Error: fmt.Sprintf("must be 1 to %d", p.cfg.MaxLimit)}},
+			}
+		}
+		req.Limit = n
+	}
+	if req.Cursor != "" {
+		vals, err := p.verify(req.Cursor)
+		if err != nil {
+			return Request{}, web.NewRequestError(err, http.StatusBadRequest)
+		}
+		req.vals = vals
+	}
+	return req, nil
+}
+
+// After reports whether req is of a page after the first; of a cursor.
+func (req Request) After() bool { return req.vals != nil }
+
+// Scan decodes the sort key of the cursor into dest; error of web.NewRequestError(.., 400).
+func (req Request) Scan(dest ...interface{}) error {
+	if err := scan(req.vals, dest); err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+	return nil
+}
+
+// Fetch is the LIMIT of the query of the page; one more than Limit,
+// to learn of a next page sans a count.
+func (req Request) Fetch() int { return req.Limit + 1 }
+
+// Page is the response body of a page of items, and the cursor of the next, if any.
+type Page[T any] struct {
+	Items []T    `json:"items"`
+	Next  string `json:"next_cursor,omitempty"`
+}
+
+// New returns the Page of items fetched per req.Fetch(), trimmed to req.Limit,
+// and of the cursor of the sort key of its last item if more remain.
+// Items is never nil, so encodes as [] if empty.
+func New[T any](p *Pager, req Request, items []T, key func(T) []interface{}) (Page[T], error) {
+	pg := Page[T]{Items: items}
+	if pg.Items == nil {
+		pg.Items = []T{}
+	}
+	if len(items) <= req.Limit {
+		return pg, nil
+	}
+	pg.Items = items[:req.Limit]
+	next, err := p.Encode(key(pg.Items[req.Limit-1])...)
+	if err != nil {
+		return Page[T]{}, err
+	}
+	pg.Next = next
+	return pg, nil
+}
+
+// SetLink sets the Link header (RFC 8288) of the next page, if next (cursor) is not empty;
+// the URL is that of r, of its other query parameters, relative to its host.
+//
+//	Link: </posts?cursor=eyJ...&limit=50>; rel="next"
+func SetLink(w http.ResponseWriter, r *http.Request, next string) {
+	if next == "" {
+		return
+	}
+	q := r.URL.Query()
+	q.Set("cursor", next)
+	u := *r.URL
+	u.Scheme, u.Host, u.User, u.RawQuery = "", "", nil, q.Encode()
+	w.Header().Add("Link", "<"+u.String()+`>; rel="next"`)
+}
+
+// ----------------------------------------------------------------------------
+//  Keyset predicate of SQL
+
+var reColumn = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// Keyset is the sort key of rows, of columns that together are unique
+// (e.g., created_at, id), of one direction; its SQL is of row comparison,
+// which an index of the same columns serves.
+// Columns are of the code, not of the client; they are not quoted.
+type Keyset struct {
+	Columns []string
+	Desc    bool
+}
+
+// Predicate returns the keyset predicate of the rows after those of a cursor,
+// of placeholders from $first; it panics on an invalid column.
+//
+//	Keyset{Columns: []string{"created_at", "id"}}.Predicate(3)  // (created_at, id) > ($3, $4)
+func (k Keyset) Predicate(first int) string {
+	k.mustValid()
+	ph := make([]string, len(k.Columns))
+	for i := range ph {
+		ph[i] = "$" + strconv.Itoa(first+i)
+	}
+	op := ">"
+	if k.Desc {
+		op = "<"
+	}
+	return "(" + strings.Join(k.Columns, ", ") + ") " + op + " (" + strings.Join(ph, ", ") + ")"
+}
+
+// OrderBy returns the ORDER BY list of the keyset; it panics on an invalid column.
+//
+//	Keyset{Columns: []string{"created_at", "id"}, Desc: true}.OrderBy()  // created_at DESC, id DESC
+func (k Keyset) OrderBy() string {
+	k.mustValid()
+	if !k.Desc {
+		return strings.Join(k.Columns, ", ")
+	}
+	return strings.Join(k.Columns, " DESC, ") + " DESC"
+}
+
+func (k Keyset) mustValid() {
+	if len(k.Columns) == 0 {
+		panic("page : keyset of no columns")
+	}
+	for _, c := range k.Columns {
+		if !reColumn.MatchString(c) {
+			panic(fmt.Sprintf("page : invalid keyset column %q", c))
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package page_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sempernow/kit/page"
+	"github.com/sempernow/kit/testkit"
+	"github.com/sempernow/kit/web"
+
+	"github.com/pkg/errors"
+)
+
+var key = []byte("0123456789abcdef0123456789abcdef")
+
+func TestCursor(t *testing.T) {
+	p, err := page.NewPager(page.Config{Key: key})
+	testkit.Log(t, "NewPager", err)
+
+	at := time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC)
+	cursor, err := p.Encode(at, int64(1<<62), "b")
+	testkit.Log(t, "Encode", err)
+
+	var (
+		gotAt time.Time
+		gotID int64
+		gotS  string
+	)
+	testkit.Log(t, "Decode", p.Decode(cursor, &gotAt, &gotID, &gotS))
+	testkit.LogDiff(t, "Time", gotAt.Equal(at), true)
+	testkit.LogDiff(t, "Int64 sans loss", gotID, int64(1<<62))
+	testkit.LogDiff(t, "String", gotS, "b")
+
+	t.Log("@ Rejects")
+	{
+		payload, sig, _ := strings.Cut(cursor, ".")
+		forged, _ := p.Encode(at, int64(1), "b")
+		forgedPayload, _, _ := strings.Cut(forged, ".")
+		other, _ := page.NewPager(page.Config{Key: []byte("another key of at least 32 bytes!")})
+		foreign, _ := other.Encode(at, int64(1<<62), "b")
+
+		for name, c := range map[string]string{
+			"Tampered payload": forgedPayload + "." + sig,
+			"Tampered mac":     payload + "." + sig[:len(sig)-2] + "AA",
+			"Foreign key":      foreign,
+			"Sans mac":         payload,
+			"Garbage":          "not a cursor",
+		} {
+			err := p.Decode(c, &gotAt, &gotID, &gotS)
+			testkit.LogDiff(t, name, errors.Is(err, page.ErrCursor), true)
+		}
+		err := p.Decode(cursor, &gotAt, &gotID)
+		testkit.LogDiff(t, "Count of values", errors.Is(err, page.ErrCursor), true)
+	}
+
+	_, err = page.NewPager(page.Config{Key: []byte("short")})
+	testkit.LogDiff(t, "Short key", err != nil, true)
+}
+
+func TestKeyset(t *testing.T) {
+	ks := page.Keyset{Columns: []string{"created_at", "p.id"}}
+	testkit.LogDiff(t, "Predicate", ks.Predicate(3), "(created_at, p.id) > ($3, $4)")
+	testkit.LogDiff(t, "OrderBy", ks.OrderBy(), "created_at, p.id")
+
+	ks.Desc = true
+	testkit.LogDiff(t, "Predicate desc", ks.Predicate(1), "(created_at, p.id) < ($1, $2)")
+	testkit.LogDiff(t, "OrderBy desc", ks.OrderBy(), "created_at DESC, p.id DESC")
+
+	func() {
+		defer func() { testkit.LogDiff(t, "Invalid column panics", recover() != nil, true) }()
+		page.Keyset{Columns: []string{"id; DROP TABLE x"}}.Predicate(1)
+	}()
+}
+
+type item struct {
+	ID int `json:"id"`
+}
+
+func TestPage(t *testing.T) {
+	p, _ := page.NewPager(page.Config{Key: key, DefaultLimit: 2, MaxLimit: 3})
+	items := []item{{1}, {2}, {3}, {4}, {5}}
+	keyOf := func(it item) []interface{} { return []interface{}{it.ID} }
+
+	// list serves items, in pages, per keyset of ID.
+	list := func(w http.ResponseWriter, r *http.Request) {
+		req, err := p.Parse(r)
+		if err != nil {
+			web.RespondError(testkit.Context(), w, err)
+			return
+		}
+		after := 0
+		if req.After() {
+			if err := req.Scan(&after); err != nil {
+				web.RespondError(testkit.Context(), w, err)
+				return
+			}
+		}
+		var rows []item
+		for _, it := range items {
+			if it.ID > after && len(rows) < req.Fetch() {
+				rows = append(rows, it)
+			}
+		}
+		pg, err := page.New(p, req, rows, keyOf)
+		if err != nil {
+			web.RespondError(testkit.Context(), w, err)
+			return
+		}
+		page.SetLink(w, r, pg.Next)
+		json.NewEncoder(w).Encode(pg)
+	}
+
+	get := func(target string) (*httptest.ResponseRecorder, page.Page[item]) {
+		w := httptest.NewRecorder()
+		list(w, httptest.NewRequest("GET", target, nil))
+		var pg page.Page[item]
+		json.Unmarshal(w.Body.Bytes(), &pg)
+		return w, pg
+	}
+
+	t.Log("@ Walk")
+	{
+		var got []int
+		target, pages := "/items?q=x", 0
+		for target != "" {
+			w, pg := get(target)
+			testkit.LogDiff(t, "Status", w.Code, http.StatusOK)
+			for _, it := range pg.Items {
+				got = append(got, it.ID)
+			}
+			target = ""
+			if link := w.Header().Get("Link"); link != "" {
+				testkit.LogDiff(t, "Link rel next", strings.HasSuffix(link, `>; rel="next"`), true)
+				target = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+				testkit.LogDiff(t, "Link keeps query", strings.Contains(target, "q=x"), true)
+				testkit.LogDiff(t, "Link of next_cursor", strings.Contains(target, "cursor="+pg.Next), true)
+			}
+			pages++
+		}
+		testkit.LogCmp(t, "All items", got, []int{1, 2, 3, 4, 5})
+		testkit.LogDiff(t, "Pages of default limit", pages, 3)
+
+		w, pg := get("/items?limit=3")
+		testkit.LogDiff(t, "Limit", len(pg.Items), 3)
+		testkit.LogDiff(t, "Next cursor", pg.Next != "", true)
+
+		testkit.LogDiff(t, "Link", w.Header().Get("Link") != "", true)
+
+		w, pg = get("/items?limit=3&cursor=" + pg.Next)
+		testkit.LogDiff(t, "Last page sans next", pg.Next, "")
+		testkit.LogDiff(t, "Last page sans link", w.Header().Get("Link"), "")
+	}
+	t.Log("@ Bad requests")
+	{
+		for _, q := range []string{"limit=0", "limit=4", "limit=x", "cursor=forged.cursor"} {
+			w, _ := get("/items?" + q)
+			testkit.LogDiff(t, q, w.Code, http.StatusBadRequest)
+		}
+		cursor, _ := p.Encode("a", "b")
+		w, _ := get("/items?cursor=" + cursor)
+		testkit.LogDiff(t, "Cursor of another keyset", w.Code, http.StatusBadRequest)
+	}
+	t.Log("@ Empty")
+	{
+		pg, err := page.New(p, page.Request{Limit: 2}, []item(nil), keyOf)
+		testkit.Log(t, "New", err)
+		bb, _ := json.Marshal(pg)
+		testkit.LogDiff(t, "Items of []", string(bb), `{"items":[]}`)
+	}
+}
@@ -24,7 +24,7 @@ func Context() context.Context {
 		Now:     time.Now(),
 	}
 
-	return context.WithValue(context.Background(), web.Key1, &values)
+	return web.WithValues(context.Background(), &values)
 }
 
 // StringPointer is a helper to get a *string from a string. It is in the tests
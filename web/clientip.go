@@ -0,0 +1,170 @@
+package web
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ClientIP resolves the IP address of the client of a request
+// per the (reverse) proxies trusted to report it.
+// Headers are believed only if the peer (r.RemoteAddr) is a trusted proxy,
+// and then only as far as the chain of proxies remains trusted:
+// the forwarding chain is walked from the right (nearest hop),
+// and the first address not of a trusted proxy is the client.
+// The RFC 7239 Forwarded header is preferred over X-Forwarded-For,
+// which is preferred over X-Real-IP.
+type ClientIP struct {
+	trusted []*net.IPNet
+}
+
+// NewClientIP returns a ClientIP resolver trusting proxies of CIDRs (or bare IPs).
+//
+//	cip, err := web.NewClientIP("10.0.0.0/8", "172.16.0.0/12", "127.0.0.1")
+func NewClientIP(trusted ...string) (*ClientIP, error) {
+	c := ClientIP{}
+	for _, s := range trusted {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, errors.New("client ip : invalid trusted proxy : " + s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			c.trusted = append(c.trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, errors.Wrap(err, "client ip : invalid trusted proxy")
+		}
+		c.trusted = append(c.trusted, n)
+	}
+	return &c, nil
+}
+
+// Resolve returns the client IP of request (r), else empty if r.RemoteAddr is invalid.
+func (c *ClientIP) Resolve(r *http.Request) string {
+	remote := parseNode(r.RemoteAddr)
+	if remote == nil {
+		return ""
+	}
+	if !c.isTrusted(remote) {
+		return remote.String()
+	}
+
+	if hops, ok := forwardedFor(r.Header.Values("Forwarded")); ok {
+		return c.walk(remote, hops).String()
+	}
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		var hops []string
+		for _, v := range xff {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+		return c.walk(remote, hops).String()
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return remote.String()
+}
+
+// Get returns the client IP of the request, resolving it only once per request;
+// the result is kept at `Values.ClientIP` of the request context (ctx).
+func (c *ClientIP) Get(ctx context.Context, r *http.Request) string {
+	v, ok := ValuesFrom(ctx)
+	if !ok {
+		return c.Resolve(r)
+	}
+	if v.ClientIP == "" {
+		v.ClientIP = c.Resolve(r)
+	}
+	return v.ClientIP
+}
+
+// walk returns the rightmost hop not of a trusted proxy, from peer (remote) leftward.
+// An unparsable hop ends the walk at the (trusted) proxy that reported it.
+// If all are trusted, the leftmost hop is the client.
+func (c *ClientIP) walk(remote net.IP, hops []string) net.IP {
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseNode(hops[i])
+		if ip == nil {
+			return client
+		}
+		client = ip
+		if !c.isTrusted(ip) {
+			return ip
+		}
+	}
+	return client
+}
+
+func (c *ClientIP) isTrusted(ip net.IP) bool {
+	for _, n := range c.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the `for` parameters of all elements of Forwarded header values,
+// and whether any such header is present.
+// https://www.rfc-editor.org/rfc/rfc7239#section-4
+func forwardedFor(values []string) ([]string, bool) {
+	if len(values) == 0 {
+		return nil, false
+	}
+	var hops []string
+	for _, v := range values {
+		for _, elem := range splitQuoted(v, ',') {
+			node := "" //... an element sans `for` is an unknown hop.
+			for _, pair := range splitQuoted(elem, ';') {
+				k, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(k, "for") {
+					node = strings.Trim(val, `"`)
+				}
+			}
+			hops = append(hops, node)
+		}
+	}
+	return hops, true
+}
+
+// splitQuoted splits s at sep, except within double quotes.
+func splitQuoted(s string, sep rune) []string {
+	var (
+		parts  []string
+		quoted bool
+		start  int
+	)
+	for i, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseNode returns the IP of a node; "ip", "ip:port", "[ipv6]" or "[ipv6]:port".
+// Obfuscated identifiers and "unknown" are nil.
+func parseNode(s string) net.IP {
+	s = strings.TrimSpace(s)
+	if ip := net.ParseIP(s); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		return net.ParseIP(host)
+	}
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+}
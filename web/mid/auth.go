@@ -2,10 +2,16 @@ package mid
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"net/http"
+	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/sempernow/kit/auth"
+	"github.com/sempernow/kit/id"
 	"github.com/sempernow/kit/web"
 
 	"github.com/pkg/errors"
@@ -54,7 +60,11 @@ func ValidToken(a *auth.Auth, rRefKey string) web.Middleware {
 			// Cookie test is for Refresh token scheme; only upon expired Access token.
 			// The API is cookie-less if client utilizes only the shorter-lived Access token
 			// and if API does not use cookie-based (DoubleSubmitCookieMethod) CSRF mitigation.
-			claims, errTkn := a.ValidateToken(parts[1])
+			// Revoked tokens (per auth.WithRevocation) are invalid; an unreachable store is HTTP 500.
+			claims, errTkn := a.ValidateTokenContext(ctx, parts[1])
+			if errors.Cause(errTkn) == auth.ErrRevocationUnavailable {
+				return errTkn
+			}
 
 			if errTkn != nil {
 				// If token is invalid, then chk for Refresh token reference cookie;
@@ -84,7 +94,220 @@ func ValidToken(a *auth.Auth, rRefKey string) web.Middleware {
 			}
 
 			// ADD Access token claims TO CONTEXT for downstream (per request) retrieval.
-			ctx = context.WithValue(ctx, auth.Key1, claims)
+			ctx = auth.WithClaims(ctx, claims)
+
+			return after(ctx, w, r)
+		}
+
+		return h
+	}
+
+	return m
+}
+
+// OptionalToken is ValidToken(..) that also admits anonymous requests;
+// those sans `Authorization` header proceed with auth.Visitor() claims (RoleUsrVis).
+// A request bearing a token is validated as by ValidToken(..); an invalid token
+// is not downgraded to a visitor. Handlers distinguish per Claims.IsVisitor().
+//
+//	svc.Handle("GET", "/v1/posts", h.List, mid.OptionalToken(a, auth.KeyRefRefresh))
+func OptionalToken(a *auth.Auth, rRefKey string) web.Middleware {
+	m := func(after web.Handler) web.Handler {
+		authenticated := ValidToken(a, rRefKey)(after)
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			if r.Header.Get("Authorization") != "" {
+				return authenticated(ctx, w, r)
+			}
+			return after(auth.WithClaims(ctx, auth.Visitor()), w, r)
+		}
+
+		return h
+	}
+
+	return m
+}
+
+// APIKey authenticates a machine client per its API key (see auth.APIKeys),
+// of request header `X-API-Key: <KEY>` or `Authorization: Bearer <KEY>`.
+// Claims of the key are added to context as are those of ValidToken(..),
+// so ValidRoles(..) and Require(..) apply alike. Responds HTTP 401 if invalid.
+//
+//	svc.Handle("GET", "/v1/reports", h.List, mid.APIKey(keys), mid.ValidRoles(auth.RoleSvcAPI))
+func APIKey(keys *auth.APIKeys) web.Middleware {
+	m := func(after web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "kit.mid.apikey")
+			defer span.End()
+
+			key := r.Header.Get("X-API-Key")
+			if key == "" {
+				scheme, tkn, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+				if ok && strings.EqualFold(scheme, "bearer") && keys.Is(tkn) {
+					key = tkn
+				}
+			}
+			if key == "" {
+				err := errors.New("expected header format: X-API-Key KEY or Authorization: Bearer KEY")
+				return web.NewRequestError(err, http.StatusUnauthorized)
+			}
+
+			claims, err := keys.Authenticate(ctx, key)
+			if err != nil {
+				if errors.Cause(err) == auth.ErrAPIKeyInvalid {
+					return web.NewRequestError(err, http.StatusUnauthorized)
+				}
+				return err //... store failure; HTTP 500.
+			}
+
+			// ADD API key claims TO CONTEXT for downstream (per request) retrieval.
+			ctx = auth.WithClaims(ctx, claims)
+
+			return after(ctx, w, r)
+		}
+
+		return h
+	}
+
+	return m
+}
+
+// Basic authenticates a user per RFC 7617 Basic scheme (`Authorization: Basic <b64(user:pass)>`),
+// against the credentials of pw. Claims are added to context as are those of ValidToken(..).
+// If invalid, responds HTTP 401 with a Basic challenge of realm.
+// Use only over TLS; the password is sent per request.
+//
+// Each verification is of the full cost of the password hash (PBKDF2; hundreds of
+// ms of CPU at auth.DefaultPasswordIterations), so successful ones are cached for
+// BasicCacheTTL, per user and password, and verifications are limited to as
+// many at once as there are CPUs; the rest await their turn. A password changed
+// (or a user removed) remains valid, per this cache, until its entry expires.
+func Basic(pw *auth.Passwords, realm string) web.Middleware {
+	challenge := `Basic realm="` + realm + `", charset="UTF-8"`
+	verified := newBasicCache()
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+
+	m := func(after web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "kit.mid.basic")
+			defer span.End()
+
+			user, pass, ok := r.BasicAuth()
+			if !ok {
+				w.Header().Set("WWW-Authenticate", challenge)
+				err := errors.New("expected authorization header format: Basic CREDENTIALS")
+				return web.NewRequestError(err, http.StatusUnauthorized)
+			}
+
+			claims, ok := verified.get(user, pass)
+			if !ok {
+				select {
+				case sem <- struct{}{}:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				var err error
+				claims, err = pw.Authenticate(ctx, user, pass)
+				<-sem
+				if err != nil {
+					if errors.Cause(err) == auth.ErrCredentials {
+						w.Header().Set("WWW-Authenticate", challenge)
+						return web.NewRequestError(err, http.StatusUnauthorized)
+					}
+					return err //... store failure; HTTP 500.
+				}
+				verified.put(user, pass, claims)
+			}
+
+			ctx = auth.WithClaims(ctx, claims)
+
+			return after(ctx, w, r)
+		}
+
+		return h
+	}
+
+	return m
+}
+
+// BasicCacheTTL is the lifetime of a successful verification of Basic(..).
+const BasicCacheTTL = time.Minute
+
+// basicCache holds the claims of successful Basic verifications, per a keyed
+// digest of user and password; expired entries are pruned at most once a minute.
+type basicCache struct {
+	key     []byte
+	mu      sync.Mutex
+	entries map[string]basicEntry
+	pruned  time.Time
+}
+
+type basicEntry struct {
+	claims  auth.Claims
+	expires time.Time
+}
+
+func newBasicCache() *basicCache {
+	key, err := id.Nonce(32)
+	if err != nil {
+		panic("basic : cache key : " + err.Error())
+	}
+	return &basicCache{key: key, entries: make(map[string]basicEntry)}
+}
+
+func (c *basicCache) digest(user, pass string) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(user + "\x00" + pass))
+	return string(mac.Sum(nil))
+}
+
+func (c *basicCache) get(user, pass string) (auth.Claims, bool) {
+	k := c.digest(user, pass)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[k]
+	if !ok || time.Now().After(e.expires) {
+		return auth.Claims{}, false
+	}
+	return e.claims, true
+}
+
+func (c *basicCache) put(user, pass string, claims auth.Claims) {
+	k := c.digest(user, pass)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if now.Sub(c.pruned) > time.Minute {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.pruned = now
+	}
+	c.entries[k] = basicEntry{claims: claims, expires: now.Add(BasicCacheTTL)}
+}
+
+// Digest authenticates a user per RFC 7616 Digest scheme (SHA-256, qop auth); see auth.Digest.
+// Claims are added to context as are those of ValidToken(..).
+// If invalid, responds HTTP 401 with a Digest challenge; stale=true if per an expired nonce.
+func Digest(d *auth.Digest) web.Middleware {
+	m := func(after web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "kit.mid.digest")
+			defer span.End()
+
+			claims, err := d.Authenticate(ctx, r)
+			if err != nil {
+				switch errors.Cause(err) {
+				case auth.ErrCredentials, auth.ErrDigestStale:
+					w.Header().Set("WWW-Authenticate", d.Challenge(errors.Cause(err) == auth.ErrDigestStale))
+					return web.NewRequestError(err, http.StatusUnauthorized)
+				}
+				return err //... store failure; HTTP 500.
+			}
+
+			ctx = auth.WithClaims(ctx, claims)
 
 			return after(ctx, w, r)
 		}
@@ -103,7 +326,7 @@ func ValidRoles(roles ...string) web.Middleware {
 			ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "kit.mid.authorize")
 			defer span.End()
 
-			claims, ok := ctx.Value(auth.Key1).(auth.Claims)
+			claims, ok := auth.FromContext(ctx)
 			if !ok {
 				return errors.New("context : missing claims: Authorize called without/before Authenticate")
 			}
@@ -120,3 +343,52 @@ func ValidRoles(roles ...string) web.Middleware {
 
 	return m
 }
+
+// Require validates that an AUTHENTICATED USER is allowed all permissions (perms)
+// upon resource, per policy (see auth.Policy); roles grant, token scopes narrow.
+// Resource is a template of route params; "group:{gid}" of route "/groups/:gid".
+// Empty resource admits only unscoped roles. If any is missing, responds HTTP 403
+// listing each missing permission as a field of the error response.
+//
+//	svc.Handle("DELETE", "/groups/:gid/posts/:pid", h.Delete, mid.ValidToken(a, auth.KeyRefRefresh),
+//		mid.Require(policy, "group:{gid}", "posts:delete"))
+func Require(policy *auth.Policy, resource string, perms ...string) web.Middleware {
+	m := func(after web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "kit.mid.require")
+			defer span.End()
+
+			claims, ok := auth.FromContext(ctx)
+			if !ok {
+				return errors.New("context : missing claims: Require called without/before Authenticate")
+			}
+
+			res := resource
+			for k, v := range web.Params(r) {
+				res = strings.ReplaceAll(res, "{"+k+"}", v)
+			}
+			missing := policy.Missing(claims, res, perms...)
+			if len(missing) == 0 {
+				return after(ctx, w, r)
+			}
+
+			detail := "permission required"
+			if res != "" {
+				detail += " upon " + res
+			}
+			fields := make([]web.FieldError, len(missing))
+			for i, perm := range missing {
+				fields[i] = web.FieldError{Field: perm, Error: detail}
+			}
+			return &web.Error{
+				Err:    errors.New("claimant not authorized for that action : lacks required permission"),
+				Status: http.StatusForbidden,
+				Fields: fields,
+			}
+		}
+
+		return h
+	}
+
+	return m
+}
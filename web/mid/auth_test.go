@@ -0,0 +1,263 @@
+package mid_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sempernow/kit/auth"
+	"github.com/sempernow/kit/testkit"
+	"github.com/sempernow/kit/web"
+	"github.com/sempernow/kit/web/mid"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/pkg/errors"
+)
+
+func TestValidToken(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	store := auth.NewMemoryRevocationStore()
+	a, err := auth.New(key, "k1", "EdDSA", auth.JWKS("k1", key.Public()), auth.WithRevocation(store))
+	testkit.Log(t, "New authenticator", err)
+
+	claims := auth.Claims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        "jti-1",
+			Subject:   "0x01",
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			IssuedAt:  auth.IssuedAtNow(),
+		},
+		TokenType: auth.Access,
+	}
+	tkn, err := a.GenerateToken(claims)
+	testkit.Log(t, "Generate token", err)
+
+	h := mid.ValidToken(a, auth.KeyRefRefresh)(
+		func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		},
+	)
+	serve := func() error {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer "+tkn)
+		return h(testkit.Context(), httptest.NewRecorder(), r)
+	}
+	status := func(err error) int {
+		if webErr, ok := errors.Cause(err).(*web.Error); ok {
+			return webErr.Status
+		}
+		return 0
+	}
+
+	testkit.Log(t, "Valid token", serve())
+
+	testkit.Log(t, "Revoke token", store.RevokeToken(context.Background(), "jti-1", time.Now().Add(time.Hour)))
+	testkit.LogDiff(t, "Revoked token is HTTP 401", status(serve()), http.StatusUnauthorized)
+}
+
+func TestRequire(t *testing.T) {
+	p, err := auth.NewPolicy(auth.PolicyConfig{Roles: map[string][]string{
+		auth.RoleUsrMbr: {"posts:read"},
+		auth.RoleGrpMod: {"posts:*"},
+	}})
+	testkit.Log(t, "New policy", err)
+
+	claims := auth.Claims{Roles: []string{auth.RoleUsrMbr, auth.ScopedRole(auth.RoleGrpMod, "group:42")}}
+	var authenticated web.Middleware = func(next web.Handler) web.Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			return next(auth.WithClaims(ctx, claims), w, r)
+		}
+	}
+	app := web.NewApp(make(chan os.Signal, 1), mid.Errors(log.New(io.Discard, "", 0)))
+	app.Handle("DELETE", "/groups/:gid/posts/:pid",
+		func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		},
+		authenticated, mid.Require(p, "group:{gid}", "posts:read", "posts:delete"),
+	)
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("DELETE", "/groups/42/posts/1", nil))
+	testkit.LogDiff(t, "Moderator of group 42", w.Code, http.StatusNoContent)
+
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("DELETE", "/groups/7/posts/1", nil))
+	testkit.LogDiff(t, "Not moderator of group 7", w.Code, http.StatusForbidden)
+
+	var resp web.ErrorResponse
+	testkit.Log(t, "Decode error response", json.Unmarshal(w.Body.Bytes(), &resp))
+	testkit.LogDiff(t, "Missing permission", len(resp.Fields) == 1 && resp.Fields[0].Field == "posts:delete", true)
+	testkit.LogDiff(t, "Detail", resp.Fields[0].Error, "permission required upon group:7")
+}
+
+func TestAPIKey(t *testing.T) {
+	store := auth.NewMemoryAPIKeyStore()
+	keys, err := auth.NewAPIKeys("sk", store)
+	testkit.Log(t, "New API keys", err)
+	key, rec, err := keys.Generate(auth.APIKey{Subject: "svc-reports", Roles: []string{auth.RoleSvcAPI}})
+	testkit.Log(t, "Generate", err)
+	store.Put(rec)
+
+	app := web.NewApp(make(chan os.Signal, 1), mid.Errors(log.New(io.Discard, "", 0)))
+	app.Handle("GET", "/reports",
+		func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		},
+		mid.APIKey(keys), mid.ValidRoles(auth.RoleSvcAPI),
+	)
+	serve := func(header, val string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/reports", nil)
+		if header != "" {
+			r.Header.Set(header, val)
+		}
+		app.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	testkit.LogDiff(t, "X-API-Key", serve("X-API-Key", key), http.StatusNoContent)
+	testkit.LogDiff(t, "Bearer", serve("Authorization", "Bearer "+key), http.StatusNoContent)
+	testkit.LogDiff(t, "Invalid key", serve("X-API-Key", key+"X"), http.StatusUnauthorized)
+	testkit.LogDiff(t, "No key", serve("", ""), http.StatusUnauthorized)
+}
+
+func TestBasicDigest(t *testing.T) {
+	store := auth.NewMemoryCredentialStore()
+	pw, err := auth.NewPasswords(store, auth.PasswordConfig{Iterations: 1000})
+	testkit.Log(t, "New passwords", err)
+	h, _ := pw.Hash("s3cr3t")
+	store.Put("foo", auth.Credential{
+		Subject:   "0x01",
+		Roles:     []string{auth.RoleUsrMbr},
+		Hash:      h,
+		DigestHA1: auth.DigestHA1("foo", "kit", "s3cr3t"),
+	})
+	d, err := auth.NewDigest(pw, "kit")
+	testkit.Log(t, "New digest", err)
+
+	ok := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	app := web.NewApp(make(chan os.Signal, 1), mid.Errors(log.New(io.Discard, "", 0)))
+	app.Handle("GET", "/basic", ok, mid.Basic(pw, "kit"), mid.ValidRoles(auth.RoleUsrMbr))
+	app.Handle("GET", "/digest", ok, mid.Digest(d), mid.ValidRoles(auth.RoleUsrMbr))
+	serve := func(path string, set func(r *http.Request)) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		set(r)
+		app.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Log("@ Basic")
+	{
+		w := serve("/basic", func(r *http.Request) {})
+		testkit.LogDiff(t, "Challenge", w.Header().Get("WWW-Authenticate"), `Basic realm="kit", charset="UTF-8"`)
+		w = serve("/basic", func(r *http.Request) { r.SetBasicAuth("foo", "s3cr3t") })
+		testkit.LogDiff(t, "Valid", w.Code, http.StatusNoContent)
+		w = serve("/basic", func(r *http.Request) { r.SetBasicAuth("foo", "wrong") })
+		testkit.LogDiff(t, "Invalid", w.Code, http.StatusUnauthorized)
+
+		h2, _ := pw.Hash("changed")
+		store.Put("foo", auth.Credential{Subject: "0x01", Roles: []string{auth.RoleUsrMbr}, Hash: h2})
+		w = serve("/basic", func(r *http.Request) { r.SetBasicAuth("foo", "s3cr3t") })
+		testkit.LogDiff(t, "Verification cached", w.Code, http.StatusNoContent)
+		w = serve("/basic", func(r *http.Request) { r.SetBasicAuth("foo", "wrong") })
+		testkit.LogDiff(t, "Failure not cached", w.Code, http.StatusUnauthorized)
+		store.Put("foo", auth.Credential{
+			Subject:   "0x01",
+			Roles:     []string{auth.RoleUsrMbr},
+			Hash:      h,
+			DigestHA1: auth.DigestHA1("foo", "kit", "s3cr3t"),
+		})
+	}
+	t.Log("@ Digest")
+	{
+		w := serve("/digest", func(r *http.Request) {})
+		testkit.LogDiff(t, "Unauthorized", w.Code, http.StatusUnauthorized)
+		scheme, params, _ := strings.Cut(w.Header().Get("WWW-Authenticate"), " ")
+		testkit.LogDiff(t, "Challenge", scheme, "Digest")
+		c := auth.ParseAuthParams(params)
+
+		authorize := func(user, pass, nc string) func(r *http.Request) {
+			return func(r *http.Request) {
+				sum := func(s string) string {
+					bb := sha256.Sum256([]byte(s))
+					return hex.EncodeToString(bb[:])
+				}
+				ha1 := sum(user + ":" + c["realm"] + ":" + pass)
+				ha2 := sum("GET:/digest")
+				resp := sum(ha1 + ":" + c["nonce"] + ":" + nc + ":abc:auth:" + ha2)
+				r.Header.Set("Authorization", `Digest username="`+user+`", realm="`+c["realm"]+`", nonce="`+c["nonce"]+
+					`", uri="/digest", algorithm=SHA-256, qop=auth, nc=`+nc+`, cnonce="abc", response="`+resp+
+					`", opaque="`+c["opaque"]+`"`)
+			}
+		}
+		w = serve("/digest", authorize("foo", "s3cr3t", "00000001"))
+		testkit.LogDiff(t, "Valid", w.Code, http.StatusNoContent)
+		w = serve("/digest", authorize("foo", "s3cr3t", "00000001"))
+		testkit.LogDiff(t, "Replay rejected", w.Code, http.StatusUnauthorized)
+		w = serve("/digest", authorize("foo", "s3cr3t", "00000002"))
+		testkit.LogDiff(t, "Next nonce count", w.Code, http.StatusNoContent)
+		w = serve("/digest", authorize("foo", "s3cr3t", "00000002"))
+		testkit.LogDiff(t, "Replay of next rejected", w.Code, http.StatusUnauthorized)
+		w = serve("/digest", authorize("bar", "s3cr3t", "00000003"))
+		testkit.LogDiff(t, "Unknown user", w.Code, http.StatusUnauthorized)
+		w = serve("/digest", authorize("foo", "wrong", "00000004"))
+		testkit.LogDiff(t, "Invalid", w.Code, http.StatusUnauthorized)
+		testkit.LogDiff(t, "Challenged anew", strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Digest "), true)
+	}
+}
+
+func TestOptionalToken(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	a, err := auth.New(key, "k1", "EdDSA", auth.JWKS("k1", key.Public()))
+	testkit.Log(t, "New authenticator", err)
+	tkn, err := a.GenerateToken(auth.Claims{
+		StandardClaims: jwt.StandardClaims{Subject: "0x01", ExpiresAt: time.Now().Add(time.Hour).Unix()},
+		Roles:          []string{auth.RoleUsrMbr},
+		TokenType:      auth.Access,
+	})
+	testkit.Log(t, "Generate token", err)
+
+	var got auth.Claims
+	h := mid.OptionalToken(a, auth.KeyRefRefresh)(
+		func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			got, _ = auth.FromContext(ctx)
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		},
+	)
+	serve := func(authorization string) error {
+		r := httptest.NewRequest("GET", "/", nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		return h(testkit.Context(), httptest.NewRecorder(), r)
+	}
+
+	testkit.Log(t, "Anonymous", serve(""))
+	testkit.LogDiff(t, "Visitor", got.IsVisitor(), true)
+
+	testkit.Log(t, "Authenticated", serve("Bearer "+tkn))
+	testkit.LogDiff(t, "Member", got.Subject == "0x01" && !got.IsVisitor(), true)
+
+	err = serve("Bearer invalid")
+	webErr, ok := errors.Cause(err).(*web.Error)
+	testkit.LogDiff(t, "Invalid token not downgraded", ok && webErr.Status == http.StatusUnauthorized, true)
+}
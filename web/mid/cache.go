@@ -0,0 +1,445 @@
+package mid
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sempernow/kit/auth"
+	"github.com/sempernow/kit/gz"
+	"github.com/sempernow/kit/web"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ****************************************************************************
+// Server-side response cache. Per web.NewResponse, we want the server to cache
+// while the browser is told `no-store`; that job was left to Nginx, which lacks
+// the per-route logic of our app (content negotiation, auth, purge on write).
+// Responses are stored whole (status, headers, body and its gzip variant)
+// exactly as the handler chain wrote them, so the client sees the same headers
+// on HIT as on MISS; `Cache-Control: no-store` included.
+// ****************************************************************************
+
+// CacheRoute is the caching policy of a route; see Cache(..).
+type CacheRoute struct {
+	TTL   time.Duration // Fresh lifetime of a stored response.
+	Stale time.Duration // Window past TTL wherein stale is served while revalidating.
+	Vary  []string      // Request headers by which stored responses vary.
+	Tags  []string      // Purge groups; see ResponseCache.PurgeTag(..).
+	Auth  bool          // Admit authenticated requests; stored responses are then per principal.
+}
+
+// ResponseCache is the in-process store of Cache(..) middleware; safe for concurrent use.
+// Entries are evicted oldest first once its capacity is reached.
+type ResponseCache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string]*cacheEntry
+	order   *list.List // Of keys, oldest first.
+	tags    map[string]map[string]struct{}
+}
+
+type cacheEntry struct {
+	key        string
+	status     int
+	header     http.Header
+	body       []byte // Identity encoded
+	gz         []byte // Gzip encoded; nil if not compressible
+	tags       []string
+	stored     time.Time
+	ttl        time.Duration
+	stale      time.Duration
+	refreshing bool
+	elem       *list.Element // Of ResponseCache.order.
+}
+
+// cacheMinGzip is the body size (bytes) under which no gzip variant is stored.
+const cacheMinGzip = 1024
+
+// NewResponseCache returns a ResponseCache of capacity max entries; unlimited if max < 1.
+func NewResponseCache(max int) *ResponseCache {
+	return &ResponseCache{
+		max:     max,
+		entries: make(map[string]*cacheEntry),
+		order:   list.New(),
+		tags:    make(map[string]map[string]struct{}),
+	}
+}
+
+// CacheKey returns the key of a request per method, path, query and its vary headers;
+// the key by which Cache(..) stores the response, and by which Purge(..) removes it.
+//
+//	Format: GET /foo/bar?a=1&b=2 accept=text/html
+func CacheKey(r *http.Request, vary ...string) string {
+	var b strings.Builder
+	b.WriteString(r.Method + " " + r.URL.Path)
+	if q := r.URL.Query(); len(q) > 0 {
+		b.WriteString("?" + q.Encode()) //... sorted by key.
+	}
+	vv := make([]string, len(vary))
+	for i, h := range vary {
+		vv[i] = strings.ToLower(h)
+	}
+	sort.Strings(vv)
+	for _, h := range vv {
+		b.WriteString(" " + h + "=" + strings.Join(r.Header.Values(h), ","))
+	}
+	return b.String()
+}
+
+// cacheKey returns the CacheKey(..) of r per route; per principal if authenticated,
+// so that the stored response of one is never served to another.
+func cacheKey(r *http.Request, route CacheRoute) string {
+	key := CacheKey(r, route.Vary...)
+	if p := principal(r); p != "" {
+		key += " principal=" + p
+	}
+	return key
+}
+
+// Purge removes the entries of keys.
+func (c *ResponseCache) Purge(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range keys {
+		c.remove(k)
+	}
+}
+
+// PurgeTag removes all entries of any of tags.
+func (c *ResponseCache) PurgeTag(tags ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range tags {
+		for k := range c.tags[t] {
+			c.remove(k)
+		}
+		delete(c.tags, t)
+	}
+}
+
+// Len returns the number of entries.
+func (c *ResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// remove deletes entry of key and its tag references. Caller holds the lock.
+func (c *ResponseCache) remove(key string) {
+	e, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	for _, t := range e.tags {
+		delete(c.tags[t], key)
+		if len(c.tags[t]) == 0 {
+			delete(c.tags, t)
+		}
+	}
+	c.order.Remove(e.elem)
+	delete(c.entries, key)
+}
+
+// get returns the entry of key, and whether it is fresh, stale (servable) or expired (nil).
+func (c *ResponseCache) get(key string, now time.Time) (e *cacheEntry, fresh bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	age := now.Sub(e.stored)
+	switch {
+	case age < e.ttl:
+		return e, true
+	case age < e.ttl+e.stale:
+		return e, false
+	}
+	c.remove(key)
+	return nil, false
+}
+
+// claim marks a stale entry as refreshing; returns false if already so.
+func (c *ResponseCache) claim(e *cacheEntry) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e.refreshing {
+		return false
+	}
+	e.refreshing = true
+	return true
+}
+
+// release clears the refreshing mark of an entry whose revalidation failed.
+func (c *ResponseCache) release(e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e.refreshing = false
+}
+
+// set stores the entry, evicting the oldest if at capacity.
+func (c *ResponseCache) set(e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(e.key)
+	if c.max > 0 && len(c.entries) >= c.max {
+		c.remove(c.order.Front().Value.(string))
+	}
+	e.elem = c.order.PushBack(e.key)
+	c.entries[e.key] = e
+	for _, t := range e.tags {
+		if c.tags[t] == nil {
+			c.tags[t] = make(map[string]struct{})
+		}
+		c.tags[t][e.key] = struct{}{}
+	}
+}
+
+// Cache serves GET and HEAD responses of a route from the store (rc) per route policy.
+// A stale entry is served while a single background request revalidates it.
+// Authenticated requests (Authorization header or token-reference cookie)
+// bypass the cache unless the route opts in (`CacheRoute.Auth`), whereof stored
+// responses are per principal (credentials), and so purged only per tag.
+// Responses that set cookies, or that vary (`Vary`) per request headers other than
+// those of the route (and Accept-Encoding), are never stored.
+//
+//	rc := mid.NewResponseCache(1000)
+//	svc.Handle("GET", "/pub/:slug", h.Page, mid.Cache(rc, mid.CacheRoute{
+//		TTL: time.Minute, Stale: time.Hour, Vary: []string{"Accept"}, Tags: []string{"pages"},
+//	}))
+func Cache(rc *ResponseCache, route CacheRoute) web.Middleware {
+	m := func(after web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "kit.mid.cache")
+			defer span.End()
+
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				return after(ctx, w, r)
+			}
+			if !route.Auth && authenticated(r) {
+				return after(ctx, w, r)
+			}
+
+			v, ok := web.ValuesFrom(ctx)
+			if !ok {
+				return web.NewShutdownError("context : missing web values")
+			}
+
+			key := cacheKey(r, route)
+			now := time.Now()
+
+			if e, fresh := rc.get(key, now); e != nil {
+				state := "HIT"
+				if !fresh {
+					state = "STALE"
+					if rc.claim(e) {
+						vv := *v
+						vv.Now = now.UTC()
+						ctx := web.WithValues(web.Detach(ctx), &vv)
+						go revalidate(ctx, rc, e, route, after, r.Clone(ctx))
+					}
+				}
+				v.StatusCode = e.status
+				return e.write(w, r, state, now)
+			}
+
+			cw := &cacheWriter{ResponseWriter: w}
+			if err := after(ctx, cw, r); err != nil {
+				return err
+			}
+			if e := cw.entry(key, route); e != nil {
+				rc.set(e)
+			}
+			return nil
+		}
+		return h
+	}
+	return m
+}
+
+// revalidate reruns the handler chain (next) for a stale entry (e),
+// per a clone of the request that found it stale, and replaces that entry.
+// The context (ctx) is detached from that request, and has its own web.Values.
+func revalidate(ctx context.Context, rc *ResponseCache, e *cacheEntry, route CacheRoute, next web.Handler, r *http.Request) {
+	ctx, cancel := context.WithTimeout(ctx, web.RespTimeMax*time.Millisecond)
+	defer cancel()
+
+	cw := &cacheWriter{ResponseWriter: newDiscardWriter()}
+	if err := next(ctx, cw, r.WithContext(ctx)); err != nil {
+		rc.release(e)
+		return
+	}
+	if x := cw.entry(e.key, route); x != nil {
+		rc.set(x)
+		return
+	}
+	rc.Purge(e.key) //... no longer storable.
+}
+
+// write sends the stored response, choosing its gzip variant if accepted.
+func (e *cacheEntry) write(w http.ResponseWriter, r *http.Request, state string, now time.Time) error {
+	hdr := w.Header()
+	for k, vv := range e.header {
+		hdr[k] = append([]string(nil), vv...)
+	}
+	body := e.body
+	if e.gz != nil {
+		addVary(hdr, "Accept-Encoding")
+		if qvalue(r.Header.Get("Accept-Encoding"), "gzip") > 0 {
+			body = e.gz
+			hdr.Set("Content-Encoding", "gzip")
+		}
+	}
+	if len(body) > 0 {
+		hdr.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	hdr.Set("Age", strconv.Itoa(int(now.Sub(e.stored).Seconds())))
+	hdr.Set("X-Cache", state)
+	w.WriteHeader(e.status)
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+// authenticated reports whether request bears credentials of a user.
+func authenticated(r *http.Request) bool {
+	return principal(r) != ""
+}
+
+// principal returns a digest of the credentials of r (Authorization header and
+// token-reference cookies); empty if none.
+func principal(r *http.Request) string {
+	h := sha256.New()
+	var any bool
+	if a := r.Header.Get("Authorization"); a != "" {
+		h.Write([]byte(a))
+		any = true
+	}
+	for _, k := range []string{auth.KeyRefAccess, auth.KeyRefRefresh} {
+		if c, err := r.Cookie(k); err == nil {
+			h.Write([]byte("\x00" + k + "=" + c.Value))
+			any = true
+		}
+	}
+	if !any {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// varies reports whether the response (of header hdr) varies per a request header
+// not keyed by route; Accept-Encoding is of the stored gzip variant, and
+// credentials are of the principal.
+func varies(hdr http.Header, route CacheRoute) bool {
+	keyed := map[string]bool{"accept-encoding": true}
+	for _, h := range route.Vary {
+		keyed[strings.ToLower(h)] = true
+	}
+	if route.Auth {
+		keyed["authorization"], keyed["cookie"] = true, true
+	}
+	for _, v := range hdr.Values("Vary") {
+		for _, h := range strings.Split(v, ",") {
+			if h = strings.ToLower(strings.TrimSpace(h)); h != "" && !keyed[h] {
+				return true //... "*" included.
+			}
+		}
+	}
+	return false
+}
+
+// cacheable statuses; those heuristically cacheable per RFC 9110 Section 15.1.
+var cacheable = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// cacheWriter tees the response to its ResponseWriter and a buffer.
+type cacheWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *cacheWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.buf.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// entry returns the storable entry of the recorded response, else nil.
+func (w *cacheWriter) entry(key string, route CacheRoute) *cacheEntry {
+	if !cacheable[w.status] {
+		return nil
+	}
+	src := w.Header()
+	if len(src.Values("Set-Cookie")) > 0 || varies(src, route) {
+		return nil
+	}
+	hdr := src.Clone()
+	hdr.Del("Content-Length")
+	hdr.Del("Content-Encoding")
+	hdr.Del("Date")
+
+	e := &cacheEntry{
+		key:    key,
+		status: w.status,
+		header: hdr,
+		body:   w.buf.Bytes(),
+		tags:   route.Tags,
+		stored: time.Now(),
+		ttl:    route.TTL,
+		stale:  route.Stale,
+	}
+	switch src.Get("Content-Encoding") {
+	case "", "identity":
+		if len(e.body) >= cacheMinGzip && allowType(CompressTypes, hdr.Get("Content-Type")) {
+			if bb, err := gz.Write(e.body); err == nil {
+				e.gz = bb
+			}
+		}
+	case "gzip":
+		bb, err := gz.Read(e.body)
+		if err != nil {
+			return nil
+		}
+		e.gz, e.body = e.body, bb
+	default:
+		return nil //... some other encoding; not ours to vary.
+	}
+	return e
+}
+
+// discardWriter is a ResponseWriter that keeps only its headers.
+type discardWriter struct {
+	header http.Header
+}
+
+func newDiscardWriter() *discardWriter {
+	return &discardWriter{header: make(http.Header)}
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(int)             {}
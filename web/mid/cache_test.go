@@ -0,0 +1,159 @@
+package mid_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sempernow/kit/testkit"
+	"github.com/sempernow/kit/web"
+	"github.com/sempernow/kit/web/mid"
+)
+
+func TestCache(t *testing.T) {
+	var calls int32
+	page := strings.Repeat("<p>lorem ipsum</p>", 100)
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		atomic.AddInt32(&calls, 1)
+		return web.Respond(ctx, w, &web.Resource{Content: []byte(page), Ctype: web.HTML}, http.StatusOK)
+	}
+	rc := mid.NewResponseCache(10)
+	h := mid.Cache(rc, mid.CacheRoute{
+		TTL:  time.Minute,
+		Vary: []string{"Accept"},
+		Tags: []string{"pages"},
+	})(handler)
+
+	get := func(hdr ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/pub/foo?b=2&a=1", nil)
+		for i := 0; i+1 < len(hdr); i += 2 {
+			r.Header.Set(hdr[i], hdr[i+1])
+		}
+		w := httptest.NewRecorder()
+		err := h(testkit.Context(), w, r)
+		testkit.Log(t, "Serve request", err)
+		return w
+	}
+
+	t.Log("@ Store on miss, serve on hit")
+	{
+		w := get()
+		testkit.LogDiff(t, "First request is a miss", w.Header().Get("X-Cache"), "")
+		w = get()
+		testkit.LogDiff(t, "Second request is a hit", w.Header().Get("X-Cache"), "HIT")
+		testkit.LogDiff(t, "Hit has body", w.Body.String(), page)
+		testkit.LogDiff(t, "Hit sans Content-Encoding", w.Header().Get("Content-Encoding"), "")
+		testkit.LogDiff(t, "Handler called once", atomic.LoadInt32(&calls), int32(1))
+	}
+	t.Log("@ Gzip variant")
+	{
+		w := get("Accept-Encoding", "gzip")
+		testkit.LogDiff(t, "Hit is gzip encoded", w.Header().Get("Content-Encoding"), "gzip")
+		testkit.LogDiff(t, "Hit is shorter", w.Body.Len() < len(page), true)
+	}
+	t.Log("@ Vary")
+	{
+		get("Accept", "application/json")
+		testkit.LogDiff(t, "Other Accept is a miss", atomic.LoadInt32(&calls), int32(2))
+	}
+	t.Log("@ Authenticated bypass")
+	{
+		w := get("Authorization", "Bearer x")
+		testkit.LogDiff(t, "Bearer bypasses cache", w.Header().Get("X-Cache"), "")
+		testkit.LogDiff(t, "Handler called", atomic.LoadInt32(&calls), int32(3))
+	}
+	t.Log("@ Purge")
+	{
+		rc.Purge(mid.CacheKey(httptest.NewRequest("GET", "/pub/foo?a=1&b=2", nil), "Accept"))
+		testkit.LogDiff(t, "Purge by key", rc.Len(), 1)
+		rc.PurgeTag("pages")
+		testkit.LogDiff(t, "Purge by tag", rc.Len(), 0)
+	}
+}
+
+func TestCacheStale(t *testing.T) {
+	var calls int32
+	done := make(chan struct{}, 1)
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		n := atomic.AddInt32(&calls, 1)
+		if n > 1 {
+			defer func() { done <- struct{}{} }()
+		}
+		return web.Respond(ctx, w, &web.Resource{Content: []byte("v"), Ctype: web.HTML}, http.StatusOK)
+	}
+	rc := mid.NewResponseCache(0)
+	h := mid.Cache(rc, mid.CacheRoute{TTL: time.Nanosecond, Stale: time.Hour})(handler)
+
+	for _, exp := range []string{"", "STALE"} {
+		w := httptest.NewRecorder()
+		err := h(testkit.Context(), w, httptest.NewRequest("GET", "/", nil))
+		testkit.Log(t, "Serve request", err)
+		testkit.LogDiff(t, "X-Cache", w.Header().Get("X-Cache"), exp)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stale entry not revalidated")
+	}
+	testkit.LogDiff(t, "Revalidated in background", atomic.LoadInt32(&calls), int32(2))
+}
+
+func TestCacheKeying(t *testing.T) {
+	var calls int32
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Path == "/lang" {
+			w.Header().Set("Vary", "Accept-Language")
+		}
+		body := "for " + r.Header.Get("Authorization")
+		return web.Respond(ctx, w, &web.Resource{Content: []byte(body), Ctype: web.HTML}, http.StatusOK)
+	}
+	serve := func(h web.Handler, path, authz string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		if authz != "" {
+			r.Header.Set("Authorization", authz)
+		}
+		w := httptest.NewRecorder()
+		testkit.Log(t, "Serve request", h(testkit.Context(), w, r))
+		return w
+	}
+
+	t.Log("@ Per principal")
+	{
+		h := mid.Cache(mid.NewResponseCache(0), mid.CacheRoute{TTL: time.Minute, Auth: true})(handler)
+		serve(h, "/", "Bearer a")
+		w := serve(h, "/", "Bearer a")
+		testkit.LogDiff(t, "Hit of same principal", w.Header().Get("X-Cache"), "HIT")
+		w = serve(h, "/", "Bearer b")
+		testkit.LogDiff(t, "Miss of other principal", w.Header().Get("X-Cache"), "")
+		testkit.LogDiff(t, "Body of other principal", w.Body.String(), "for Bearer b")
+	}
+	t.Log("@ Vary of response")
+	{
+		atomic.StoreInt32(&calls, 0)
+		h := mid.Cache(mid.NewResponseCache(0), mid.CacheRoute{TTL: time.Minute})(handler)
+		serve(h, "/lang", "")
+		serve(h, "/lang", "")
+		testkit.LogDiff(t, "Not stored if varied per unkeyed header", atomic.LoadInt32(&calls), int32(2))
+
+		h = mid.Cache(mid.NewResponseCache(0), mid.CacheRoute{TTL: time.Minute, Vary: []string{"Accept-Language"}})(handler)
+		serve(h, "/lang", "")
+		w := serve(h, "/lang", "")
+		testkit.LogDiff(t, "Stored if keyed", w.Header().Get("X-Cache"), "HIT")
+	}
+	t.Log("@ Capacity")
+	{
+		rc := mid.NewResponseCache(2)
+		h := mid.Cache(rc, mid.CacheRoute{TTL: time.Minute})(handler)
+		for _, p := range []string{"/1", "/2", "/3"} {
+			serve(h, p, "")
+		}
+		testkit.LogDiff(t, "Len", rc.Len(), 2)
+		testkit.LogDiff(t, "Newest kept", serve(h, "/3", "").Header().Get("X-Cache"), "HIT")
+		testkit.LogDiff(t, "Oldest evicted", serve(h, "/1", "").Header().Get("X-Cache"), "")
+	}
+}
@@ -0,0 +1,421 @@
+package mid
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sempernow/kit/gz"
+	"github.com/sempernow/kit/web"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ************************************************************
+// Handle compression at response writer, not at request middleware.
+// The writer defers its decision until the handler has declared
+// status, Content-Type, Content-Encoding and (maybe) Content-Length,
+// so 204/304 bodies, images and tiny responses pass through untouched.
+// ************************************************************
+
+// CompressConfig declares the parameters of Compress(..); zero values select defaults.
+type CompressConfig struct {
+	// Encodings offered, in order of server preference; "gzip" and "deflate" (default both).
+	Encodings []string
+	// MinSize (bytes) of a body worth compressing (default 1024).
+	MinSize int
+	// Types is the content-type allowlist; media type ("text/css") or range ("text/*").
+	// Default is text/*, JSON, JavaScript, SVG and XML types.
+	Types []string
+	// Level of compression per compress/flate, HuffmanOnly through BestCompression
+	// (default flate.DefaultCompression). As 0 selects the default, NoCompression is
+	// not selectable; offer no Encodings of the route instead.
+	Level int
+}
+
+// CompressTypes is the default content-type allowlist of Compress(..).
+var CompressTypes = []string{
+	"text/*",
+	web.JSON, web.WEBMANIFEST, web.SVG,
+	"application/javascript", "application/xml",
+	"*+json", "*+xml",
+}
+
+// Compress contains a chainable handler that compresses the response body
+// per encoding negotiated against the request's Accept-Encoding header (q values honored),
+// adding `Vary: Accept-Encoding` to any response of a compressible type.
+// Responses already encoded (`web.Resource.Gz`) pass through if the client accepts gzip,
+// else are decoded to identity.
+//
+// It panics if cfg declares an unsupported encoding or a level out of range.
+//
+//	svc := web.NewApp(shutdown, mid.Logger(log), mid.Errors(log), mid.Compress(mid.CompressConfig{}))
+func Compress(cfg CompressConfig) web.Middleware {
+	if len(cfg.Encodings) == 0 {
+		cfg.Encodings = []string{"gzip", "deflate"}
+	}
+	if cfg.MinSize == 0 {
+		cfg.MinSize = 1024
+	}
+	if len(cfg.Types) == 0 {
+		cfg.Types = CompressTypes
+	}
+	if cfg.Level == 0 {
+		cfg.Level = flate.DefaultCompression
+	}
+	if cfg.Level < flate.HuffmanOnly || cfg.Level > flate.BestCompression {
+		panic("compress : invalid level : " + strconv.Itoa(cfg.Level))
+	}
+	for _, e := range cfg.Encodings {
+		if e != "gzip" && e != "deflate" {
+			panic("compress : unsupported encoding : " + e)
+		}
+	}
+
+	m := func(after web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "kit.mid.compress")
+			defer span.End()
+
+			// Upgraded connections (WebSocket) are not ours to encode.
+			if r.Header.Get("Upgrade") != "" {
+				return after(ctx, w, r)
+			}
+
+			cw := &compressWriter{
+				ResponseWriter: w,
+				cfg:            &cfg,
+				accept:         r.Header.Get("Accept-Encoding"),
+				head:           r.Method == http.MethodHead,
+			}
+			err := after(ctx, cw, r)
+			if errClose := cw.close(); err == nil {
+				err = errClose
+			}
+			return err
+		}
+		return h
+	}
+	return m
+}
+
+// Gzip compresses the response body per gzip if the request accepts it.
+//
+// Deprecated: Use Compress, which this now wraps.
+func Gzip() web.Middleware {
+	return Compress(CompressConfig{Encodings: []string{"gzip"}})
+}
+
+// Compression modes of compressWriter.
+const (
+	modePending  = iota // Awaiting header or MinSize bytes.
+	modePass            // Write through as is.
+	modeEncode          // Write through the encoder.
+	modeGunzip          // Buffer all; decode gzip on close.
+	modeBuffered        // Size unknown; buffering until MinSize.
+)
+
+// compressWriter decides upon first write whether and how to encode the body.
+type compressWriter struct {
+	http.ResponseWriter
+	cfg    *CompressConfig
+	accept string
+	head   bool
+
+	mode    int
+	status  int
+	coding  string
+	buf     []byte
+	enc     io.WriteCloser
+	release func()
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	if status < 200 && status != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(status) //... informational; e.g., 103 Early Hints.
+		return
+	}
+	w.status = status
+	w.decide()
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	switch w.mode {
+	case modeEncode:
+		return w.enc.Write(b)
+	case modeGunzip:
+		w.buf = append(w.buf, b...)
+		return len(b), nil
+	case modeBuffered:
+		w.buf = append(w.buf, b...)
+		if len(w.buf) >= w.cfg.MinSize {
+			if err := w.commit(); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher; a buffered body is thereby committed to encoding.
+func (w *compressWriter) Flush() {
+	if w.mode == modeBuffered {
+		w.commit()
+	}
+	if f, ok := w.enc.(interface{ Flush() error }); ok && w.mode == modeEncode {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker if the underlying ResponseWriter does.
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("compress : response writer is not a hijacker")
+	}
+	w.mode = modePass
+	return hj.Hijack()
+}
+
+// decide sets the mode per status and the headers declared by the handler.
+// A body already gzip encoded (e.g., of web.Resource.Gz) is decoded for a client
+// that does not accept gzip, regardless of Types, which limit only compression.
+func (w *compressWriter) decide() {
+	hdr := w.Header()
+
+	if w.head || w.status == http.StatusNoContent || w.status == http.StatusNotModified ||
+		w.status == http.StatusSwitchingProtocols {
+		w.pass()
+		return
+	}
+
+	switch upstream := hdr.Get("Content-Encoding"); upstream {
+	case "", "identity":
+	case "gzip":
+		addVary(hdr, "Accept-Encoding")
+		if qvalue(w.accept, "gzip") > 0 {
+			w.pass()
+			return
+		}
+		hdr.Del("Content-Length")
+		hdr.Del("Content-Encoding")
+		w.mode = modeGunzip
+		return
+	default:
+		w.pass()
+		return
+	}
+
+	if !allowType(w.cfg.Types, hdr.Get("Content-Type")) {
+		w.pass()
+		return
+	}
+	addVary(hdr, "Accept-Encoding")
+
+	w.coding = NegotiateEncoding(w.accept, w.cfg.Encodings...)
+	if w.coding == "identity" {
+		w.pass()
+		return
+	}
+	if cl := hdr.Get("Content-Length"); cl != "" {
+		if n, err := strconv.Atoi(cl); err == nil && n < w.cfg.MinSize {
+			w.pass()
+			return
+		}
+		w.encode() //... else passed through.
+		return
+	}
+	w.mode = modeBuffered
+}
+
+// pass writes the header and the rest of the response through as is.
+func (w *compressWriter) pass() {
+	w.mode = modePass
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// encode starts the encoder of the response, then writes its header.
+// If the encoder fails to start, the response passes through as is.
+func (w *compressWriter) encode() error {
+	enc, release, err := w.encoder()
+	if err != nil {
+		w.pass()
+		return err
+	}
+	hdr := w.Header()
+	hdr.Del("Content-Length")
+	hdr.Set("Content-Encoding", w.coding)
+	w.ResponseWriter.WriteHeader(w.status)
+	w.enc, w.release, w.mode = enc, release, modeEncode
+	return nil
+}
+
+// encoder returns that of the negotiated coding, and its release (if pooled).
+func (w *compressWriter) encoder() (io.WriteCloser, func(), error) {
+	switch w.coding {
+	case "gzip":
+		if w.cfg.Level == flate.DefaultCompression {
+			zw := gzPool.Get().(*gzip.Writer)
+			zw.Reset(w.ResponseWriter)
+			return zw, func() { gzPool.Put(zw) }, nil
+		}
+		zw, err := gzip.NewWriterLevel(w.ResponseWriter, w.cfg.Level)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "compress : gzip")
+		}
+		return zw, nil, nil
+	case "deflate":
+		zw, err := flate.NewWriter(w.ResponseWriter, w.cfg.Level)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "compress : deflate")
+		}
+		return zw, nil, nil
+	}
+	return nil, nil, errors.New("compress : unsupported encoding : " + w.coding)
+}
+
+// commit writes the buffered body through the encoder, else as is if it fails to start.
+func (w *compressWriter) commit() error {
+	w.encode() //... else passed through.
+	bb := w.buf
+	w.buf = nil
+	if w.mode == modeEncode {
+		_, err := w.enc.Write(bb)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(bb)
+	return err
+}
+
+// close completes the response per mode.
+func (w *compressWriter) close() error {
+	switch w.mode {
+	case modePending:
+		return nil //... handler wrote nothing.
+	case modeEncode:
+		err := w.enc.Close()
+		if w.release != nil {
+			w.release()
+		}
+		return err
+	case modeBuffered:
+		w.Header().Set("Content-Length", strconv.Itoa(len(w.buf)))
+		w.pass()
+		_, err := w.ResponseWriter.Write(w.buf)
+		return err
+	case modeGunzip:
+		bb, err := gz.Read(w.buf)
+		if err != nil {
+			return errors.Wrap(err, "compress : gunzip")
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(bb)))
+		w.pass()
+		_, err = w.ResponseWriter.Write(bb)
+		return err
+	}
+	return nil
+}
+
+var gzPool = sync.Pool{
+	New: func() interface{} {
+		w := gzip.NewWriter(io.Discard)
+		return w
+	},
+}
+
+// NegotiateEncoding returns the content coding of offers (in server preference)
+// most preferred per Accept-Encoding header (accept), else "identity".
+// Codings of q=0 are refused, as are those unlisted if "*;q=0".
+// https://www.rfc-editor.org/rfc/rfc9110#field.accept-encoding
+func NegotiateEncoding(accept string, offers ...string) string {
+	best, bestQ := "identity", 0.0
+	for _, o := range offers {
+		if q := qvalue(accept, o); q > bestQ {
+			best, bestQ = o, q
+		}
+	}
+	return best
+}
+
+// qvalue returns the q value of content coding per Accept-Encoding header (accept).
+func qvalue(accept, coding string) float64 {
+	wild := -1.0
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, p := range params[1:] {
+			k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if ok && strings.EqualFold(k, "q") {
+				if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 && f <= 1 {
+					q = f
+				} else {
+					q = 0
+				}
+			}
+		}
+		switch {
+		case name == coding:
+			return q
+		case name == "*":
+			wild = q
+		}
+	}
+	if wild >= 0 {
+		return wild
+	}
+	return 0
+}
+
+// allowType reports whether content type (ctype) matches any of types;
+// a media type, a range ("text/*"), or a structured-syntax suffix ("*+json").
+func allowType(types []string, ctype string) bool {
+	ctype = strings.ToLower(strings.TrimSpace(strings.SplitN(ctype, ";", 2)[0]))
+	if ctype == "" {
+		return false
+	}
+	for _, t := range types {
+		switch {
+		case t == ctype:
+			return true
+		case strings.HasSuffix(t, "/*") && strings.HasPrefix(ctype, strings.TrimSuffix(t, "*")):
+			return true
+		case strings.HasPrefix(t, "*+") && strings.HasSuffix(ctype, t[1:]):
+			return true
+		}
+	}
+	return false
+}
+
+// addVary adds field to the Vary header unless listed already.
+func addVary(hdr http.Header, field string) {
+	for _, v := range hdr.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(f), field) {
+				return
+			}
+		}
+	}
+	hdr.Add("Vary", field)
+}
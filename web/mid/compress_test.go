@@ -0,0 +1,135 @@
+package mid_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sempernow/kit/gz"
+	"github.com/sempernow/kit/testkit"
+	"github.com/sempernow/kit/web"
+	"github.com/sempernow/kit/web/mid"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	offers := []string{"gzip", "deflate"}
+	tests := []struct {
+		accept, exp string
+	}{
+		{"", "identity"},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "gzip"},
+		{"deflate, gzip;q=0.5", "deflate"},
+		{"gzip;q=0", "identity"},
+		{"gzip;q=0, *", "deflate"},
+		{"*;q=0.1, gzip;q=0", "deflate"},
+		{"br", "identity"},
+		{"GZIP;Q=0.8", "gzip"},
+		{"gzip;q=bogus", "identity"},
+	}
+	for _, tt := range tests {
+		testkit.LogDiff(t, "Accept-Encoding: "+tt.accept, mid.NegotiateEncoding(tt.accept, offers...), tt.exp)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	page := strings.Repeat("<p>lorem ipsum</p>", 100)
+	serve := func(rs *web.Resource, status int, accept string) *httptest.ResponseRecorder {
+		h := mid.Compress(mid.CompressConfig{})(
+			func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				return web.Respond(ctx, w, rs, status)
+			},
+		)
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", accept)
+		w := httptest.NewRecorder()
+		testkit.Log(t, "Serve request", h(testkit.Context(), w, r))
+		return w
+	}
+
+	t.Log("@ Compressible")
+	{
+		w := serve(&web.Resource{Content: []byte(page), Ctype: web.HTML}, 200, "deflate;q=0.5, gzip")
+		testkit.LogDiff(t, "Content-Encoding", w.Header().Get("Content-Encoding"), "gzip")
+		testkit.LogDiff(t, "Vary", w.Header().Get("Vary"), "Accept-Encoding")
+		bb, err := gz.Read(w.Body.Bytes())
+		testkit.Log(t, "Decode body", err)
+		testkit.LogDiff(t, "Body", string(bb), page)
+
+		w = serve(&web.Resource{Content: []byte(page), Ctype: web.HTML}, 200, "gzip;q=0, deflate")
+		testkit.LogDiff(t, "Content-Encoding", w.Header().Get("Content-Encoding"), "deflate")
+
+		w = serve(&web.Resource{Content: []byte(page), Ctype: web.HTML}, 200, "gzip;q=0")
+		testkit.LogDiff(t, "Content-Encoding", w.Header().Get("Content-Encoding"), "identity")
+		testkit.LogDiff(t, "Body", w.Body.String(), page)
+	}
+	t.Log("@ Pass through")
+	{
+		w := serve(&web.Resource{Content: []byte("tiny"), Ctype: web.HTML}, 200, "gzip")
+		testkit.LogDiff(t, "Tiny body", w.Header().Get("Content-Encoding"), "identity")
+
+		w = serve(&web.Resource{Content: []byte(page), Ctype: web.PNG}, 200, "gzip")
+		testkit.LogDiff(t, "Image", w.Header().Get("Content-Encoding"), "identity")
+		testkit.LogDiff(t, "Image not varied", w.Header().Get("Vary"), "")
+
+		w = serve(&web.Resource{Content: []byte(page), Ctype: web.HTML}, 200, "")
+		testkit.LogDiff(t, "Not accepted", w.Header().Get("Content-Encoding"), "identity")
+		testkit.LogDiff(t, "Not accepted yet varied", w.Header().Get("Vary"), "Accept-Encoding")
+	}
+	t.Log("@ Already gzip (web.Resource.Gz)")
+	{
+		bb, _ := gz.Write([]byte(page))
+		w := serve(&web.Resource{Content: bb, Ctype: web.HTML, Gz: true}, 200, "gzip")
+		testkit.LogDiff(t, "Passed as is", w.Body.String(), string(bb))
+
+		w = serve(&web.Resource{Content: bb, Ctype: web.HTML, Gz: true}, 200, "deflate")
+		testkit.LogDiff(t, "Decoded", w.Header().Get("Content-Encoding"), "")
+		testkit.LogDiff(t, "Decoded body", w.Body.String(), page)
+
+		wasm := "application/wasm"
+		w = serve(&web.Resource{Content: bb, Ctype: wasm, Gz: true}, 200, "identity")
+		testkit.LogDiff(t, "Decoded sans type allowed", w.Header().Get("Content-Encoding"), "")
+		testkit.LogDiff(t, "Decoded body sans type allowed", w.Body.String(), page)
+		testkit.LogDiff(t, "Varied", w.Header().Get("Vary"), "Accept-Encoding")
+
+		w = serve(&web.Resource{Content: bb, Ctype: wasm, Gz: true}, 200, "")
+		testkit.LogDiff(t, "Decoded sans Accept-Encoding", w.Body.String(), page)
+
+		w = serve(&web.Resource{Content: bb, Ctype: wasm, Gz: true}, 200, "gzip")
+		testkit.LogDiff(t, "Passed as is sans type allowed", w.Header().Get("Content-Encoding"), "gzip")
+	}
+	t.Log("@ Flush before MinSize")
+	{
+		h := mid.Compress(mid.CompressConfig{})(
+			func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				w.Header().Set("Content-Type", web.HTML)
+				w.Write([]byte("<p>"))
+				w.(http.Flusher).Flush()
+				_, err := w.Write([]byte(page))
+				return err
+			},
+		)
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		testkit.Log(t, "Serve request", h(testkit.Context(), w, r))
+		testkit.LogDiff(t, "Flushed", w.Flushed, true)
+		testkit.LogDiff(t, "Content-Encoding", w.Header().Get("Content-Encoding"), "gzip")
+		bb, err := gz.Read(w.Body.Bytes())
+		testkit.Log(t, "Decode body", err)
+		testkit.LogDiff(t, "Body", string(bb), "<p>"+page)
+	}
+	t.Log("@ Invalid config")
+	{
+		for _, cfg := range []mid.CompressConfig{{Level: 10}, {Level: -3}, {Encodings: []string{"br"}}} {
+			func() {
+				defer func() {
+					testkit.LogDiff(t, "Panics", recover() != nil, true)
+				}()
+				mid.Compress(cfg)
+			}()
+		}
+	}
+}
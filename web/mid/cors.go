@@ -21,28 +21,35 @@ import (
 **********************************************************************************/
 
 // CORS handles Cross-Origin Resource Sharing requests;
-// allowed origins and methods are settable per endpoint,
+// allowed origins (policy) and methods are settable per endpoint,
 // and closed over per declaration.
+//   - policy is required; it panics if nil.
+//   - a policy of any origin ("*") is answered as such, sans credentials.
 //   - methods GET, HEAD, and OPTIONS are allowed regardless.
-func CORS(origins, methods []string) web.Middleware {
-
-	if len(origins) == 0 {
-		origins = append(origins, "*")
+func CORS(policy *OriginPolicy, methods []string) web.Middleware {
+	if policy == nil {
+		panic("cors : origin policy is required")
 	}
+
 	methods = append(methods, []string{"GET", "HEAD", "OPTIONS"}...)
 	allow := strings.Join(str.Unique(methods), ",")
 
-	match := func(origins []string, r *http.Request) string {
-		for _, o := range origins {
-			if r.Header.Get("Origin") == o {
-				return r.Header.Get("Origin")
-			} else {
-				if o == "*" {
-					return r.Header.Get("Origin")
-				}
-			}
+	// allowOrigin sets the headers of the request's origin if allowed; else none,
+	// so the browser blocks the cross-origin request. An echo of any origin along
+	// with credentials would grant every site credentialed reads, so any is "*",
+	// which browsers refuse with credentials.
+	allowOrigin := func(w http.ResponseWriter, r *http.Request) {
+		if policy.Any() {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			return
 		}
-		return ""
+		if o := r.Header.Get("Origin"); o != "" && policy.AllowOrigin(o) {
+			w.Header().Set("Access-Control-Allow-Origin", o)
+			// To send Cookie, WWW-Authentication headers:
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			// ... browser sends only on fetch setting: "credentials: true"
+		}
+		addVary(w.Header(), "Origin") //... response varies per origin.
 	}
 
 	m := func(after web.Handler) web.Handler {
@@ -76,19 +83,11 @@ func CORS(origins, methods []string) web.Middleware {
 				// TODO: Read Origin header, validate against approved list (meta.Service),
 				// and then set origin to Origin if allowing.
 
-				if mo := match(origins, r); mo != "" {
-					w.Header().Set("Access-Control-Allow-Origin", mo)
-					//w.Header().Set("Access-Control-Allow-Origin", "*")
-					// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Access-Control-Allow-Origin
-					//... EITHER one origin, or all (*)
-					//    Client declares per value of request header `Origin: ...`.
-					//    https://developer.mozilla.org/en-US/docs/Web/HTTP/CORS
-				} else {
-					if len(origins) > 0 {
-						w.Header().Set("Access-Control-Allow-Origin", origins[0])
-					}
-				}
-				w.Header().Set("Access-Control-Allow-Credentials", "true")
+				allowOrigin(w, r)
+				// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Access-Control-Allow-Origin
+				//... EITHER one origin, or all (*)
+				//    Client declares per value of request header `Origin: ...`.
+				//    https://developer.mozilla.org/en-US/docs/Web/HTTP/CORS
 
 				// List of Allowable Request Headers:
 				// @ "Unsafe" requests : https://javascript.info/fetch-crossorigin#safe-requests
@@ -126,19 +125,7 @@ func CORS(origins, methods []string) web.Middleware {
 				return after(ctx, w, r)
 			} //... All browsers add Origin header to all CORS requests.
 
-			if mo := match(origins, r); mo != "" {
-				dbug(DBUG, "CORS : origins match :: ALLOWing")
-				// To allow, send this header.
-				w.Header().Set("Access-Control-Allow-Origin", mo)
-				// To send Cookie, WWW-Authentication headers:
-				w.Header().Set("Access-Control-Allow-Credentials", "true")
-				// ... browser sends only on fetch setting: "credentials: true"
-
-			} else {
-				if mo != "*" { //... if response varies per origin, then ...
-					w.Header().Set("Vary", "Origin")
-				} //... if request denied, give client a hint of how response varies.
-			}
+			allowOrigin(w, r)
 
 			// @ "Safe" requests : https://javascript.info/fetch-crossorigin#safe-requests
 			// (@ "Unsafe" requests, this is handled @ preflight per Access-Control-Allow-Headers)
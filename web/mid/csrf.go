@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/sempernow/kit/auth"
@@ -29,18 +30,22 @@ const (
 // CSRF mitigates Cross-Site Request Forgery attacks per mode.
 // Each mode is a stateless OWASP-advised method for doing so.
 // Multiple modes may be invoked per handler; modes are mutually orthogonal.
-// See mitigate(..) for per-mode details.
+// See mitigate(..) for per-mode details. The origin policy applies only to
+// SourceTargetHeaders mode, and may be nil otherwise.
 // https://cheatsheetseries.owasp.org/cheatsheets/Cross-Site_Request_Forgery_Prevention_Cheat_Sheet.html
 //
-//	USAGE: mid.CSRF(mid.DomainLockedDouble, "__Host-c", []string{"http://foo.com", "https://api.bar.xyz"}...)
-func CSRF(mode int, cookieKey string, origins ...string) web.Middleware {
+//	USAGE: mid.CSRF(mid.SourceTargetHeaders, "", mid.MustOriginPolicy(log, "https://foo.com", "https://api.bar.xyz"))
+func CSRF(mode int, cookieKey string, policy *OriginPolicy) web.Middleware {
+	if mode == SourceTargetHeaders && policy == nil {
+		panic("csrf : SourceTargetHeaders mode requires an origin policy")
+	}
 
 	m := func(after web.Handler) web.Handler {
 		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 			ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "kit.mid.csrf")
 			defer span.End()
 
-			err := mitigate(r, mode, cookieKey, origins...)
+			err := mitigate(r, mode, cookieKey, policy)
 			if (mode == DoubleSubmitCookie) || (mode == DomainLockedDouble) {
 				DeleteCSRFCookie(w, cookieKey)
 			}
@@ -70,16 +75,15 @@ func DeleteCSRFCookie(w http.ResponseWriter, key string) {
 	http.SetCookie(w, csrf)
 }
 
-// mitigate(..) per mode. The origins param applies only to
-// SourceTargetHeaders (1) mode.
-func mitigate(r *http.Request, mode int, key string, origins ...string) error {
+// mitigate(..) per mode. The policy param applies only to
+// SourceTargetHeaders (2) mode.
+func mitigate(r *http.Request, mode int, key string, policy *OriginPolicy) error {
 	switch mode {
 	case SansMitigation:
 	case CustomAJAXHeader:
 		// This solution is weak; relies on Fetch default settings/behavior.
 		// Custom Request Header @ AJAX requests
 		// https://cheatsheetseries.owasp.org/cheatsheets/Cross-Site_Request_Forgery_Prevention_Cheat_Sheet.html#use-of-custom-request-headers
-		dbug(DBUG, "CSRF : CustomAJAXHeader: ", r.Header.Get("X-CSRF-Token"))
 		if r.Header.Get("X-CSRF-Token") == "" {
 			err := errors.New("missing x-csrf-token header")
 			return web.NewRequestError(err, http.StatusForbidden)
@@ -92,37 +96,21 @@ func mitigate(r *http.Request, mode int, key string, origins ...string) error {
 		// Requests lacking Referer/Host/Origin headers will pass CSRF.
 		// Such are typically legitimate; 3rd-party server-sent requests.
 		// *****************************************************************
-		var (
-			err    error
-			errStr string
-		)
+		var mismatch []string
 		// SOURCE (Origin||Referer)
-		if !matchOrigin(origins, r.Referer()) {
-			errStr = "host-referer"
+		if !policy.AllowOrigin(r.Referer()) {
+			mismatch = append(mismatch, "host-referer")
 		}
-		if !matchOrigin(origins, r.Header.Get("Origin")) {
-			if errStr != "" {
-				errStr += "AND host-origin"
-			}
+		if !policy.AllowOrigin(r.Header.Get("Origin")) {
+			mismatch = append(mismatch, "host-origin")
 		}
 		// TARGET (Host) header must be sent in all HTTP/1.1 requests.
 		// Golang unsets the Host request header; "promotes" it to `r.Host`.
-		if !matchHost(origins, r.Host) {
-			if errStr != "" {
-				errStr += ", "
-			}
-			errStr = errStr + "host-target"
-		}
-		if errStr != "" {
-			errStr = "csrf : mismatch : " + errStr
-			err = errors.New(errStr)
+		if !policy.AllowHost(r.Host) {
+			mismatch = append(mismatch, "host-target")
 		}
-
-		dbug(DBUG, "CSRF : SourceTargetHeaders",
-			" : Referer: ", r.Referer(),
-			" : Host: ", r.Host,
-		)
-		if err != nil {
+		if len(mismatch) > 0 {
+			err := errors.New("csrf : mismatch : " + strings.Join(mismatch, ", "))
 			return web.NewRequestError(err, http.StatusForbidden)
 		}
 	case DoubleSubmitCookie:
@@ -166,8 +154,6 @@ func mitigate(r *http.Request, mode int, key string, origins ...string) error {
 			return web.NewRequestError(err, http.StatusForbidden)
 		}
 
-		dbug(DBUG, "CSRF : DomainLockedDouble : token: ", tkn.CSRF)
-
 	case HMACCookie:
 		// HMAC-based Token Pattern : a DoubleSubmitCookie method
 		// https://en.wikipedia.org/wiki/HMAC
@@ -10,8 +10,12 @@ import (
 	"go.opentelemetry.io/otel/trace"
 )
 
-// Hotlinks forbids requests from all Referer, Host, and Origin not on hosts whitelist.
-func Hotlinks(hosts []string) web.Middleware {
+// Hotlinks forbids requests from all Referer, Host, and Origin not whitelisted by policy;
+// it panics if policy is nil.
+func Hotlinks(policy *OriginPolicy) web.Middleware {
+	if policy == nil {
+		panic("hotlink : origin policy is required")
+	}
 	m := func(after web.Handler) web.Handler {
 		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 			ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "kit.mid.hotlinks")
@@ -19,17 +23,14 @@ func Hotlinks(hosts []string) web.Middleware {
 
 			var err error
 
-			if !matchHost(hosts, r.Host) {
+			if !policy.AllowHost(r.Host) {
 				err = errors.New("hotlink : host mismatch : " + r.Host)
 			}
-			if !matchOrigin(hosts, r.Referer()) {
+			if !policy.AllowOrigin(r.Referer()) {
 				err = errors.New("hotlink : referer mismatch")
 			}
-			o := r.Header.Get("Origin")
-			if o != "" {
-				if !matchOrigin(hosts, o) {
-					err = errors.New("hotlink : origin mismatch")
-				}
+			if !policy.AllowOrigin(r.Header.Get("Origin")) {
+				err = errors.New("hotlink : origin mismatch")
 			}
 
 			if err != nil {
@@ -20,6 +20,9 @@ import (
 // does not contain any blacklisted string of []excludedPaths list.
 //
 //	Format: TraceID : (200) GET /foo/bar -> IP:Port (Latency)
+//
+// The client IP is that of `web.Values.ClientIP` if resolved (see `web.App.SetClientIP`),
+// else that of the peer, `r.RemoteAddr`.
 func Logger(log *log.Logger, excludePaths ...string) web.Middleware {
 	m := func(before web.Handler) web.Handler {
 		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
@@ -28,7 +31,7 @@ func Logger(log *log.Logger, excludePaths ...string) web.Middleware {
 
 			// If the context is missing this value,
 			// request the service to be shutdown gracefully.
-			v, ok := ctx.Value(web.Key1).(*web.Values)
+			v, ok := web.ValuesFrom(ctx)
 			if !ok {
 				return web.NewShutdownError("context : missing web values")
 			}
@@ -52,11 +55,15 @@ func Logger(log *log.Logger, excludePaths ...string) web.Middleware {
 				// 	r.RemoteAddr,
 				// 	time.Since(v.Now),
 				// )
+				remote := r.RemoteAddr
+				if v.ClientIP != "" {
+					remote = v.ClientIP
+				}
 				log.Printf("(%d) : %s %s -> %s (%s)",
 					v.StatusCode,
 					r.Method,
 					r.URL.Path,
-					r.RemoteAddr,
+					remote,
 					time.Since(v.Now),
 				)
 			}
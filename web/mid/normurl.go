@@ -23,7 +23,7 @@ func NormURL(params string) web.Middleware {
 
 			// If the context is missing this value, request the service
 			// to be shutdown gracefully.
-			_, ok := ctx.Value(web.Key1).(*web.Values)
+			_, ok := web.ValuesFrom(ctx)
 			if !ok {
 				return web.NewShutdownError("context : missing web values")
 			}
@@ -0,0 +1,200 @@
+package mid
+
+import (
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// OriginPolicy matches the source (Origin, Referer) and target (Host) of requests
+// against a whitelist of rules, per exact scheme, host and port;
+// shared by Hotlinks(..), CSRF(..) and CORS(..). Rules are of the forms:
+//
+//	https://app.foo.com       Scheme, host and (default) port 443
+//	https://app.foo.com:8443  Scheme, host and port
+//	https://*.foo.com         Any subdomain of foo.com, but not foo.com itself
+//	localhost:3030            Host and port, of scheme http or https
+//	foo.com                   Host of scheme http or https, at its default port
+//	*                         Any origin
+//
+// Denials are logged if the logger (log) is not nil.
+// Its consumers require one; allow any origin per rule "*", not per nil.
+type OriginPolicy struct {
+	rules []originRule
+	any   bool
+	log   *log.Logger
+}
+
+type originRule struct {
+	scheme string // Empty if http or https.
+	host   string // Sans "*." if wild.
+	port   string // Empty if default of scheme.
+	wild   bool
+}
+
+// NewOriginPolicy parses rules into an OriginPolicy.
+func NewOriginPolicy(log *log.Logger, rules ...string) (*OriginPolicy, error) {
+	p := OriginPolicy{log: log}
+	for _, s := range rules {
+		if s == "*" {
+			p.any = true
+			continue
+		}
+		raw := s
+		if !strings.Contains(s, "://") {
+			raw = "//" + s
+		}
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, errors.Wrap(err, "origin policy : rule "+s)
+		}
+		if u.Host == "" || u.User != nil || strings.Trim(u.Path, "/") != "" || u.RawQuery != "" {
+			return nil, errors.New("origin policy : rule is not an origin : " + s)
+		}
+		rule := originRule{
+			scheme: strings.ToLower(u.Scheme),
+			host:   strings.ToLower(u.Hostname()),
+			port:   u.Port(),
+		}
+		if strings.HasPrefix(rule.host, "*.") {
+			rule.wild = true
+			rule.host = rule.host[2:]
+		}
+		if strings.Contains(rule.host, "*") || rule.host == "" {
+			return nil, errors.New("origin policy : invalid host wildcard : " + s)
+		}
+		if rule.port == defaultPort(rule.scheme) {
+			rule.port = ""
+		}
+		p.rules = append(p.rules, rule)
+	}
+	return &p, nil
+}
+
+// MustOriginPolicy is NewOriginPolicy(..) that panics on error; for use at init.
+func MustOriginPolicy(log *log.Logger, rules ...string) *OriginPolicy {
+	p, err := NewOriginPolicy(log, rules...)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Any reports whether the policy allows any origin.
+func (p *OriginPolicy) Any() bool {
+	return p.any
+}
+
+// AllowOrigin reports whether origin (an Origin or Referer header value) is whitelisted.
+// Absent (empty) header passes; such requests are typically same-origin or server-sent.
+func (p *OriginPolicy) AllowOrigin(origin string) bool {
+	if origin == "" || p.any {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err == nil && u.Host != "" && u.User == nil {
+		scheme := strings.ToLower(u.Scheme)
+		port := u.Port()
+		if port == defaultPort(scheme) {
+			port = ""
+		}
+		for _, rule := range p.rules {
+			if rule.match(scheme, strings.ToLower(u.Hostname()), port) {
+				return true
+			}
+		}
+	}
+	p.deny("origin", origin)
+	return false
+}
+
+// AllowHost reports whether host (`r.Host`; "host[:port]") is whitelisted.
+// The scheme is unknown to Host, so its port, if any, is compared to that of each rule's scheme.
+// Absent (empty) host passes.
+func (p *OriginPolicy) AllowHost(host string) bool {
+	if host == "" || p.any {
+		return true
+	}
+	u, err := url.Parse("//" + host)
+	if err == nil && u.Host == host && u.User == nil {
+		h, port := strings.ToLower(u.Hostname()), u.Port()
+		for _, rule := range p.rules {
+			if rule.match(rule.scheme, h, port) || (rule.implies(port) && rule.match(rule.scheme, h, "")) {
+				return true
+			}
+		}
+	}
+	p.deny("host", host)
+	return false
+}
+
+// match reports whether rule matches the (normalized) scheme, host and port.
+func (rule originRule) match(scheme, host, port string) bool {
+	switch rule.scheme {
+	case "":
+		if scheme != "" && scheme != "http" && scheme != "https" {
+			return false
+		}
+	default:
+		if scheme != rule.scheme {
+			return false
+		}
+	}
+	if port != rule.port {
+		return false
+	}
+	if rule.wild {
+		return strings.HasSuffix(host, "."+rule.host)
+	}
+	return host == rule.host
+}
+
+// implies reports whether port is the default of rule's scheme; either if sans scheme.
+func (rule originRule) implies(port string) bool {
+	if rule.scheme == "" {
+		return port == "80" || port == "443"
+	}
+	return port != "" && port == defaultPort(rule.scheme)
+}
+
+func (p *OriginPolicy) deny(kind, v string) {
+	if p.log != nil {
+		p.log.Printf("origin policy : deny %s : %q", kind, v)
+	}
+}
+
+// defaultPort returns the port implied by scheme, else empty.
+func defaultPort(scheme string) string {
+	switch scheme {
+	case "http", "ws":
+		return "80"
+	case "https", "wss":
+		return "443"
+	}
+	return ""
+}
+
+// ============================================================
+// pwa       | r.Host         : localhost:3030
+// pwa       | r.Referer()    : http://localhost:3030/test
+//
+// r.Header.Get("Referer")    : http://localhost:3030/test
+// r.Header.Get("Origin")     : http://localhost:3030
+// ============================================================
+
+// ====================================================================
+// Golang REMOVEs the Host header from the client-request headers map!
+// ====================================================================
+// All we have is r.Host as a proxy for the Host header if present,
+// else r.Host is somthing else:
+// "
+//  ... is either the value of the "Host" header
+//      or the host name given in the URL itself.
+// "
+// Inexplicably, Golang hides the Host header itself;
+// they "promote" it to that morphodite (r.Host).
+// IOW, we have NO WAY OF KNOWING if the client sent a Host header.
+// https://pkg.go.dev/net/http?utm_source=godoc#Request.Header
+// ====================================================================
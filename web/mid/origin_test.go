@@ -0,0 +1,128 @@
+package mid_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sempernow/kit/testkit"
+	"github.com/sempernow/kit/web"
+	"github.com/sempernow/kit/web/mid"
+)
+
+func TestOriginPolicy(t *testing.T) {
+	p, err := mid.NewOriginPolicy(nil,
+		"https://good.com",
+		"https://*.cdn.good.com",
+		"http://localhost:3030",
+		"api.good.com",
+	)
+	testkit.Log(t, "Parse rules", err)
+
+	t.Log("@ Origin and Referer")
+	{
+		tests := []struct {
+			origin string
+			exp    bool
+		}{
+			{"", true},
+			{"https://good.com", true},
+			{"https://good.com:443", true},
+			{"https://GOOD.com/some/page?x=1", true},
+			{"https://good.com.evil.net", false},
+			{"https://evil.net/https://good.com/", false},
+			{"https://evilgood.com", false},
+			{"http://good.com", false},
+			{"https://good.com:8443", false},
+			{"https://user@good.com", false},
+			{"null", false},
+			{"https://a.cdn.good.com", true},
+			{"https://a.b.cdn.good.com", true},
+			{"https://cdn.good.com", false},
+			{"https://x.cdn.good.com.evil.net", false},
+			{"http://localhost:3030/test", true},
+			{"http://localhost:3031", false},
+			{"https://localhost:3030", false},
+			{"http://api.good.com", true},
+			{"https://api.good.com", true},
+			{"https://api.good.com:8080", false},
+		}
+		for _, tt := range tests {
+			testkit.LogDiff(t, "AllowOrigin("+tt.origin+")", p.AllowOrigin(tt.origin), tt.exp)
+		}
+	}
+	t.Log("@ Host")
+	{
+		tests := []struct {
+			host string
+			exp  bool
+		}{
+			{"good.com", true},
+			{"good.com:443", true},
+			{"good.com:80", false},
+			{"good.com.evil.net", false},
+			{"localhost:3030", true},
+			{"localhost", false},
+			{"a.cdn.good.com", true},
+			{"api.good.com", true},
+			{"api.good.com:80", true},
+			{"good.com/x", false},
+		}
+		for _, tt := range tests {
+			testkit.LogDiff(t, "AllowHost("+tt.host+")", p.AllowHost(tt.host), tt.exp)
+		}
+	}
+	t.Log("@ Invalid rules")
+	{
+		for _, rule := range []string{"https://good.com/path", "https://go*d.com", "https://*", "user@good.com"} {
+			_, err := mid.NewOriginPolicy(nil, rule)
+			testkit.LogDiff(t, "Reject "+rule, err != nil, true)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	ok := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error { return nil }
+	serve := func(mw web.Middleware, origin string) http.Header {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		testkit.Log(t, "Serve request", mw(ok)(testkit.Context(), w, r))
+		return w.Header()
+	}
+
+	t.Log("@ Whitelisted")
+	{
+		cors := mid.CORS(mid.MustOriginPolicy(nil, "https://good.com"), nil)
+		hdr := serve(cors, "https://good.com")
+		testkit.LogDiff(t, "Origin echoed", hdr.Get("Access-Control-Allow-Origin"), "https://good.com")
+		testkit.LogDiff(t, "Credentials", hdr.Get("Access-Control-Allow-Credentials"), "true")
+		testkit.LogDiff(t, "Vary", hdr.Get("Vary"), "Origin")
+
+		hdr = serve(cors, "https://evil.net")
+		testkit.LogDiff(t, "Denied sans origin", hdr.Get("Access-Control-Allow-Origin"), "")
+		testkit.LogDiff(t, "Denied sans credentials", hdr.Get("Access-Control-Allow-Credentials"), "")
+	}
+	t.Log("@ Any")
+	{
+		hdr := serve(mid.CORS(mid.MustOriginPolicy(nil, "*"), nil), "https://evil.net")
+		testkit.LogDiff(t, "Wildcard", hdr.Get("Access-Control-Allow-Origin"), "*")
+		testkit.LogDiff(t, "Sans credentials", hdr.Get("Access-Control-Allow-Credentials"), "")
+	}
+	t.Log("@ Nil policy")
+	{
+		for name, mw := range map[string]func(){
+			"CORS":     func() { mid.CORS(nil, nil) },
+			"Hotlinks": func() { mid.Hotlinks(nil) },
+			"CSRF":     func() { mid.CSRF(mid.SourceTargetHeaders, "", nil) },
+		} {
+			func() {
+				defer func() {
+					testkit.LogDiff(t, name+" panics", recover() != nil, true)
+				}()
+				mw()
+			}()
+		}
+	}
+}
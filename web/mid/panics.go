@@ -22,7 +22,7 @@ func Panics(log *log.Logger) web.Middleware {
 
 			// If the context is missing this value, request the service
 			// to be shutdown gracefully.
-			v, ok := ctx.Value(web.Key1).(*web.Values)
+			v, ok := web.ValuesFrom(ctx)
 			if !ok {
 				return web.NewShutdownError("context : missing web values")
 			}
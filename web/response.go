@@ -150,7 +150,7 @@ func NewResponse(cc CSP) Response {
 
 		// Set status code for request-logger middleware.
 		// If context is missing Value, then request service shutdown gracefully.
-		v, ok := ctx.Value(Key1).(*Values)
+		v, ok := ValuesFrom(ctx)
 		if !ok {
 			return NewShutdownError("context : missing web values")
 		}
@@ -382,7 +382,8 @@ func NewResponse(cc CSP) Response {
 			w.Header().Set("Content-Length", "0")
 		}
 
-		if statusCode == 401 {
+		if statusCode == 401 && w.Header().Get("WWW-Authenticate") == "" {
+			//... unless a challenge is already set; e.g., per mid.Basic(..) or mid.Digest(..).
 			w.Header().Set("WWW-Authenticate", `Bearer realm="Access the web app APIs"`)
 			/****************************************************************************************
 			  Types : Basic(*)|Bearer|Digest(*)|HOBA|Mutual|Negotiate|OAuth|SCRAM=SHA-{1,256}|vapid
@@ -69,6 +69,8 @@ func GetHostname() string {
 }
 
 // GetForwardedHostIP from X-Forwarded-Host request header. Also see GetInboundIP(..) .
+//
+// Deprecated: It performs a DNS lookup of a client-supplied header. Use ClientIP.
 func GetForwardedHostIP(r *http.Request) (string, error) {
 	ips, _ := net.LookupIP(r.Header.Get("X-Forwarded-Host"))
 	for _, ip := range ips {
@@ -96,6 +98,9 @@ func GetForwardedHostIP(r *http.Request) (string, error) {
 //	SOLUTION 2: "https://github.com/newsnowlabs/docker-ingress-routing-daemon"
 //	SOLUTION 3: Prefetch client side (async); set as header: X-Client-Ip
 //		Prefetch @ "https://api.ipify.org?format=json"
+//
+// Deprecated: It trusts the headers of any caller, so clients may spoof their IP.
+// Use ClientIP, which trusts only the headers set by trusted proxies.
 func GetInboundIP(r *http.Request) (string, error) {
 
 	// Get IP from the X-REAL-IP header
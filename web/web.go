@@ -20,14 +20,27 @@ import (
 // ctxKey represents the type of value for the context key.
 type ctxKey int
 
-// Key1 is used for protected set/get of Claims VALUEs from a `context.Context`.
-const Key1 ctxKey = 1
+// valuesKey is the context key of the request's *Values; unexported, so collision free.
+const valuesKey ctxKey = 1
 
 // Values represent state for each request.
 type Values struct {
 	TraceID    string
 	Now        time.Time
 	StatusCode int
+	ClientIP   string // Set per ClientIP resolver; see App.SetClientIP(..).
+}
+
+// WithValues returns a copy of ctx carrying the request values (v).
+func WithValues(ctx context.Context, v *Values) context.Context {
+	return context.WithValue(ctx, valuesKey, v)
+}
+
+// ValuesFrom returns the request values of ctx; false if none,
+// as of a context not of a request handled by App.
+func ValuesFrom(ctx context.Context) (*Values, bool) {
+	v, ok := ctx.Value(valuesKey).(*Values)
+	return v, ok
 }
 
 // RespTimeMax is app-wide max response time in milliseconds,
@@ -57,6 +70,8 @@ type App struct {
 	otmux    http.Handler
 	shutdown chan os.Signal
 	mw       []Middleware
+	clientIP *ClientIP
+	ws       wsSessions
 }
 
 // NewApp creates an `App` value to handle a set of routes for the application.
@@ -76,9 +91,16 @@ func NewApp(shutdown chan os.Signal, mw ...Middleware) *App {
 		otmux:    otelhttp.NewHandler(mux, "req"),
 		shutdown: shutdown,
 		mw:       mw,
+		ws:       wsSessions{live: make(map[*WebSocket]context.CancelFunc)},
 	}
 }
 
+// SetClientIP sets the resolver by which the client IP of each request
+// is resolved once, on arrival, and kept at `Values.ClientIP`.
+func (a *App) SetClientIP(c *ClientIP) {
+	a.clientIP = c
+}
+
 // SignalShutdown is used to gracefully shutdown the app when an integrity issue is identified.
 func (a *App) SignalShutdown() {
 	a.shutdown <- syscall.SIGTERM
@@ -134,7 +156,10 @@ func (a *App) handle(debug bool, method string, path string, handler Handler, mw
 			TraceID: span.SpanContext().TraceID.String(),
 			Now:     time.Now().UTC(),
 		}
-		ctx = context.WithValue(ctx, Key1, &v)
+		if a.clientIP != nil {
+			v.ClientIP = a.clientIP.Resolve(r)
+		}
+		ctx = WithValues(ctx, &v)
 
 		// Sans timeout
 		// // Call the wrapped handler functions.
@@ -250,6 +275,20 @@ func validateShutdown(err error) bool {
 	return true
 }
 
+// Detach returns a context that keeps the values of ctx (trace span, Values, claims),
+// but neither its deadline nor its cancellation; for work that outlives the request.
+func Detach(ctx context.Context) context.Context {
+	return detached{ctx}
+}
+
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
 // Redirect performs as http.Redirect(..), while fitting the context-based signature of this library.
 func Redirect(ctx context.Context, w http.ResponseWriter, r *http.Request, url string, code int, msg ...string) error {
 	if (code > 399) || (code < 300) {
@@ -2,6 +2,7 @@ package web_test
 
 import (
 	"net"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
@@ -64,3 +65,55 @@ func TestGetOutboundIP(t *testing.T) {
 	t.Log(`LookupTXT("`+web.GetOutboundAddr()+`"):`, txt)
 	//t.Fatal("=== end")
 }
+
+func TestClientIP(t *testing.T) {
+	cip, err := web.NewClientIP("10.0.0.0/8", "192.168.1.1", "2001:db8:1::/48")
+	testkit.Log(t, "Parse trusted proxies", err)
+
+	tests := []struct {
+		msg, remote string
+		hdr         map[string]string
+		exp         string
+	}{
+		{"Untrusted peer; headers ignored", "203.0.113.9:5000",
+			map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Real-IP": "1.2.3.4"}, "203.0.113.9"},
+		{"Trusted peer sans headers", "10.0.0.2:5000", nil, "10.0.0.2"},
+		{"Trusted peer; X-Real-IP", "10.0.0.2:5000",
+			map[string]string{"X-Real-IP": "198.51.100.7"}, "198.51.100.7"},
+		{"Rightmost untrusted of X-Forwarded-For", "10.0.0.2:5000",
+			map[string]string{"X-Forwarded-For": "6.6.6.6, 198.51.100.7, 10.1.1.1"}, "198.51.100.7"},
+		{"Spoofed leftmost ignored", "192.168.1.1:80",
+			map[string]string{"X-Forwarded-For": "1.1.1.1,198.51.100.7"}, "198.51.100.7"},
+		{"All trusted; leftmost", "10.0.0.2:5000",
+			map[string]string{"X-Forwarded-For": "10.9.9.9, 10.1.1.1"}, "10.9.9.9"},
+		{"Garbage hop ends walk at its reporter", "10.0.0.2:5000",
+			map[string]string{"X-Forwarded-For": "198.51.100.7, bogus, 10.1.1.1"}, "10.1.1.1"},
+		{"Forwarded preferred", "10.0.0.2:5000",
+			map[string]string{
+				"Forwarded":       `for=192.0.2.60;proto=http;by=203.0.113.43, for="[2001:db8:cafe::17]:4711"`,
+				"X-Forwarded-For": "6.6.6.6",
+			}, "2001:db8:cafe::17"},
+		{"Forwarded; trusted IPv6 hop skipped", "10.0.0.2:5000",
+			map[string]string{"Forwarded": `for=192.0.2.60, for="[2001:db8:1::1]"`}, "192.0.2.60"},
+		{"Forwarded; obfuscated hop", "10.0.0.2:5000",
+			map[string]string{"Forwarded": `for=192.0.2.60, for=_hidden`}, "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		for k, v := range tt.hdr {
+			r.Header.Set(k, v)
+		}
+		testkit.LogDiff(t, tt.msg, cip.Resolve(r), tt.exp)
+	}
+
+	t.Log("@ Once per request")
+	{
+		ctx := testkit.Context()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "203.0.113.9:5000"
+		cip.Get(ctx, r)
+		r.RemoteAddr = "203.0.113.10:5000"
+		testkit.LogDiff(t, "Kept at web.Values", cip.Get(ctx, r), "203.0.113.9")
+	}
+}
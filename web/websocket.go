@@ -0,0 +1,577 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ****************************************************************************
+// WebSocket (RFC 6455) per standard library only.
+// https://www.rfc-editor.org/rfc/rfc6455
+//
+// The upgrade request runs through the route's middleware chain like any other,
+// so auth, CORS/Hotlinks origin checks and logging apply at handshake.
+// Once upgraded, the connection is no longer a request/response:
+// errors are reported to the peer as close codes, not to the chain,
+// and the session is detached from the app-wide response timeout (RespTimeMax).
+// ****************************************************************************
+
+// WebSocket message types (opcodes).
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+)
+
+const (
+	opContinuation = 0x0
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// WebSocket close codes.
+// https://www.rfc-editor.org/rfc/rfc6455#section-7.4.1
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005 // Never sent; reported if peer sent none.
+	CloseAbnormal        = 1006 // Never sent; reported if connection dropped sans close frame.
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// wsGUID is the magic of the Sec-WebSocket-Accept handshake.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrWebSocketClosed is returned on write after the close frame is sent.
+var ErrWebSocketClosed = errors.New("websocket : closed")
+
+// CloseError is the close code and reason of a closed WebSocket.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return "websocket : closed : " + strconv.Itoa(e.Code) + " " + e.Reason
+}
+
+// WebSocketConfig contains the parameters of a WebSocket endpoint; zero values select defaults.
+type WebSocketConfig struct {
+	// Subprotocols supported, in order of server preference.
+	Subprotocols []string
+	// MaxMessageSize (bytes) of a message read; larger closes with 1009 (default 1 MiB).
+	MaxMessageSize int64
+	// PingInterval of server pings; a peer silent for two intervals is dropped (default 30s).
+	// Negative disables pings and the read deadline.
+	PingInterval time.Duration
+	// WriteTimeout of each frame written (default 10s).
+	WriteTimeout time.Duration
+}
+
+// WebSocketHandler handles the session of an upgraded WebSocket connection.
+// Its context is cancelled upon App.Shutdown(..). Returning ends the session;
+// per close code 1000 if nil, else that of a *CloseError, else 1011.
+type WebSocketHandler func(ctx context.Context, ws *WebSocket) error
+
+// WebSocket is a server-side WebSocket connection.
+// ReadMessage and ReadJSON are for use by one goroutine;
+// the write methods are safe for concurrent use.
+type WebSocket struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	cfg         WebSocketConfig
+	subprotocol string
+
+	wmu      sync.Mutex
+	sentCl   bool // Close frame sent.
+	recvCl   bool // Close frame received.
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// upgrade completes the WebSocket opening handshake of request (r), hijacking its connection.
+// Before hijack, errors are of type *Error; HTTP 400, 405 or 426, so respond per RespondError.
+// Origin is not checked here; that is for middleware (see mid.OriginPolicy).
+func upgrade(w http.ResponseWriter, r *http.Request, cfg WebSocketConfig) (*WebSocket, error) {
+	if cfg.MaxMessageSize <= 0 {
+		cfg.MaxMessageSize = 1 << 20
+	}
+	if cfg.PingInterval == 0 {
+		cfg.PingInterval = 30 * time.Second
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = 10 * time.Second
+	}
+
+	if r.Method != http.MethodGet {
+		return nil, NewRequestError(errors.New("websocket : method not GET"), http.StatusMethodNotAllowed)
+	}
+	if !r.ProtoAtLeast(1, 1) || !headerHasToken(r.Header, "Connection", "upgrade") ||
+		!headerHasToken(r.Header, "Upgrade", "websocket") {
+		return nil, NewRequestError(errors.New("websocket : not an upgrade request"), http.StatusBadRequest)
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, NewRequestError(errors.New("websocket : unsupported version"), http.StatusUpgradeRequired)
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if bb, err := base64.StdEncoding.DecodeString(key); err != nil || len(bb) != 16 {
+		return nil, NewRequestError(errors.New("websocket : invalid key"), http.StatusBadRequest)
+	}
+
+	var subprotocol string
+	for _, offer := range headerTokens(r.Header, "Sec-WebSocket-Protocol") {
+		for _, p := range cfg.Subprotocols {
+			if subprotocol == "" && strings.EqualFold(offer, p) {
+				subprotocol = p
+			}
+		}
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("websocket : response writer is not a hijacker")
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, errors.Wrap(err, "websocket : hijack")
+	}
+	conn.SetDeadline(time.Time{}) //... clear those of http.Server.
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n"
+	if subprotocol != "" {
+		resp += "Sec-WebSocket-Protocol: " + subprotocol + "\r\n"
+	}
+	conn.SetWriteDeadline(time.Now().Add(cfg.WriteTimeout))
+	if _, err := io.WriteString(conn, resp+"\r\n"); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "websocket : handshake")
+	}
+	conn.SetWriteDeadline(time.Time{})
+
+	ws := WebSocket{
+		conn:        conn,
+		br:          brw.Reader,
+		cfg:         cfg,
+		subprotocol: subprotocol,
+		done:        make(chan struct{}),
+	}
+	if cfg.PingInterval > 0 {
+		conn.SetReadDeadline(time.Now().Add(2 * cfg.PingInterval))
+		go ws.ping()
+	}
+	return &ws, nil
+}
+
+// Subprotocol returns that negotiated at handshake, else empty.
+func (ws *WebSocket) Subprotocol() string {
+	return ws.subprotocol
+}
+
+// RemoteAddr returns the network address of the peer.
+func (ws *WebSocket) RemoteAddr() net.Addr {
+	return ws.conn.RemoteAddr()
+}
+
+// ReadMessage returns the type and payload of the next data message,
+// replying to pings and the close handshake along the way.
+// Upon close, the error is a *CloseError; its code is that sent by peer,
+// or that sent to peer on protocol violation.
+func (ws *WebSocket) ReadMessage() (int, []byte, error) {
+	var (
+		typ int
+		msg []byte
+	)
+	for {
+		fin, op, payload, err := ws.readFrame()
+		if err != nil {
+			return 0, nil, ws.fail(err)
+		}
+		if ws.cfg.PingInterval > 0 {
+			ws.conn.SetReadDeadline(time.Now().Add(2 * ws.cfg.PingInterval))
+		}
+
+		switch op {
+		case opPing:
+			if err := ws.writeFrame(opPong, payload); err != nil && err != ErrWebSocketClosed {
+				return 0, nil, ws.fail(err)
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, ws.closed(payload)
+		case opContinuation:
+			if typ == 0 {
+				return 0, nil, ws.fail(&CloseError{CloseProtocolError, "unexpected continuation"})
+			}
+		case TextMessage, BinaryMessage:
+			if typ != 0 {
+				return 0, nil, ws.fail(&CloseError{CloseProtocolError, "expected continuation"})
+			}
+			typ = op
+		default:
+			return 0, nil, ws.fail(&CloseError{CloseProtocolError, "unknown opcode"})
+		}
+
+		if int64(len(msg))+int64(len(payload)) > ws.cfg.MaxMessageSize {
+			return 0, nil, ws.fail(&CloseError{CloseMessageTooBig, "message too big"})
+		}
+		msg = append(msg, payload...)
+		if !fin {
+			continue
+		}
+		if typ == TextMessage && !utf8.Valid(msg) {
+			return 0, nil, ws.fail(&CloseError{CloseInvalidPayload, "invalid utf-8"})
+		}
+		return typ, msg, nil
+	}
+}
+
+// ReadJSON reads the next message and decodes it (JSON) into the provided pointer (ptr).
+func (ws *WebSocket) ReadJSON(ptr interface{}) error {
+	_, bb, err := ws.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bb, ptr)
+}
+
+// WriteMessage sends payload as one message of type TextMessage or BinaryMessage.
+func (ws *WebSocket) WriteMessage(typ int, payload []byte) error {
+	if typ != TextMessage && typ != BinaryMessage {
+		return errors.New("websocket : invalid message type")
+	}
+	return ws.writeFrame(typ, payload)
+}
+
+// WriteJSON sends v encoded (JSON) as a text message.
+func (ws *WebSocket) WriteJSON(v interface{}) error {
+	bb, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ws.writeFrame(TextMessage, bb)
+}
+
+// Close starts the closing handshake per code and reason.
+// The peer's reply surfaces at ReadMessage as a *CloseError.
+func (ws *WebSocket) Close(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	return ws.writeFrame(opClose, append(payload, reason...))
+}
+
+// end completes the session per handler error (err), and closes the connection.
+func (ws *WebSocket) end(err error) {
+	code, reason := CloseNormal, ""
+	var ce *CloseError
+	switch {
+	case err == nil:
+	case errors.As(err, &ce):
+		code, reason = ce.Code, ce.Reason
+	default:
+		code, reason = CloseInternalError, "internal error"
+	}
+	if code == CloseNoStatus || code == CloseAbnormal {
+		code = CloseNormal
+	}
+	if ws.Close(code, reason) == nil && !ws.recvCl {
+		// Await the peer's close frame, briefly, discarding all else.
+		ws.conn.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			_, op, _, err := ws.readFrame()
+			if err != nil || op == opClose {
+				break
+			}
+		}
+	}
+	ws.stop()
+	ws.conn.Close()
+}
+
+// closed handles the close frame of peer; echoing its code as the RFC requires.
+func (ws *WebSocket) closed(payload []byte) error {
+	ws.recvCl = true
+	ce := &CloseError{Code: CloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		ce = &CloseError{CloseProtocolError, "invalid close payload"}
+	case len(payload) >= 2:
+		ce.Code = int(binary.BigEndian.Uint16(payload))
+		ce.Reason = string(payload[2:])
+		if !validCloseCode(ce.Code) || !utf8.Valid(payload[2:]) {
+			ce = &CloseError{CloseProtocolError, "invalid close payload"}
+		}
+	}
+	code := ce.Code
+	if code == CloseNoStatus {
+		code = CloseNormal
+	}
+	ws.Close(code, "")
+	return ce
+}
+
+// fail sends the close frame of a protocol violation (or drops the connection on I/O error),
+// returning the error as a *CloseError.
+func (ws *WebSocket) fail(err error) error {
+	var ce *CloseError
+	if errors.As(err, &ce) {
+		ws.Close(ce.Code, ce.Reason)
+		return ce
+	}
+	ws.stop()
+	ws.conn.Close()
+	return &CloseError{CloseAbnormal, err.Error()}
+}
+
+// readFrame reads one (client-masked) frame.
+// https://www.rfc-editor.org/rfc/rfc6455#section-5.2
+func (ws *WebSocket) readFrame() (fin bool, op int, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(ws.br, hdr[:]); err != nil {
+		return
+	}
+	fin = hdr[0]&0x80 != 0
+	op = int(hdr[0] & 0x0F)
+	if hdr[0]&0x70 != 0 {
+		return fin, op, nil, &CloseError{CloseProtocolError, "reserved bits set"}
+	}
+	if hdr[1]&0x80 == 0 {
+		return fin, op, nil, &CloseError{CloseProtocolError, "client frame not masked"}
+	}
+	n := int64(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(ws.br, ext[:]); err != nil {
+			return
+		}
+		n = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(ws.br, ext[:]); err != nil {
+			return
+		}
+		u := binary.BigEndian.Uint64(ext[:])
+		if u>>63 != 0 {
+			return fin, op, nil, &CloseError{CloseProtocolError, "invalid length"}
+		}
+		n = int64(u)
+	}
+	if op >= opClose && (!fin || n > 125) {
+		return fin, op, nil, &CloseError{CloseProtocolError, "invalid control frame"}
+	}
+	if n > ws.cfg.MaxMessageSize {
+		return fin, op, nil, &CloseError{CloseMessageTooBig, "message too big"}
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(ws.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(ws.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// writeFrame writes one (unmasked, final) frame; nothing after the close frame.
+func (ws *WebSocket) writeFrame(op int, payload []byte) error {
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+	if ws.sentCl {
+		return ErrWebSocketClosed
+	}
+	if op == opClose {
+		ws.sentCl = true
+	}
+
+	buf := make([]byte, 0, 10+len(payload))
+	buf = append(buf, 0x80|byte(op))
+	switch n := len(payload); {
+	case n <= 125:
+		buf = append(buf, byte(n))
+	case n <= 0xFFFF:
+		buf = append(buf, 126, byte(n>>8), byte(n))
+	default:
+		buf = append(buf, 127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+	buf = append(buf, payload...)
+
+	ws.conn.SetWriteDeadline(time.Now().Add(ws.cfg.WriteTimeout))
+	_, err := ws.conn.Write(buf)
+	return err
+}
+
+// ping sends pings per interval until the session ends.
+func (ws *WebSocket) ping() {
+	t := time.NewTicker(ws.cfg.PingInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ws.done:
+			return
+		case <-t.C:
+			if err := ws.writeFrame(opPing, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (ws *WebSocket) stop() {
+	ws.doneOnce.Do(func() { close(ws.done) })
+}
+
+// validCloseCode reports whether code may be sent in a close frame.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// headerTokens returns the comma-separated tokens of all values of header key.
+func headerTokens(h http.Header, key string) []string {
+	var tt []string
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tt = append(tt, t)
+			}
+		}
+	}
+	return tt
+}
+
+// headerHasToken reports whether header key lists token (case-insensitive).
+func headerHasToken(h http.Header, key, token string) bool {
+	for _, t := range headerTokens(h, key) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// ----------------------------------------------------------------------------
+// App integration
+
+// wsSessions tracks the live WebSocket sessions of an App, for Shutdown(..).
+type wsSessions struct {
+	mu   sync.Mutex
+	live map[*WebSocket]context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+// HandleWebSocket sets a WebSocket handler for a path (GET) to the application server mux.
+// The upgrade request passes through the app's middleware and then that of the route (mw).
+//
+//	svc.HandleWebSocket("/live", web.WebSocketConfig{}, h.Live, mid.Hotlinks(policy), mid.ValidToken(a, auth.KeyRefRefresh))
+func (a *App) HandleWebSocket(path string, cfg WebSocketConfig, handler WebSocketHandler, mw ...Middleware) {
+	h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "kit.web.websocket")
+		defer span.End()
+
+		ws, err := upgrade(w, r, cfg)
+		if err != nil {
+			return err
+		}
+		if v, ok := ValuesFrom(ctx); ok {
+			v.StatusCode = http.StatusSwitchingProtocols
+		}
+
+		// The session outlives the app-wide response timeout, but not App.Shutdown(..).
+		ctx, cancel := context.WithCancel(Detach(ctx))
+		defer cancel()
+		if !a.ws.add(ws, cancel) {
+			ws.end(&CloseError{CloseGoingAway, "server shutdown"})
+			return nil
+		}
+		defer a.ws.remove(ws)
+
+		ws.end(handler(ctx, ws))
+		return nil //... hijacked, so never an error for the chain to respond with.
+	}
+	a.handle(false, http.MethodGet, path, h, mw...)
+}
+
+// Shutdown closes all WebSocket sessions per close code 1001 (Going Away),
+// cancels their contexts, and waits for their handlers to return or ctx to end.
+// The http.Server does not track hijacked connections, so call this alongside
+// its own Shutdown(..).
+func (a *App) Shutdown(ctx context.Context) error {
+	a.ws.mu.Lock()
+	for ws, cancel := range a.ws.live {
+		ws.Close(CloseGoingAway, "server shutdown")
+		cancel()
+	}
+	a.ws.live = nil //... and refuse new sessions.
+	a.ws.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		a.ws.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// add registers a session; false if the app is shutting down.
+func (s *wsSessions) add(ws *WebSocket, cancel context.CancelFunc) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.live == nil {
+		return false
+	}
+	s.live[ws] = cancel
+	s.wg.Add(1)
+	return true
+}
+
+func (s *wsSessions) remove(ws *WebSocket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.live, ws)
+	s.wg.Done()
+}
@@ -0,0 +1,207 @@
+package web_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sempernow/kit/testkit"
+	"github.com/sempernow/kit/web"
+	"github.com/sempernow/kit/web/mid"
+)
+
+// wsClient is a minimal RFC 6455 client; it masks its frames as clients must.
+type wsClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func dialWS(t *testing.T, srv *httptest.Server, path string, hdr ...string) (*wsClient, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	testkit.Log(t, "Dial", err)
+	lines := []string{
+		"GET " + path + " HTTP/1.1", "Host: x", "Upgrade: websocket", "Connection: Upgrade",
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==", "Sec-WebSocket-Version: 13",
+	}
+	for _, h := range hdr { //... replaces a default of the same name.
+		name, _, _ := strings.Cut(h, ":")
+		for i, l := range lines {
+			if strings.HasPrefix(l, name+":") {
+				lines = append(lines[:i], lines[i+1:]...)
+				break
+			}
+		}
+		lines = append(lines, h)
+	}
+	io.WriteString(conn, strings.Join(lines, "\r\n")+"\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	testkit.Log(t, "Read handshake response", err)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &wsClient{conn, br}, resp
+}
+
+func (c *wsClient) send(fin bool, op byte, payload []byte) {
+	b0 := op
+	if fin {
+		b0 |= 0x80
+	}
+	buf := []byte{b0}
+	switch n := len(payload); {
+	case n <= 125:
+		buf = append(buf, 0x80|byte(n))
+	case n <= 0xFFFF:
+		buf = append(buf, 0x80|126, byte(n>>8), byte(n))
+	default:
+		buf = append(buf, 0x80|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+	mask := []byte{1, 2, 3, 4}
+	buf = append(buf, mask...)
+	for i, b := range payload {
+		buf = append(buf, b^mask[i%4])
+	}
+	c.conn.Write(buf)
+}
+
+func (c *wsClient) recv() (op byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(c.br, hdr[:]); err != nil {
+		return
+	}
+	n := int(hdr[1] & 0x7F)
+	if n == 126 {
+		var ext [2]byte
+		io.ReadFull(c.br, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload = make([]byte, n)
+	_, err = io.ReadFull(c.br, payload)
+	return hdr[0] & 0x0F, payload, err
+}
+
+func closeCode(payload []byte) int {
+	if len(payload) < 2 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(payload))
+}
+
+func TestWebSocket(t *testing.T) {
+	app := web.NewApp(make(chan os.Signal, 1), mid.Errors(log.New(io.Discard, "", 0)))
+	echo := func(ctx context.Context, ws *web.WebSocket) error {
+		for {
+			typ, msg, err := ws.ReadMessage()
+			if err != nil {
+				return err
+			}
+			if err := ws.WriteMessage(typ, msg); err != nil {
+				return err
+			}
+		}
+	}
+	var forbidden web.Middleware = func(next web.Handler) web.Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			if r.Header.Get("Origin") == "https://evil.net" {
+				w.WriteHeader(http.StatusForbidden)
+				return nil
+			}
+			return next(ctx, w, r)
+		}
+	}
+	app.HandleWebSocket("/echo", web.WebSocketConfig{MaxMessageSize: 1024, Subprotocols: []string{"v1"}}, echo, forbidden)
+	srv := httptest.NewServer(app)
+	defer srv.Close()
+
+	t.Log("@ Handshake")
+	{
+		c, resp := dialWS(t, srv, "/echo", "Sec-WebSocket-Protocol: v0, v1")
+		defer c.conn.Close()
+		testkit.LogDiff(t, "HTTP 101", resp.StatusCode, http.StatusSwitchingProtocols)
+		testkit.LogDiff(t, "Sec-WebSocket-Accept", resp.Header.Get("Sec-WebSocket-Accept"), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+		testkit.LogDiff(t, "Subprotocol", resp.Header.Get("Sec-WebSocket-Protocol"), "v1")
+
+		t.Log("@ Echo, fragmented, ping")
+		c.send(true, web.TextMessage, []byte("hello"))
+		op, msg, err := c.recv()
+		testkit.Log(t, "Receive", err)
+		testkit.LogDiff(t, "Echo", string(msg), "hello")
+		testkit.LogDiff(t, "Text", op, byte(web.TextMessage))
+
+		c.send(false, web.BinaryMessage, []byte("ab"))
+		c.send(true, 0x9, []byte("p")) //... control frame amid fragments.
+		c.send(true, 0x0, []byte("cd"))
+		op, msg, _ = c.recv()
+		testkit.LogDiff(t, "Pong", op, byte(0xA))
+		testkit.LogDiff(t, "Pong payload", string(msg), "p")
+		op, msg, _ = c.recv()
+		testkit.LogDiff(t, "Reassembled", string(msg), "abcd")
+		testkit.LogDiff(t, "Binary", op, byte(web.BinaryMessage))
+
+		t.Log("@ Close handshake")
+		c.send(true, 0x8, []byte{0x03, 0xE8})
+		op, msg, _ = c.recv()
+		testkit.LogDiff(t, "Close echoed", op, byte(0x8))
+		testkit.LogDiff(t, "Close code", closeCode(msg), web.CloseNormal)
+	}
+	t.Log("@ Message size limit")
+	{
+		c, _ := dialWS(t, srv, "/echo")
+		defer c.conn.Close()
+		c.send(true, web.BinaryMessage, make([]byte, 2048))
+		op, msg, _ := c.recv()
+		testkit.LogDiff(t, "Close", op, byte(0x8))
+		testkit.LogDiff(t, "Close code", closeCode(msg), web.CloseMessageTooBig)
+	}
+	t.Log("@ Invalid UTF-8")
+	{
+		c, _ := dialWS(t, srv, "/echo")
+		defer c.conn.Close()
+		c.send(true, web.TextMessage, []byte{0xff, 0xfe})
+		_, msg, _ := c.recv()
+		testkit.LogDiff(t, "Close code", closeCode(msg), web.CloseInvalidPayload)
+	}
+	t.Log("@ Middleware chain")
+	{
+		c, resp := dialWS(t, srv, "/echo", "Origin: https://evil.net")
+		defer c.conn.Close()
+		testkit.LogDiff(t, "Refused by middleware", resp.StatusCode, http.StatusForbidden)
+
+		c, resp = dialWS(t, srv, "/echo", "Sec-WebSocket-Version: 8")
+		defer c.conn.Close()
+		testkit.LogDiff(t, "HTTP 426", resp.StatusCode, http.StatusUpgradeRequired)
+	}
+	t.Log("@ App shutdown")
+	{
+		c, _ := dialWS(t, srv, "/echo")
+		defer c.conn.Close()
+		c.send(true, web.TextMessage, []byte("x"))
+		c.recv() //... session is live.
+
+		done := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			done <- app.Shutdown(ctx)
+		}()
+		op, msg, _ := c.recv()
+		testkit.LogDiff(t, "Close", op, byte(0x8))
+		testkit.LogDiff(t, "Going away", closeCode(msg), web.CloseGoingAway)
+		c.send(true, 0x8, msg[:2])
+		testkit.Log(t, "Shutdown", <-done)
+
+		_, _, err := c.recv()
+		testkit.LogDiff(t, "Connection closed", errors.Is(err, io.EOF), true)
+	}
+}